@@ -7,6 +7,8 @@ import (
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/logging"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/memorystore"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers/apiserver"
 	"github.com/rs/zerolog/log"
 )
 
@@ -28,6 +30,22 @@ func main() {
 
 	log.Info().Msg("Starting MCB API Server")
 
+	shutdownTracer, err := tracing.InitTracerFromConfig(context.Background(), "mcb-api")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	defer func() {
+		_ = shutdownTracer(context.Background())
+	}()
+
+	shutdownMetrics, err := tracing.InitMetricsFromConfig(context.Background(), "mcb-api")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize metrics")
+	}
+	defer func() {
+		_ = shutdownMetrics(context.Background())
+	}()
+
 	log.Info().Msg("Initializing database connection pool")
 	apierr := dbservice.InitDbPool(context.Background())
 	if apierr != nil {
@@ -37,10 +55,31 @@ func main() {
 	defer dbservice.ClosePool()
 	log.Info().Msg("Database connection pool initialized")
 
+	apierr = dbservice.CheckSchemaVersion(context.Background())
+	if apierr != nil {
+		log.Fatal().Err(apierr).Msg("Unsupported database schema, run backend migrate up")
+	}
+
 	// Setup the memory store
 	log.Info().Msg("Initializing memory store")
 	memorystore.Init()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Keep the memory store and websocket clients current. The change feed loads the memory store itself whenever it
+	// connects, the queue feed needs it loaded once up front.
+	if apiserver.ChangeFeedSource() == apiserver.ChangeFeedPostgres {
+		go apiserver.RunCheckboxChangeFeed(ctx)
+	} else {
+		log.Info().Msg("Loading memory store")
+		apierr = memorystore.LoadCheckboxesFromStore(ctx)
+		if apierr != nil {
+			log.Fatal().Err(apierr).Msg("Failed to load memory store")
+		}
+	}
+	go apiserver.RunCheckboxUpdateConsumer(ctx)
+
 	// Setup router with middleware
 	log.Info().Msg("Setting up HTTP router")
 	r := api.SetupRouter()
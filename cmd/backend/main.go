@@ -2,6 +2,10 @@ package main
 
 import (
 	"context"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/logging"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers/backend"
 	"github.com/rs/zerolog/log"
@@ -19,20 +23,110 @@ const (
 
 // launches the backend server, which publishes and consumes to the queue, and updates the postgresql db
 func main() {
+	// Initialize configuration
+	err := config.InitConfig()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize configuration")
+	}
+
+	// Initialize logging system from environment variables
+	err = logging.InitLoggerFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize logging system")
+	}
+
+	// backend migrate ... applies the migrations and exits, rather than starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	log.Info().Msg("Starting MCB Backend Server")
+
+	shutdownTracer, err := tracing.InitTracerFromConfig(context.Background(), "mcb-backend")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize tracing")
+	}
+	defer func() {
+		// flush the spans of the drained messages, after everything else has stopped
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracer(flushCtx)
+	}()
+
+	shutdownMetrics, err := tracing.InitMetricsFromConfig(context.Background(), "mcb-backend")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize metrics")
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownMetrics(flushCtx)
+	}()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	log.Info().Msg("Initializing database connection pool")
+	apierr := dbservice.InitDbPool(ctx)
+	if apierr != nil {
+		log.Fatal().Err(apierr).Msg("Failed to initialize database connection pool")
+	}
+	defer dbservice.ClosePool()
+	log.Info().Msg("Database connection pool initialized")
+
+	apierr = dbservice.CheckSchemaVersion(ctx)
+	if apierr != nil {
+		log.Fatal().Err(apierr).Msg("Unsupported database schema, run backend migrate up")
+	}
+
+	// The outbox relay and the maintenance jobs, which include the metrics, run on the elected leader only, so
+	// several backends can run side by side without duplicating that work. They all work on Postgres tables.
+	if dbservice.DatabaseProvider() == dbservice.ProviderPostgres {
+		election := dbservice.NewLeaderElection("backend", dbservice.LeaderCallbacks{
+			OnStartedLeading: backend.RunLeaderDuties,
+			OnStoppedLeading: func() {
+				log.Info().Msg("No longer the leader, outbox relay and scheduler stopped")
+			},
+		})
+		go election.Run(ctx)
+	} else {
+		// a single node has no one to elect, so relays its own outbox, but the maintenance jobs need postgres
+		log.Info().Msgf("DATABASE_PROVIDER is %s, running the outbox relay without leader election, the scheduler needs postgres so will not run", dbservice.DatabaseProvider())
+		go backend.RunOutboxRelay(ctx)
+	}
+
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// In-flight messages are applied on their own context, so they can finish after ctx stops the pulling
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+	drainTimeout := backend.DrainTimeout()
+
 	// hangs out in a goroutine and blocks until it receives CTRL+C or SIGTERM
 	go func() {
 		sig := <-sigChan
-		log.Info().Msgf("Received signal %v, shutting down gracefully...", sig)
+		log.Info().Msgf("Received signal %v, shutting down gracefully, draining in-flight messages for up to %v...", sig, drainTimeout)
 		cancel()
+		time.AfterFunc(drainTimeout, cancelWork)
 	}()
 
+	// Consume the checkbox action queue until shutdown
+	if config.GetString("BACKEND_APPLY_MODE") == backend.ApplyModePool {
+		backend.RunCheckboxActionWorkerPool(ctx, workCtx)
+	} else {
+		runConsumeLoop(ctx, workCtx)
+	}
+
+	// the deferred ClosePool runs last, once nothing is using the database
+	log.Info().Msg("Drain complete, closing database connection pool")
+}
+
+// runConsumeLoop consumes one batch of the checkbox action queue at a time, sleeping between batches, until ctx is
+// cancelled. Each batch is applied on workCtx.
+func runConsumeLoop(ctx context.Context, workCtx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
@@ -42,7 +136,7 @@ func main() {
 		}
 
 		starttime := time.Now()
-		result := backend.ConsumeCheckboxActionQueue(ctx)
+		result := backend.ConsumeCheckboxActionQueue(ctx, workCtx)
 		endtime := time.Now()
 		runtimeSeconds := int(math.Round(endtime.Sub(starttime).Seconds()))
 
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/rs/zerolog/log"
+)
+
+const migrateUsage = `usage: backend migrate <command>
+
+commands:
+  up          apply all pending migrations
+  down [N]    revert the last N migrations, 1 if not given
+  goto V      migrate up or down to version V, 0 reverts every migration
+  status      show the schema version and the pending migrations`
+
+// runMigrate runs the migrate subcommand, which applies the migrations embedded in the binary, see dbservice.Migrator
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	if dbservice.DatabaseProvider() != dbservice.ProviderPostgres {
+		log.Fatal().Msgf("migrate is for postgres, the %s schema is created when the database is opened", dbservice.DatabaseProvider())
+	}
+
+	ctx := context.Background()
+	migrator, err := dbservice.NewMigrator(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to start migrating")
+	}
+
+	err = runMigrateCommand(ctx, migrator, args)
+	migrator.Close(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msgf("migrate %s failed", args[0])
+	}
+}
+
+func runMigrateCommand(ctx context.Context, migrator *dbservice.Migrator, args []string) error {
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down takes a positive number of migrations to revert, not %q", args[1])
+			}
+			steps = n
+		}
+		return migrator.Down(ctx, steps)
+	case "goto":
+		if len(args) < 2 {
+			return fmt.Errorf("goto needs the version to migrate to")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("goto takes a version number, not %q", args[1])
+		}
+		return migrator.Goto(ctx, version)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(status)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], migrateUsage)
+	}
+}
+
+func printMigrationStatus(status dbservice.MigrationStatus) {
+	switch {
+	case status.Current == nil:
+		fmt.Println("Schema version: none")
+	case status.Current.Dirty:
+		fmt.Printf("Schema version: %d (dirty)\n", status.Current.Version)
+	default:
+		fmt.Printf("Schema version: %d\n", status.Current.Version)
+	}
+	fmt.Printf("Supported version: %d\n", status.Supported)
+
+	if len(status.Pending) == 0 {
+		fmt.Println("No pending migrations")
+		return
+	}
+	fmt.Println("Pending migrations:")
+	for _, migration := range status.Pending {
+		fmt.Printf("  %d_%s\n", migration.Version, migration.Name)
+	}
+}
@@ -86,3 +86,38 @@ resource "aws_cloudwatch_metric_alarm" "service_cpu_low" {
 
   alarm_actions = [aws_appautoscaling_policy.down.arn]
 }
+
+resource "aws_appautoscaling_target" "backend" {
+  service_namespace  = "ecs"
+  resource_id        = "service/${aws_ecs_cluster.main.name}/${aws_ecs_service.backend.name}"
+  scalable_dimension = "ecs:service:DesiredCount"
+  role_arn           = aws_iam_role.ecs_auto_scale_role.arn
+  min_capacity       = 2
+  max_capacity       = 8
+}
+
+# Track the worker utilization the backend publishes from its worker pool autoscaler, adding backend tasks once the
+# backends together want more workers than they're allowed to run. Only the pool apply mode publishes it, so the task
+# definition sets BACKEND_APPLY_MODE=pool.
+resource "aws_appautoscaling_policy" "worker_utilization" {
+  name               = "cb_worker_utilization"
+  service_namespace  = "ecs"
+  resource_id        = "service/${aws_ecs_cluster.main.name}/${aws_ecs_service.backend.name}"
+  scalable_dimension = "ecs:service:DesiredCount"
+  policy_type        = "TargetTrackingScaling"
+
+  target_tracking_scaling_policy_configuration {
+    target_value       = 75
+    scale_in_cooldown  = 300
+    scale_out_cooldown = 60
+
+    customized_metric_specification {
+      metric_name = "WorkerUtilization"
+      namespace   = "MCB/Backend"
+      statistic   = "Average"
+      unit        = "Percent"
+    }
+  }
+
+  depends_on = [aws_appautoscaling_target.backend]
+}
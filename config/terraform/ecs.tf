@@ -45,3 +45,49 @@ resource "aws_ecs_service" "main" {
 
   depends_on = [aws_alb_listener.front_end, aws_iam_role_policy_attachment.ecs-task-execution-role-policy-attachment]
 }
+
+data "template_file" "cb_backend" {
+  template = file("./templates/ecs/cb_backend.json.tpl")
+
+  vars = {
+    backend_image                = var.backend_image
+    fargate_cpu                  = var.fargate_cpu
+    fargate_memory               = var.fargate_memory
+    aws_region                   = var.aws_region
+    environment                  = var.environment
+    database_url                 = var.database_url
+    database_user                = var.database_user
+    database_password_secret_arn = var.database_password_secret_arn
+    checkboxaction_topic_arn     = var.checkboxaction_topic_arn
+    checkboxupdate_topic_arn     = var.checkboxupdate_topic_arn
+    sqs_base_url                 = var.sqs_base_url
+    checkboxaction_queue         = var.checkboxaction_queue
+  }
+}
+
+resource "aws_ecs_task_definition" "backend" {
+  family                   = "cb-backend-task"
+  execution_role_arn       = aws_iam_role.ecs_task_execution_role.arn
+  network_mode             = "awsvpc"
+  requires_compatibilities = ["FARGATE"]
+  cpu                      = var.fargate_cpu
+  memory                   = var.fargate_memory
+  container_definitions    = data.template_file.cb_backend.rendered
+}
+
+# The backend only pulls from the queues and writes to the database, so it sits behind no load balancer
+resource "aws_ecs_service" "backend" {
+  name            = "cb-backend-service"
+  cluster         = aws_ecs_cluster.main.id
+  task_definition = aws_ecs_task_definition.backend.arn
+  desired_count   = var.backend_count
+  launch_type     = "FARGATE"
+
+  network_configuration {
+    security_groups  = [aws_security_group.backend_tasks.id]
+    subnets          = aws_subnet.private.*.id
+    assign_public_ip = true
+  }
+
+  depends_on = [aws_iam_role_policy_attachment.ecs-task-execution-role-policy-attachment]
+}
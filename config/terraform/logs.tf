@@ -12,3 +12,12 @@ resource "aws_cloudwatch_log_stream" "cb_log_stream" {
   name           = "cb-log-stream"
   log_group_name = aws_cloudwatch_log_group.cb_log_group.name
 }
+
+resource "aws_cloudwatch_log_group" "cb_backend_log_group" {
+  name              = "/ecs/cb-backend"
+  retention_in_days = 30
+
+  tags = {
+    Name = "cb-backend-log-group"
+  }
+}
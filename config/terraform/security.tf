@@ -39,3 +39,17 @@ resource "aws_security_group" "ecs_tasks" {
     cidr_blocks = ["0.0.0.0/0"]
   }
 }
+
+# The backend takes no inbound traffic
+resource "aws_security_group" "backend_tasks" {
+  name        = "cb-backend-tasks-security-group"
+  description = "allow outbound access only"
+  vpc_id      = aws_vpc.main.id
+
+  egress {
+    protocol    = "-1"
+    from_port   = 0
+    to_port     = 0
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
@@ -0,0 +1,34 @@
+[
+  {
+    "name": "cb-backend",
+    "image": "${backend_image}",
+    "cpu": ${fargate_cpu},
+    "memory": ${fargate_memory},
+    "networkMode": "awsvpc",
+    "logConfiguration": {
+        "logDriver": "awslogs",
+        "options": {
+          "awslogs-group": "/ecs/cb-backend",
+          "awslogs-region": "${aws_region}",
+          "awslogs-stream-prefix": "ecs"
+        }
+    },
+    "environment": [
+      { "name": "MCBAPI_ENVIRONMENT", "value": "${environment}" },
+      { "name": "MCBAPI_BACKEND_APPLY_MODE", "value": "pool" },
+      { "name": "MCBAPI_LOGGING_FORMAT", "value": "json" },
+      { "name": "MCBAPI_LOGGING_OUTPUT", "value": "stdout" },
+      { "name": "MCBAPI_DATABASE_PROVIDER", "value": "postgres" },
+      { "name": "MCBAPI_DATABASE_URL", "value": "${database_url}" },
+      { "name": "MCBAPI_DATABASE_USER", "value": "${database_user}" },
+      { "name": "MCBAPI_QUEUE_PROVIDER", "value": "aws" },
+      { "name": "MCBAPI_AWS_SNS_CHECKBOXACTION_TOPIC_ARN", "value": "${checkboxaction_topic_arn}" },
+      { "name": "MCBAPI_AWS_SQS_CHECKBOXACTION_BASE_URL", "value": "${sqs_base_url}" },
+      { "name": "MCBAPI_AWS_SQS_CHECKBOXACTION_CONSUMER1", "value": "${checkboxaction_queue}" },
+      { "name": "MCBAPI_AWS_SNS_CHECKBOXUPDATE_TOPIC_ARN", "value": "${checkboxupdate_topic_arn}" }
+    ],
+    "secrets": [
+      { "name": "MCBAPI_DATABASE_PASSWORD", "valueFrom": "${database_password_secret_arn}" }
+    ]
+  }
+]
@@ -54,3 +54,46 @@ variable "fargate_memory" {
   description = "Fargate instance memory to provision (in MiB)"
   default = "2048"
 }
+
+variable "backend_image" {
+  description = "Docker image of the backend to run in the ECS cluster"
+}
+
+variable "backend_count" {
+  description = "Number of backend containers to start with"
+  default = 2
+}
+
+variable "environment" {
+  description = "ENVIRONMENT of the backend, which has no config file so takes its config from the task's environment"
+  default = "prod"
+}
+
+variable "database_url" {
+  description = "Postgres URL of the checkbox database, eg postgres://host:5432/millcheckdb"
+}
+
+variable "database_user" {
+  description = "Database user the backend connects as"
+  default = "mcbuser"
+}
+
+variable "database_password_secret_arn" {
+  description = "ARN of the Secrets Manager secret or SSM parameter holding the database user's password, which the task execution role must be able to read"
+}
+
+variable "checkboxaction_topic_arn" {
+  description = "ARN of the SNS topic the checkbox actions are published to"
+}
+
+variable "checkboxupdate_topic_arn" {
+  description = "ARN of the SNS topic the outbox relay publishes the checkbox updates to"
+}
+
+variable "sqs_base_url" {
+  description = "Base URL of the account's SQS queues, eg https://sqs.us-east-1.amazonaws.com/123456789012/"
+}
+
+variable "checkboxaction_queue" {
+  description = "Name of the SQS queue the backends consume the checkbox actions from"
+}
@@ -0,0 +1,15 @@
+// Package database holds the schema migrations, embedded in the binaries so they can migrate the database themselves,
+// see dbservice.Migrator, and the schema of the SQLite database used instead of Postgres on a single node
+package database
+
+import "embed"
+
+// Migrations holds the files of database/migrations, named NNN_name.up.sql and NNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
+
+// SQLiteSchema creates the tables of the SQLite database, see dbservice.SQLiteCheckboxRepository
+//
+//go:embed sqlite/schema.sql
+var SQLiteSchema string
@@ -0,0 +1,2 @@
+REVOKE SELECT ON SCHEMA_MIGRATIONS FROM MCBUSERROLE
+;
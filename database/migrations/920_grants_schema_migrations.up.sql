@@ -0,0 +1,7 @@
+/*
+ The API server and backend check the schema version in SCHEMA_MIGRATIONS before they start. The table is created
+ by the migration runner, in the migrating user's schema, before it runs the first migration.
+ */
+
+GRANT SELECT ON SCHEMA_MIGRATIONS TO MCBUSERROLE
+;
@@ -0,0 +1,2 @@
+DROP TABLE MCB.CHECKBOX_SNAPSHOT_T
+;
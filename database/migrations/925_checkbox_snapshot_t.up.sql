@@ -0,0 +1,28 @@
+/*
+ CHECKBOX_SNAPSHOT_T
+ Snapshots of the whole board, written on a schedule by the backend. An API server loads the latest one, then only
+ the checkboxes UPDATE_T shows have changed since, rather than all of CHECKBOX_T.
+- SNAPSHOT_VERSION bigint, not null, identity, primary key
+- SNAPSHOT_DATE timestamp with time zone, not null, default now(), the start of the transaction that read the board
+- BOARD_SIZE int, not null, the number of checkboxes
+- CHECKED_COUNT int, not null
+- BOARD bytea, not null, the board as a gzipped bitset
+- INDEXES
+  - PK: SNAPSHOT_VERSION
+ */
+
+CREATE TABLE MCB.CHECKBOX_SNAPSHOT_T (
+    SNAPSHOT_VERSION BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    SNAPSHOT_DATE TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    BOARD_SIZE INT NOT NULL,
+    CHECKED_COUNT INT NOT NULL,
+    BOARD BYTEA NOT NULL
+)
+;
+
+-- already compressed, so not worth Postgres trying again
+ALTER TABLE MCB.CHECKBOX_SNAPSHOT_T ALTER COLUMN BOARD SET STORAGE EXTERNAL
+;
+
+GRANT SELECT, INSERT, DELETE ON MCB.CHECKBOX_SNAPSHOT_T TO MCBUSERROLE
+;
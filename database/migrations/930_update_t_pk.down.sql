@@ -0,0 +1,4 @@
+/*
+ UPDATE_T keeps its ( UPDATE_DATE, REQUEST_ID ) key going down. A batch writes many rows with the same UPDATE_DATE, so
+ once one has run the old key on UPDATE_DATE alone can't be restored, and the composite key does everything it did.
+ */
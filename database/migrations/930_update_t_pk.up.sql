@@ -0,0 +1,11 @@
+/*
+ UPDATE_T
+ Every attempt to change a checkbox now writes a row, so UPDATE_DATE alone is no longer unique.
+- INDEXES
+  - PK: UPDATE_DATE, REQUEST_ID
+ */
+
+ALTER TABLE MCB.UPDATE_T DROP CONSTRAINT UPDATE_T_PKEY
+;
+ALTER TABLE MCB.UPDATE_T ADD PRIMARY KEY ( UPDATE_DATE, REQUEST_ID )
+;
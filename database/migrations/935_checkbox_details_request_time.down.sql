@@ -0,0 +1,2 @@
+ALTER TABLE MCB.CHECKBOX_DETAILS_T DROP COLUMN LAST_REQUEST_TIME
+;
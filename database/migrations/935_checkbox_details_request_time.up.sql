@@ -0,0 +1,9 @@
+/*
+ CHECKBOX_DETAILS_T
+- LAST_REQUEST_TIME timestamp with time zone, not null, default epoch
+  - the time the last applied request was made by the client, used by the last-writer-wins conflict policy
+ */
+
+ALTER TABLE MCB.CHECKBOX_DETAILS_T
+    ADD COLUMN LAST_REQUEST_TIME TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT 'epoch'
+;
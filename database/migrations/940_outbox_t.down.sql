@@ -0,0 +1,2 @@
+DROP TABLE MCB.OUTBOX_T
+;
@@ -0,0 +1,38 @@
+/*
+ OUTBOX_T
+ Checkbox update events, written in the same transaction as the update itself, and relayed to the update topic by
+ the backend. Rows are relayed in OUTBOX_ID order and marked sent afterwards, so delivery is at-least-once.
+- OUTBOX_ID bigint, not null, identity, primary key
+- EVENT_TYPE varchar(50), not null
+- CHECKBOX_NBR int, not null
+- CHECKED boolean, not null
+- UPDATED_BY uuid, not null, (UUIDv7)
+- REQUEST_ID uuid, not null, (UUIDv7)
+- SUCCESS boolean, not null
+- REASON varchar(200), not null
+- EVENT_DATE timestamp with time zone, not null, default now()
+- SENT_DATE timestamp with time zone, null until the event has been published
+- INDEXES
+  - PK: OUTBOX_ID
+  - IX1: OUTBOX_ID where SENT_DATE is null
+ */
+
+CREATE TABLE MCB.OUTBOX_T (
+    OUTBOX_ID BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    EVENT_TYPE VARCHAR(50) NOT NULL,
+    CHECKBOX_NBR INT NOT NULL,
+    CHECKED BOOLEAN NOT NULL,
+    UPDATED_BY UUID NOT NULL,
+    REQUEST_ID UUID NOT NULL,
+    SUCCESS BOOLEAN NOT NULL,
+    REASON VARCHAR(200) NOT NULL,
+    EVENT_DATE TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    SENT_DATE TIMESTAMP WITH TIME ZONE
+)
+;
+
+CREATE INDEX OUTBOX_IX1 ON MCB.OUTBOX_T ( OUTBOX_ID ) WHERE SENT_DATE IS NULL
+;
+
+GRANT SELECT, INSERT, UPDATE ON MCB.OUTBOX_T TO MCBUSERROLE
+;
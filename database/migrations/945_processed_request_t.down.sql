@@ -0,0 +1,2 @@
+DROP TABLE MCB.PROCESSED_REQUEST_T
+;
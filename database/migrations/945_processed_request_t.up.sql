@@ -0,0 +1,22 @@
+/*
+ PROCESSED_REQUEST_T
+ One row per checkbox action request applied by the backend, written in the same transaction as the update, so a
+ redelivered request is detected and skipped rather than applied twice.
+- REQUEST_ID uuid, not null, primary key, (UUIDv7)
+- PROCESSED_DATE timestamp with time zone, not null, default now()
+- INDEXES
+  - PK: REQUEST_ID
+  - IX1: PROCESSED_DATE
+ */
+
+CREATE TABLE MCB.PROCESSED_REQUEST_T (
+    REQUEST_ID UUID NOT NULL PRIMARY KEY,
+    PROCESSED_DATE TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+)
+;
+
+CREATE INDEX PROCESSED_REQUEST_IX1 ON MCB.PROCESSED_REQUEST_T ( PROCESSED_DATE )
+;
+
+GRANT SELECT, INSERT ON MCB.PROCESSED_REQUEST_T TO MCBUSERROLE
+;
@@ -0,0 +1,2 @@
+drop table MCB.JOB_LEASE_T
+;
@@ -0,0 +1,22 @@
+/*
+ JOB_LEASE_T
+ One row per scheduled maintenance job. A backend instance takes the lease before running a job, so only one instance
+ runs each job at a time, and each scheduled run happens only once across all instances.
+- JOB_NAME varchar(50), not null, primary key
+- OWNER varchar(200), not null, the backend instance holding or last holding the lease
+- LEASE_UNTIL timestamp with time zone, not null, the lease is free once this has passed
+- LAST_SCHEDULED_TIME timestamp with time zone, not null, the scheduled time of the last run that took the lease
+- INDEXES
+  - PK: JOB_NAME
+ */
+
+CREATE TABLE MCB.JOB_LEASE_T (
+    JOB_NAME VARCHAR(50) NOT NULL PRIMARY KEY,
+    OWNER VARCHAR(200) NOT NULL,
+    LEASE_UNTIL TIMESTAMP WITH TIME ZONE NOT NULL,
+    LAST_SCHEDULED_TIME TIMESTAMP WITH TIME ZONE NOT NULL
+)
+;
+
+GRANT SELECT, INSERT, UPDATE ON MCB.JOB_LEASE_T TO MCBUSERROLE
+;
@@ -0,0 +1,35 @@
+/*
+ JOB_RUN_T
+ History of scheduled maintenance job runs, one row per run.
+- RUN_ID bigint, not null, identity, primary key
+- JOB_NAME varchar(50), not null
+- OWNER varchar(200), not null, the backend instance that ran the job
+- SCHEDULED_TIME timestamp with time zone, not null
+- START_DATE timestamp with time zone, not null, default now()
+- END_DATE timestamp with time zone, null while the job is running
+- STATUS varchar(20), not null, running, succeeded or failed
+- ROWS_AFFECTED bigint, not null, default 0
+- ERROR_MESSAGE varchar(1000), null unless the job failed
+- INDEXES
+  - PK: RUN_ID
+  - IX1: JOB_NAME, START_DATE
+ */
+
+CREATE TABLE MCB.JOB_RUN_T (
+    RUN_ID BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    JOB_NAME VARCHAR(50) NOT NULL,
+    OWNER VARCHAR(200) NOT NULL,
+    SCHEDULED_TIME TIMESTAMP WITH TIME ZONE NOT NULL,
+    START_DATE TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    END_DATE TIMESTAMP WITH TIME ZONE,
+    STATUS VARCHAR(20) NOT NULL,
+    ROWS_AFFECTED BIGINT NOT NULL DEFAULT 0,
+    ERROR_MESSAGE VARCHAR(1000)
+)
+;
+
+CREATE INDEX JOB_RUN_IX1 ON MCB.JOB_RUN_T ( JOB_NAME, START_DATE )
+;
+
+GRANT SELECT, INSERT, UPDATE ON MCB.JOB_RUN_T TO MCBUSERROLE
+;
@@ -0,0 +1,2 @@
+REVOKE DELETE ON MCB.UPDATE_T, MCB.CLIENT_T, MCB.PROCESSED_REQUEST_T, MCB.OUTBOX_T, MCB.JOB_RUN_T FROM MCBUSERROLE
+;
@@ -0,0 +1,6 @@
+/*
+ The maintenance jobs purge old rows from these tables
+ */
+
+GRANT DELETE ON MCB.UPDATE_T, MCB.CLIENT_T, MCB.PROCESSED_REQUEST_T, MCB.OUTBOX_T, MCB.JOB_RUN_T TO MCBUSERROLE
+;
@@ -0,0 +1,2 @@
+drop table MCB.METRICS_T
+;
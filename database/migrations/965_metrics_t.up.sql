@@ -0,0 +1,35 @@
+/*
+ METRICS_T
+ One row per metrics interval, appended by the backend's compute_metrics job, for the stats API and its charts.
+- INTERVAL_END timestamp with time zone, not null, primary key
+- INTERVAL_START timestamp with time zone, not null
+- CHECKED_COUNT int, not null, checkboxes checked at the end of the interval
+- CHECKS int, not null, checkboxes changed to checked during the interval
+- UNCHECKS int, not null, checkboxes changed to unchecked during the interval
+- FAILED_COUNT int, not null, requests during the interval that were not applied
+- ACTIVE_CLIENTS int, not null, distinct users with a request during the interval
+- QUEUE_DEPTH bigint, not null, approximate number of requests waiting in the queue at the end of the interval, or
+  -1 if the queue could not be asked
+- QUEUE_LAG_MS bigint, null if there were no requests, the longest time a request applied during the interval
+  spent between the API server and the database
+- CREATED_DATE timestamp with time zone, not null, default now()
+- INDEXES
+  - PK: INTERVAL_END
+ */
+
+CREATE TABLE MCB.METRICS_T (
+    INTERVAL_END TIMESTAMP WITH TIME ZONE NOT NULL PRIMARY KEY,
+    INTERVAL_START TIMESTAMP WITH TIME ZONE NOT NULL,
+    CHECKED_COUNT INT NOT NULL,
+    CHECKS INT NOT NULL,
+    UNCHECKS INT NOT NULL,
+    FAILED_COUNT INT NOT NULL,
+    ACTIVE_CLIENTS INT NOT NULL,
+    QUEUE_DEPTH BIGINT NOT NULL,
+    QUEUE_LAG_MS BIGINT,
+    CREATED_DATE TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+)
+;
+
+GRANT SELECT, INSERT ON MCB.METRICS_T TO MCBUSERROLE
+;
@@ -0,0 +1,2 @@
+ALTER TABLE MCB.UPDATE_T DROP COLUMN REQUEST_TIME
+;
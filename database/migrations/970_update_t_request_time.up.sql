@@ -0,0 +1,8 @@
+/*
+ UPDATE_T
+ The time the API server accepted the request, so the time it spent waiting in the queue can be measured.
+- REQUEST_TIME timestamp with time zone, null for updates recorded before this column was added
+ */
+
+ALTER TABLE MCB.UPDATE_T ADD COLUMN REQUEST_TIME TIMESTAMP WITH TIME ZONE
+;
@@ -0,0 +1,4 @@
+DROP TRIGGER CHECKBOX_CHANGE_TRG ON MCB.CHECKBOX_T
+;
+DROP FUNCTION MCB.NOTIFY_CHECKBOX_CHANGE()
+;
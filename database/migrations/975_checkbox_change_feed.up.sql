@@ -0,0 +1,27 @@
+/*
+ CHECKBOX CHANGE FEED
+ Every change to CHECKBOX_T.CHECKED_STATE is sent on the mcb_checkbox_changes notification channel when its
+ transaction commits, which the API servers listen on to keep their memory stores current. A notification is only
+ sent if its transaction commits, but a listener can still miss some while it is disconnected, so it reloads when it
+ reconnects, and reconciles its memory store with CHECKBOX_T every so often.
+ The payload is JSON: {"checkbox_nbr": 456, "checked": true, "changed_at": "..."}
+ */
+
+CREATE FUNCTION MCB.NOTIFY_CHECKBOX_CHANGE() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('mcb_checkbox_changes', json_build_object(
+        'checkbox_nbr', NEW.CHECKBOX_NBR,
+        'checked', NEW.CHECKED_STATE,
+        'changed_at', NOW()
+    )::TEXT);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql
+;
+
+CREATE TRIGGER CHECKBOX_CHANGE_TRG
+    AFTER UPDATE OF CHECKED_STATE ON MCB.CHECKBOX_T
+    FOR EACH ROW
+    WHEN ( OLD.CHECKED_STATE IS DISTINCT FROM NEW.CHECKED_STATE )
+    EXECUTE FUNCTION MCB.NOTIFY_CHECKBOX_CHANGE()
+;
@@ -0,0 +1,2 @@
+DROP TABLE MCB.SCHEMA_COMPATIBILITY_T
+;
@@ -0,0 +1,20 @@
+/*
+ SCHEMA_COMPATIBILITY_T
+ A single row holding the oldest schema version a build must support to run against this schema. The API server and
+ backend accept a schema ahead of their own version as long as they support at least this one, so the tasks of the
+ previous build can still start during a rolling deploy. A migration that an older build can't run against, such as
+ one dropping a column it reads, sets this to its own version.
+- OLDEST_COMPATIBLE_VERSION bigint, not null
+ */
+
+CREATE TABLE MCB.SCHEMA_COMPATIBILITY_T (
+    OLDEST_COMPATIBLE_VERSION BIGINT NOT NULL
+)
+;
+
+-- the builds before this one only run against the exact version they support, so none of them are counted
+INSERT INTO MCB.SCHEMA_COMPATIBILITY_T ( OLDEST_COMPATIBLE_VERSION ) VALUES ( 980 )
+;
+
+GRANT SELECT ON MCB.SCHEMA_COMPATIBILITY_T TO MCBUSERROLE
+;
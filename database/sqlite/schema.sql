@@ -0,0 +1,62 @@
+/*
+ The SQLite equivalents of CHECKBOX_T, CHECKBOX_DETAILS_T, UPDATE_T and OUTBOX_T, for running on a single node
+ without Postgres, see dbservice.SQLiteCheckboxRepository. SQLite has no schemas, partitions, uuid or timestamp types,
+ so the tables are unqualified, uuids are stored as text, and timestamps as unix microseconds.
+ Every statement is idempotent, it runs each time the database is opened.
+ */
+
+CREATE TABLE IF NOT EXISTS CHECKBOX_T (
+    CHECKBOX_NBR INTEGER NOT NULL PRIMARY KEY,
+    CHECKED_STATE BOOLEAN NOT NULL DEFAULT FALSE
+)
+;
+
+CREATE TABLE IF NOT EXISTS CHECKBOX_DETAILS_T (
+    CHECKBOX_NBR INTEGER NOT NULL PRIMARY KEY,
+    INIT_DATE INTEGER NOT NULL,
+    LAST_UPDATED_BY TEXT NOT NULL,
+    LAST_REQUEST_ID TEXT NOT NULL,
+    LAST_UPDATED_DATE INTEGER NOT NULL,
+    LAST_REQUEST_TIME INTEGER NOT NULL DEFAULT 0
+)
+;
+
+CREATE INDEX IF NOT EXISTS CHECKBOX_DETAILS_IX1 ON CHECKBOX_DETAILS_T ( LAST_UPDATED_BY )
+;
+
+/*
+ Every attempt to change a checkbox, as in Postgres. A request is processed at most once, so REQUEST_ID is unique,
+ which does the job of PROCESSED_REQUEST_T.
+ */
+CREATE TABLE IF NOT EXISTS UPDATE_T (
+    UPDATE_DATE INTEGER NOT NULL,
+    CHECKBOX_NBR INTEGER NOT NULL,
+    CHECKED BOOLEAN NOT NULL,
+    UPDATED_BY TEXT NOT NULL,
+    REQUEST_ID TEXT NOT NULL UNIQUE,
+    SUCCESS BOOLEAN NOT NULL,
+    REQUEST_TIME INTEGER
+)
+;
+
+CREATE INDEX IF NOT EXISTS UPDATE_IX1 ON UPDATE_T ( CHECKBOX_NBR, UPDATE_DATE )
+;
+CREATE INDEX IF NOT EXISTS UPDATE_IX2 ON UPDATE_T ( UPDATED_BY )
+;
+
+/*
+ The update events waiting to be published, as in Postgres. There is no purge job without Postgres, so the relay
+ deletes each event once it is published rather than setting a SENT_DATE.
+ */
+CREATE TABLE IF NOT EXISTS OUTBOX_T (
+    OUTBOX_ID INTEGER PRIMARY KEY AUTOINCREMENT,
+    EVENT_TYPE TEXT NOT NULL,
+    CHECKBOX_NBR INTEGER NOT NULL,
+    CHECKED BOOLEAN NOT NULL,
+    UPDATED_BY TEXT NOT NULL,
+    REQUEST_ID TEXT NOT NULL,
+    SUCCESS BOOLEAN NOT NULL,
+    REASON TEXT NOT NULL DEFAULT '',
+    EVENT_DATE INTEGER NOT NULL
+)
+;
@@ -1,6 +1,9 @@
 package api
 
 import (
+	"context"
+	"fmt"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
 	"net/http"
 	"os"
@@ -11,6 +14,7 @@ import (
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/config"
 	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/logging"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/memorystore"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/uuidservice"
@@ -59,8 +63,11 @@ func SetupRouter() *gin.Engine {
 
 	// API endpoints
 	r.GET("/api/v1/checkbox/:checkboxNbr/status", getStatus)
+	r.GET("/api/v1/checkbox/:checkboxNbr/history", getHistory)
 	r.POST("/api/v1/checkbox/:checkboxNbr/check/:userUuid", checkboxCheck)
 	r.POST("/api/v1/checkbox/:checkboxNbr/uncheck/:userUuid", checkboxUncheck)
+	r.GET("/api/v1/stats", getStats)
+	r.GET("/api/v1/stats/history", getStatsHistory)
 
 	r.GET("/web/client", clientPage)
 
@@ -94,6 +101,7 @@ func getServerIp() string {
 func getStatus(c *gin.Context) {
 	logging.LogAPICall(c, "get_status", map[string]any{
 		"checkbox_nbr": c.Param("checkboxNbr"),
+		"consistent":   c.Query("consistent"),
 	})
 
 	checkboxNbr, err := validateCheckboxNumber(c)
@@ -102,8 +110,20 @@ func getStatus(c *gin.Context) {
 		apierror.AbortWithAPIError(c, apiErr)
 		return
 	}
+	consistent, err := validateConsistentRead(c)
+	if err != nil {
+		apiErr := apierror.ValidationError(err.Error())
+		apierror.AbortWithAPIError(c, apiErr)
+		return
+	}
+
+	// a client checking on its own change asks for consistent, so it doesn't see a replica from before it
+	var ctx context.Context = c
+	if consistent {
+		ctx = dbservice.ForcePrimary(c)
+	}
 
-	checked, lastUpdated, apierr := dbservice.GetCheckboxStatus(c, checkboxNbr)
+	checked, lastUpdated, apierr := dbservice.GetCheckboxRepository().GetCheckboxStatus(ctx, checkboxNbr)
 	if apierr != nil {
 		log.Error().Err(err).Msgf("failed to get checkbox status for checkbox %d", checkboxNbr)
 		apierror.AbortWithAPIError(c, apierr)
@@ -120,6 +140,43 @@ func getStatus(c *gin.Context) {
 	return
 }
 
+// getHistory returns the most recent attempts to change a checkbox, newest first, including those the conflict policy
+// did not apply
+func getHistory(c *gin.Context) {
+	logging.LogAPICall(c, "get_history", map[string]any{
+		"checkbox_nbr": c.Param("checkboxNbr"),
+		"limit":        c.Query("limit"),
+	})
+
+	checkboxNbr, err := validateCheckboxNumber(c)
+	if err != nil {
+		apiErr := apierror.ValidationError(err.Error())
+		apierror.AbortWithAPIError(c, apiErr)
+		return
+	}
+	limit, err := validateCheckboxHistoryLimit(c)
+	if err != nil {
+		apiErr := apierror.ValidationError(err.Error())
+		apierror.AbortWithAPIError(c, apiErr)
+		return
+	}
+
+	history, apierr := dbservice.GetCheckboxRepository().GetCheckboxHistory(c, checkboxNbr, limit)
+	if apierr != nil {
+		log.Error().Err(apierr).Msgf("failed to get checkbox history for checkbox %d", checkboxNbr)
+		apierror.AbortWithAPIError(c, apierr)
+		return
+	}
+
+	response := gin.H{
+		"checkbox_nbr": checkboxNbr,
+		"updates":      history,
+	}
+
+	logging.LogAPIResponse(c, "get_history", http.StatusOK, gin.H{"checkbox_nbr": checkboxNbr, "updates": len(history)})
+	c.JSON(http.StatusOK, response)
+}
+
 func checkboxCheck(c *gin.Context) {
 	logging.LogAPICall(c, "checkbox_check", map[string]any{
 		"checkbox_nbr": c.Param("checkboxNbr"),
@@ -140,19 +197,32 @@ func checkboxCheck(c *gin.Context) {
 		return
 	}
 
+	request := conflictpolicy.CheckboxRequest{
+		CheckboxNbr: checkboxNbr,
+		Checked:     true,
+		UserUuid:    userUuid,
+		RequestUuid: requestUuid,
+		RequestTime: time.Now(),
+	}
+	apiErr := checkPolicyPermits(c, request)
+	if apiErr != nil {
+		apierror.AbortWithAPIError(c, apiErr)
+		return
+	}
+
 	payload := queueservice.CheckboxActionPayload{
-		Action:      "check",
+		Action:      queueservice.CheckboxActionChecked,
 		CheckboxNbr: checkboxNbr,
 		UserUuid:    userUuid.String(),
 		RequestUuid: requestUuid.String(),
-		RequestTime: time.Now(),
+		RequestTime: request.RequestTime,
 		UserIp:      c.RemoteIP(),
 		ApiServer:   getServerName(),
 	}
 
 	// Use context-aware queue service call
 	ctx := tracing.PropagateTraceID(c)
-	_, apiErr := queueservice.PublishCheckboxAction(ctx, payload)
+	_, apiErr = queueservice.PublishCheckboxAction(ctx, payload)
 	if apiErr != nil {
 		apierror.AbortWithAPIError(c, apiErr.WithStackTrace())
 		return
@@ -187,19 +257,32 @@ func checkboxUncheck(c *gin.Context) {
 		return
 	}
 
+	request := conflictpolicy.CheckboxRequest{
+		CheckboxNbr: checkboxNbr,
+		Checked:     false,
+		UserUuid:    userUuid,
+		RequestUuid: requestUuid,
+		RequestTime: time.Now(),
+	}
+	apiErr := checkPolicyPermits(c, request)
+	if apiErr != nil {
+		apierror.AbortWithAPIError(c, apiErr)
+		return
+	}
+
 	payload := queueservice.CheckboxActionPayload{
-		Action:      "uncheck",
+		Action:      queueservice.CheckboxActionUnchecked,
 		CheckboxNbr: checkboxNbr,
 		UserUuid:    userUuid.String(),
 		RequestUuid: requestUuid.String(),
-		RequestTime: time.Now(),
+		RequestTime: request.RequestTime,
 		UserIp:      c.RemoteIP(),
 		ApiServer:   getServerName(),
 	}
 
 	// Use context-aware queue service call
 	ctx := tracing.PropagateTraceID(c)
-	_, apiErr := queueservice.PublishCheckboxAction(ctx, payload)
+	_, apiErr = queueservice.PublishCheckboxAction(ctx, payload)
 	if apiErr != nil {
 		apierror.AbortWithAPIError(c, apiErr.WithStackTrace())
 		return
@@ -213,3 +296,32 @@ func checkboxUncheck(c *gin.Context) {
 	logging.LogAPIResponse(c, "checkbox_uncheck", http.StatusOK, response)
 	c.JSON(http.StatusOK, response)
 }
+
+// checkPolicyPermits rejects a request up front if the active conflict policy does not allow it. Whether the checkbox
+// is checked comes from the memory store, so the check stays off the database, apart from the owner_uncheck policy's
+// unchecks of a checked box, which need to know who checked it.
+func checkPolicyPermits(c *gin.Context, request conflictpolicy.CheckboxRequest) apierror.APIError {
+	policy := conflictpolicy.GetPolicy()
+
+	checked, err := memorystore.GetCheckboxStatus(request.CheckboxNbr)
+	if err != nil {
+		return apierror.InternalError(err.Error())
+	}
+	current := conflictpolicy.CheckboxState{Checked: checked}
+
+	if checked && policy.NeedsOwner(request) {
+		var apiErr apierror.APIError
+		current, apiErr = dbservice.GetCheckboxRepository().GetCheckboxState(c, request.CheckboxNbr)
+		if apiErr != nil {
+			log.Error().Err(apiErr).Msgf("failed to get checkbox state for checkbox %d", request.CheckboxNbr)
+			return apiErr
+		}
+	}
+
+	if !policy.Permits(current, request) {
+		return apierror.NewAPIErrorFromCode(apierror.ErrForbidden,
+			fmt.Sprintf("conflict policy %s does not allow checked=%t on checkbox %d", policy.Name(), request.Checked, request.CheckboxNbr))
+	}
+
+	return nil
+}
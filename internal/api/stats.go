@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/logging"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/memorystore"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// getStats returns the live checked count from this API server's memory store, along with the most recent metrics
+// interval computed by the backend
+func getStats(c *gin.Context) {
+	logging.LogAPICall(c, "get_stats", map[string]any{})
+
+	checked, total := memorystore.CheckedCount()
+	percentChecked := 0.0
+	if total > 0 {
+		percentChecked = 100 * float64(checked) / float64(total)
+	}
+
+	latest, found, apierr := dbservice.GetLatestMetrics(c)
+	if apierr != nil {
+		log.Error().Err(apierr).Msg("failed to get latest metrics")
+		apierror.AbortWithAPIError(c, apierr)
+		return
+	}
+
+	response := gin.H{
+		"checked_count":   checked,
+		"total_count":     total,
+		"percent_checked": percentChecked,
+		"latest_interval": nil,
+	}
+	if found {
+		response["latest_interval"] = latest
+	}
+
+	logging.LogAPIResponse(c, "get_stats", http.StatusOK, response)
+	c.JSON(http.StatusOK, response)
+}
+
+// getStatsHistory returns the metrics intervals between the from and to query params, oldest first, for charts
+func getStatsHistory(c *gin.Context) {
+	logging.LogAPICall(c, "get_stats_history", map[string]any{
+		"from":  c.Query("from"),
+		"to":    c.Query("to"),
+		"limit": c.Query("limit"),
+	})
+
+	from, to, limit, err := validateStatsHistoryParams(c, time.Now())
+	if err != nil {
+		apiErr := apierror.ValidationError(err.Error())
+		apierror.AbortWithAPIError(c, apiErr)
+		return
+	}
+
+	history, apierr := dbservice.GetMetricsHistory(c, from, to, limit)
+	if apierr != nil {
+		log.Error().Err(apierr).Msgf("failed to get metrics history from %v to %v", from, to)
+		apierror.AbortWithAPIError(c, apierr)
+		return
+	}
+
+	response := gin.H{
+		"from":      from,
+		"to":        to,
+		"intervals": history,
+	}
+
+	logging.LogAPIResponse(c, "get_stats_history", http.StatusOK, gin.H{"from": from, "to": to, "intervals": len(history)})
+	c.JSON(http.StatusOK, response)
+}
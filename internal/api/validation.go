@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func validateCheckboxNumber(c *gin.Context) (int, error) {
@@ -60,3 +61,87 @@ func validateParams(c *gin.Context) (int, uuid.UUID, error) {
 
 	return checkboxNbr, userUuid, nil
 }
+
+const (
+	defaultStatsHistoryLimit = 288 // a day of the default 5 minute intervals
+	maxStatsHistoryLimit     = 2016
+	defaultStatsHistoryRange = 24 * time.Hour
+)
+
+// validateStatsHistoryParams reads the optional from and to RFC3339 times, and the limit on the number of intervals.
+// By default the range is the last day, up to now.
+func validateStatsHistoryParams(c *gin.Context, now time.Time) (time.Time, time.Time, int, error) {
+	to := now
+	if toStr := strings.TrimSpace(c.Query("to")); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("validation error: 'to' time '%s' is not a valid RFC3339 time", toStr)
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultStatsHistoryRange)
+	if fromStr := strings.TrimSpace(c.Query("from")); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("validation error: 'from' time '%s' is not a valid RFC3339 time", fromStr)
+		}
+		from = parsed
+	}
+
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, 0, errors.New("validation error: 'from' time must be before 'to' time")
+	}
+
+	limit := defaultStatsHistoryLimit
+	if limitStr := strings.TrimSpace(c.Query("limit")); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("validation error: Limit '%s' is not a valid integer", limitStr)
+		}
+		if parsed <= 0 || parsed > maxStatsHistoryLimit {
+			return time.Time{}, time.Time{}, 0, fmt.Errorf("validation error: Limit '%d' is out of range (1 - %d)", parsed, maxStatsHistoryLimit)
+		}
+		limit = parsed
+	}
+
+	return from, to, limit, nil
+}
+
+const (
+	defaultCheckboxHistoryLimit = 20
+	maxCheckboxHistoryLimit     = 100
+)
+
+// validateCheckboxHistoryLimit reads the optional limit on the number of updates returned from a checkbox's history
+func validateCheckboxHistoryLimit(c *gin.Context) (int, error) {
+	limitStr := strings.TrimSpace(c.Query("limit"))
+	if limitStr == "" {
+		return defaultCheckboxHistoryLimit, nil
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		return 0, fmt.Errorf("validation error: Limit '%s' is not a valid integer", limitStr)
+	}
+	if limit <= 0 || limit > maxCheckboxHistoryLimit {
+		return 0, fmt.Errorf("validation error: Limit '%d' is out of range (1 - %d)", limit, maxCheckboxHistoryLimit)
+	}
+
+	return limit, nil
+}
+
+// validateConsistentRead reads the optional consistent flag, which asks for a read from the primary database rather
+// than a replica that may not have the latest changes yet
+func validateConsistentRead(c *gin.Context) (bool, error) {
+	consistentStr := strings.TrimSpace(c.Query("consistent"))
+	if consistentStr == "" {
+		return false, nil
+	}
+
+	consistent, err := strconv.ParseBool(consistentStr)
+	if err != nil {
+		return false, fmt.Errorf("validation error: Consistent '%s' is not a valid boolean", consistentStr)
+	}
+	return consistent, nil
+}
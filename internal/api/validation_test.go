@@ -6,6 +6,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestValidationCheckboxNumber(t *testing.T) {
@@ -273,3 +274,222 @@ func TestValidateUserUuid(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateStatsHistoryParams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Date(2025, time.January, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		query         string
+		expectError   bool
+		errorContains string
+		expectedFrom  time.Time
+		expectedTo    time.Time
+		expectedLimit int
+	}{
+		{
+			name:          "Defaults to the last day",
+			query:         "",
+			expectError:   false,
+			expectedFrom:  now.Add(-24 * time.Hour),
+			expectedTo:    now,
+			expectedLimit: 288,
+		},
+		{
+			name:          "Explicit range and limit",
+			query:         "from=2025-01-14T00:00:00Z&to=2025-01-14T06:00:00Z&limit=72",
+			expectError:   false,
+			expectedFrom:  time.Date(2025, time.January, 14, 0, 0, 0, 0, time.UTC),
+			expectedTo:    time.Date(2025, time.January, 14, 6, 0, 0, 0, time.UTC),
+			expectedLimit: 72,
+		},
+		{
+			name:          "Only to, from defaults to a day earlier",
+			query:         "to=2025-01-10T00:00:00Z",
+			expectError:   false,
+			expectedFrom:  time.Date(2025, time.January, 9, 0, 0, 0, 0, time.UTC),
+			expectedTo:    time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC),
+			expectedLimit: 288,
+		},
+		{
+			name:          "Invalid from",
+			query:         "from=yesterday",
+			expectError:   true,
+			errorContains: "not a valid RFC3339 time",
+		},
+		{
+			name:          "Invalid to",
+			query:         "to=2025-01-10",
+			expectError:   true,
+			errorContains: "not a valid RFC3339 time",
+		},
+		{
+			name:          "From after to",
+			query:         "from=2025-01-15T11:00:00Z&to=2025-01-15T10:00:00Z",
+			expectError:   true,
+			errorContains: "must be before",
+		},
+		{
+			name:          "Non-integer limit",
+			query:         "limit=lots",
+			expectError:   true,
+			errorContains: "not a valid integer",
+		},
+		{
+			name:          "Zero limit",
+			query:         "limit=0",
+			expectError:   true,
+			errorContains: "out of range",
+		},
+		{
+			name:          "Limit above maximum",
+			query:         "limit=2017",
+			expectError:   true,
+			errorContains: "out of range",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// mock gin context
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+
+			from, to, limit, err := validateStatsHistoryParams(c, now)
+			if tt.expectError {
+				assert.Error(t, err)
+				if tt.errorContains != "" {
+					assert.Contains(t, err.Error(), tt.errorContains)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedFrom, from)
+				assert.Equal(t, tt.expectedTo, to)
+				assert.Equal(t, tt.expectedLimit, limit)
+			}
+		})
+	}
+}
+
+func TestValidateCheckboxHistoryLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		query         string
+		expectError   bool
+		errorContains string
+		expectedLimit int
+	}{
+		{
+			name:          "Default limit",
+			query:         "",
+			expectError:   false,
+			expectedLimit: 20,
+		},
+		{
+			name:          "Maximum limit",
+			query:         "limit=100",
+			expectError:   false,
+			expectedLimit: 100,
+		},
+		{
+			name:          "Invalid limit",
+			query:         "limit=lots",
+			expectError:   true,
+			errorContains: "not a valid integer",
+		},
+		{
+			name:          "Zero limit",
+			query:         "limit=0",
+			expectError:   true,
+			errorContains: "out of range",
+		},
+		{
+			name:          "Limit above maximum",
+			query:         "limit=101",
+			expectError:   true,
+			errorContains: "out of range",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// mock gin context
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+
+			limit, err := validateCheckboxHistoryLimit(c)
+			if tt.expectError {
+				assert.Error(t, err)
+				if tt.errorContains != "" {
+					assert.Contains(t, err.Error(), tt.errorContains)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedLimit, limit)
+			}
+		})
+	}
+}
+
+func TestValidateConsistentRead(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name               string
+		query              string
+		expectError        bool
+		errorContains      string
+		expectedConsistent bool
+	}{
+		{
+			name:               "Default",
+			query:              "",
+			expectError:        false,
+			expectedConsistent: false,
+		},
+		{
+			name:               "Consistent",
+			query:              "consistent=true",
+			expectError:        false,
+			expectedConsistent: true,
+		},
+		{
+			name:               "Not consistent",
+			query:              "consistent=false",
+			expectError:        false,
+			expectedConsistent: false,
+		},
+		{
+			name:          "Invalid",
+			query:         "consistent=very",
+			expectError:   true,
+			errorContains: "not a valid boolean",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// mock gin context
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/?"+tt.query, nil)
+
+			consistent, err := validateConsistentRead(c)
+			if tt.expectError {
+				assert.Error(t, err)
+				if tt.errorContains != "" {
+					assert.Contains(t, err.Error(), tt.errorContains)
+				}
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedConsistent, consistent)
+			}
+		})
+	}
+}
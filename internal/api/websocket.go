@@ -1,24 +1,178 @@
 package api
 
 import (
+	"encoding/json"
+	"sync"
+	"time"
+
 	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+// wsEventResync tells clients they may have missed updates, and should fetch the full state again
+const wsEventResync = "resync"
+
+const (
+	wsSendBufferSize = 256              // updates buffered per client before it is considered too slow
+	wsWriteTimeout   = 10 * time.Second // max time to write a single update to a client
 )
 
+// wsClient is a single connected websocket client, with its own buffered queue of outgoing updates. userUuid is the
+// user it connected as, if any, which it gets the results of its own requests for.
+type wsClient struct {
+	conn     *websocket.Conn
+	send     chan []byte
+	userUuid string
+}
+
+// wsHub tracks all connected websocket clients on this API server, and fans out updates to them
+type wsHub struct {
+	mu      sync.RWMutex
+	clients map[*wsClient]struct{}
+}
+
+var hub = &wsHub{
+	clients: make(map[*wsClient]struct{}),
+}
+
+func (h *wsHub) register(client *wsClient) {
+	h.mu.Lock()
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *wsHub) unregister(client *wsClient) {
+	h.mu.Lock()
+	if _, ok := h.clients[client]; ok {
+		delete(h.clients, client)
+		close(client.send)
+	}
+	h.mu.Unlock()
+}
+
+func (h *wsHub) broadcast(message []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		client.queue(message)
+	}
+}
+
+// sendToUser sends to just the clients connected as the given user
+func (h *wsHub) sendToUser(userUuid string, message []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		if client.userUuid == userUuid {
+			client.queue(message)
+		}
+	}
+}
+
+// queue is a non-blocking send, a client that can't keep up is dropped rather than slowing down everyone else
+func (c *wsClient) queue(message []byte) {
+	select {
+	case c.send <- message:
+	default:
+		log.Warn().Msgf("websocket client %s is too slow, closing connection", c.conn.RemoteAddr())
+		go c.conn.Close()
+	}
+}
+
+// BroadcastCheckboxUpdate sends an update to every websocket client connected to this API server, so it must not
+// carry anything that identifies the user who made it
+func BroadcastCheckboxUpdate(update any) apierror.APIError {
+	message, err := marshalUpdate(update)
+	if err != nil {
+		return err
+	}
+
+	hub.broadcast(message)
+	return nil
+}
+
+// SendCheckboxUpdateToUser sends an update to the websocket clients on this API server connected as the given user
+func SendCheckboxUpdateToUser(userUuid string, update any) apierror.APIError {
+	message, err := marshalUpdate(update)
+	if err != nil {
+		return err
+	}
+
+	hub.sendToUser(userUuid, message)
+	return nil
+}
+
+func marshalUpdate(update any) ([]byte, apierror.APIError) {
+	message, err := json.Marshal(update)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal websocket update to JSON")
+		return nil, apierror.WrapWithCodeFromConstants(err, apierror.ErrInternalServer, "failed to marshal websocket update to JSON")
+	}
+	return message, nil
+}
+
+// BroadcastResync tells every websocket client connected to this API server to fetch the full state again
+func BroadcastResync() apierror.APIError {
+	return BroadcastCheckboxUpdate(gin.H{
+		"event_type": wsEventResync,
+	})
+}
+
+// wsGetAllCheckboxes streams checkbox changes to the client. A client that connects with ?user_uuid= also gets the
+// results of that user's requests.
 func wsGetAllCheckboxes(ctx *gin.Context) {
 	var upgrader = websocket.Upgrader{}
 
+	userUuid := ctx.Query("user_uuid")
+	if userUuid != "" {
+		parsed, err := uuid.Parse(userUuid)
+		if err != nil {
+			apierror.AbortWithAPIError(ctx, apierror.ValidationError("user_uuid is not a valid UUID"))
+			return
+		}
+		userUuid = parsed.String()
+	}
+
 	w, r := ctx.Writer, ctx.Request
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		apiErr := apierror.InternalError("Failed to generate client UUID")
+		apiErr := apierror.InternalError("Failed to upgrade websocket connection")
 		apierror.AbortWithAPIError(ctx, apiErr.WithStackTrace())
 		return
 	}
 	defer conn.Close()
 
+	client := &wsClient{
+		conn:     conn,
+		send:     make(chan []byte, wsSendBufferSize),
+		userUuid: userUuid,
+	}
+	hub.register(client)
+	defer hub.unregister(client)
+
+	go client.writeUpdates()
+
+	// We don't expect anything from the client, but we have to read to notice when it goes away
 	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			log.Debug().Err(err).Msgf("websocket client %s disconnected", conn.RemoteAddr())
+			return
+		}
+	}
+}
 
+func (c *wsClient) writeUpdates() {
+	for message := range c.send {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			log.Debug().Err(err).Msgf("failed to write update to websocket client %s", c.conn.RemoteAddr())
+			c.conn.Close()
+			return
+		}
 	}
 }
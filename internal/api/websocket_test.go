@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebsocketSendToUser(t *testing.T) {
+	// the error middleware reads the config to decide how much of an error to show
+	require.NoError(t, apiconfig.InitConfigWithFolder("../../config/", ""))
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(apierror.ErrorHandlingMiddleware())
+	router.GET("/ws", wsGetAllCheckboxes)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	const user = "550e8400-e29b-41d4-a716-446655440000"
+	owner, _, err := websocket.DefaultDialer.Dial(url+"?user_uuid="+user, nil)
+	require.NoError(t, err)
+	defer owner.Close()
+	other, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer other.Close()
+
+	_, response, err := websocket.DefaultDialer.Dial(url+"?user_uuid=nope", nil)
+	require.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+
+	// the clients are registered once the upgrade returns on the server
+	require.Eventually(t, func() bool {
+		hub.mu.RLock()
+		defer hub.mu.RUnlock()
+		return len(hub.clients) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	require.Nil(t, SendCheckboxUpdateToUser(user, gin.H{"event_type": "request_complete"}))
+	require.Nil(t, BroadcastCheckboxUpdate(gin.H{"event_type": "checkbox_changed"}))
+
+	assert.Equal(t, `{"event_type":"request_complete"}`, readMessage(t, owner))
+	assert.Equal(t, `{"event_type":"checkbox_changed"}`, readMessage(t, owner))
+	assert.Equal(t, `{"event_type":"checkbox_changed"}`, readMessage(t, other), "only the owner gets its request's result")
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	return string(message)
+}
@@ -0,0 +1,126 @@
+package bitset
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+)
+
+// Atomic is a bitset that is safe for concurrent use without a lock. A bit is read with an atomic load of its word,
+// and set with a compare and swap on it, so setting bits that share a word never loses one of them. Reads of more than
+// one word, such as Count, see each word as it was when it was read, not the whole set at one moment.
+type Atomic struct {
+	words []atomic.Uint64
+	size  int
+}
+
+// NewAtomic creates an atomic bitset of size bits, all unset
+func NewAtomic(size int) *Atomic {
+	return &Atomic{
+		words: make([]atomic.Uint64, (size+wordBits-1)/wordBits),
+		size:  size,
+	}
+}
+
+// NewAtomicFrom creates an atomic bitset holding a copy of b's bits
+func NewAtomicFrom(b *Bitset) *Atomic {
+	a := NewAtomic(b.size)
+	for i, word := range b.words {
+		a.words[i].Store(word)
+	}
+	return a
+}
+
+// Len returns the number of bits
+func (a *Atomic) Len() int {
+	return a.size
+}
+
+// Get reports whether bit i is set, i must be in range
+func (a *Atomic) Get(i int) bool {
+	return a.words[i/wordBits].Load()&(1<<(i%wordBits)) != 0
+}
+
+// Set sets or clears bit i, i must be in range, and reports whether it was set before
+func (a *Atomic) Set(i int, value bool) bool {
+	word := &a.words[i/wordBits]
+	mask := uint64(1) << (i % wordBits)
+	for {
+		old := word.Load()
+		updated := old &^ mask
+		if value {
+			updated = old | mask
+		}
+		// no need to write a bit that is already right
+		if updated == old || word.CompareAndSwap(old, updated) {
+			return old&mask != 0
+		}
+	}
+}
+
+// Count returns the number of set bits
+func (a *Atomic) Count() int {
+	count := 0
+	for i := range a.words {
+		count += bits.OnesCount64(a.words[i].Load())
+	}
+	return count
+}
+
+// CountRange returns the number of set bits from start up to end
+func (a *Atomic) CountRange(start, end int) (int, error) {
+	if err := a.checkRange(start, end); err != nil {
+		return 0, err
+	}
+	if start == end {
+		return 0, nil
+	}
+
+	first, last := start/wordBits, (end-1)/wordBits
+	count := 0
+	for w := first; w <= last; w++ {
+		word := a.words[w].Load()
+		if w == first {
+			word &^= 1<<(start%wordBits) - 1
+		}
+		if w == last && end%wordBits != 0 {
+			word &= 1<<(end%wordBits) - 1
+		}
+		count += bits.OnesCount64(word)
+	}
+	return count, nil
+}
+
+// Range returns the bits from start up to end as a []bool
+func (a *Atomic) Range(start, end int) ([]bool, error) {
+	if err := a.checkRange(start, end); err != nil {
+		return nil, err
+	}
+
+	bools := make([]bool, end-start)
+	var word uint64
+	for i := start; i < end; i++ {
+		// a word is loaded once for all of its bits in the range
+		if i == start || i%wordBits == 0 {
+			word = a.words[i/wordBits].Load()
+		}
+		bools[i-start] = word&(1<<(i%wordBits)) != 0
+	}
+	return bools, nil
+}
+
+// ToBitset returns a copy of the bits as a Bitset
+func (a *Atomic) ToBitset() *Bitset {
+	b := New(a.size)
+	for i := range a.words {
+		b.words[i] = a.words[i].Load()
+	}
+	return b
+}
+
+func (a *Atomic) checkRange(start, end int) error {
+	if start < 0 || start > end || end > a.size {
+		return fmt.Errorf("bits %d to %d are out of range of a bitset of %d", start, end, a.size)
+	}
+	return nil
+}
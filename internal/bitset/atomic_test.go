@@ -0,0 +1,129 @@
+package bitset
+
+import (
+	"math/rand/v2"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAtomicSetGet(t *testing.T) {
+	bools := randomBools(1000)
+	a := NewAtomicFrom(FromBools(bools))
+	assert.Equal(t, 1000, a.Len())
+	assert.Equal(t, countTrue(bools), a.Count())
+
+	was := a.Set(10, !bools[10])
+	assert.Equal(t, bools[10], was)
+	assert.Equal(t, !bools[10], a.Get(10))
+	assert.Equal(t, !bools[10], a.Set(10, !bools[10]), "setting it again leaves it alone")
+
+	bools[10] = !bools[10]
+	assert.Equal(t, bools, a.ToBitset().ToBools())
+}
+
+func TestAtomicRanges(t *testing.T) {
+	bools := randomBools(1000)
+	a := NewAtomicFrom(FromBools(bools))
+
+	for _, r := range [][2]int{{0, 1000}, {0, 0}, {5, 6}, {3, 60}, {60, 70}, {64, 128}, {100, 999}, {999, 1000}} {
+		got, err := a.Range(r[0], r[1])
+		require.NoError(t, err)
+		assert.Equal(t, bools[r[0]:r[1]], got, "range %v", r)
+
+		count, err := a.CountRange(r[0], r[1])
+		require.NoError(t, err)
+		assert.Equal(t, countTrue(bools[r[0]:r[1]]), count, "range %v", r)
+	}
+
+	for _, r := range [][2]int{{-1, 10}, {10, 5}, {0, 1001}} {
+		_, err := a.Range(r[0], r[1])
+		assert.Error(t, err, "range %v", r)
+		_, err = a.CountRange(r[0], r[1])
+		assert.Error(t, err, "range %v", r)
+	}
+}
+
+func TestAtomicConcurrentSet(t *testing.T) {
+	// every goroutine sets its own bits, which share words with the others', and none may be lost
+	const goroutines = 8
+	a := NewAtomic(64 * 100)
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := g; i < a.Len(); i += goroutines {
+				a.Set(i, true)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, a.Len(), a.Count())
+}
+
+// lockedBitset is the memory store as it was, a Bitset behind a sync.RWMutex, to benchmark Atomic against
+type lockedBitset struct {
+	mu sync.RWMutex
+	b  *Bitset
+}
+
+func (l *lockedBitset) Get(i int) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.b.Get(i)
+}
+
+func (l *lockedBitset) Set(i int, value bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.b.Set(i, value)
+}
+
+// benchmarkMixed runs parallel readers and writers over the board, with writesPerHundred of each hundred operations a
+// write, the rest a read
+func benchmarkMixed(b *testing.B, writesPerHundred int, get func(int) bool, set func(int, bool)) {
+	b.RunParallel(func(pb *testing.PB) {
+		rng := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+		for pb.Next() {
+			i := rng.IntN(boardSize)
+			if rng.IntN(100) < writesPerHundred {
+				set(i, rng.IntN(2) == 0)
+			} else {
+				get(i)
+			}
+		}
+	})
+}
+
+// BenchmarkParallel compares Atomic with the locked bitset, run it with -cpu 1,4,8 to see the lock's contention grow
+func BenchmarkParallel(b *testing.B) {
+	for _, mix := range []struct {
+		name             string
+		writesPerHundred int
+	}{
+		{"reads", 0},
+		{"mostly_reads", 10},
+		{"half_writes", 50},
+		{"writes", 100},
+	} {
+		b.Run(mix.name+"/locked", func(b *testing.B) {
+			l := &lockedBitset{b: New(boardSize)}
+			benchmarkMixed(b, mix.writesPerHundred, l.Get, l.Set)
+		})
+		b.Run(mix.name+"/atomic", func(b *testing.B) {
+			a := NewAtomic(boardSize)
+			benchmarkMixed(b, mix.writesPerHundred, a.Get, func(i int, value bool) { a.Set(i, value) })
+		})
+	}
+}
+
+func BenchmarkAtomicCount(b *testing.B) {
+	a := NewAtomicFrom(FromBools(randomBools(boardSize)))
+	b.ResetTimer()
+	for range b.N {
+		a.Count()
+	}
+}
@@ -0,0 +1,130 @@
+// Package bitset is a fixed size set of bits, an eighth of the size of a []bool, for holding the checkbox board
+package bitset
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+)
+
+const wordBits = 64
+
+// Bitset holds size bits, bit i is bit i%64 of word i/64
+type Bitset struct {
+	words []uint64
+	size  int
+}
+
+// New creates a bitset of size bits, all unset
+func New(size int) *Bitset {
+	return &Bitset{
+		words: make([]uint64, (size+wordBits-1)/wordBits),
+		size:  size,
+	}
+}
+
+// FromBools creates a bitset with bit i set where bools[i] is true
+func FromBools(bools []bool) *Bitset {
+	b := New(len(bools))
+	for i, set := range bools {
+		if set {
+			b.words[i/wordBits] |= 1 << (i % wordBits)
+		}
+	}
+	return b
+}
+
+// Len returns the number of bits
+func (b *Bitset) Len() int {
+	return b.size
+}
+
+// Get reports whether bit i is set, i must be in range
+func (b *Bitset) Get(i int) bool {
+	return b.words[i/wordBits]&(1<<(i%wordBits)) != 0
+}
+
+// Set sets or clears bit i, i must be in range
+func (b *Bitset) Set(i int, value bool) {
+	if value {
+		b.words[i/wordBits] |= 1 << (i % wordBits)
+	} else {
+		b.words[i/wordBits] &^= 1 << (i % wordBits)
+	}
+}
+
+// Count returns the number of set bits
+func (b *Bitset) Count() int {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// ToBools returns the bits as a []bool
+func (b *Bitset) ToBools() []bool {
+	bools := make([]bool, b.size)
+	for i := range bools {
+		bools[i] = b.Get(i)
+	}
+	return bools
+}
+
+// Bytes returns the bits as little endian 64 bit words, the layout FromBytes reads
+func (b *Bitset) Bytes() []byte {
+	data := make([]byte, len(b.words)*8)
+	for i, word := range b.words {
+		binary.LittleEndian.PutUint64(data[i*8:], word)
+	}
+	return data
+}
+
+// FromBytes creates a bitset of size bits from the layout Bytes writes
+func FromBytes(size int, data []byte) (*Bitset, error) {
+	b := New(size)
+	if len(data) != len(b.words)*8 {
+		return nil, fmt.Errorf("%d bytes is not a bitset of %d bits, which is %d bytes", len(data), size, len(b.words)*8)
+	}
+	for i := range b.words {
+		b.words[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	// bits past the end would be counted
+	if extra := size % wordBits; extra != 0 && b.words[len(b.words)-1]>>extra != 0 {
+		return nil, fmt.Errorf("bits are set past the end of a bitset of %d bits", size)
+	}
+	return b, nil
+}
+
+// SetPacked overwrites nbits bits from offset with packed, which holds them most significant bit first, the layout of
+// a Postgres bit string. An offset that is a multiple of 64 is decoded a word at a time, and one that is a multiple
+// of 8 a byte at a time.
+func (b *Bitset) SetPacked(offset int, packed []byte, nbits int) error {
+	if offset < 0 || nbits < 0 || offset+nbits > b.size {
+		return fmt.Errorf("bits %d to %d are out of range of a bitset of %d", offset, offset+nbits, b.size)
+	}
+	if len(packed)*8 < nbits {
+		return fmt.Errorf("%d bytes can't hold %d bits", len(packed), nbits)
+	}
+
+	i := 0
+	if offset%wordBits == 0 {
+		// reversing the big endian word puts the first bit of its first byte in bit 0
+		for ; i+wordBits <= nbits; i += wordBits {
+			b.words[(offset+i)/wordBits] = bits.Reverse64(binary.BigEndian.Uint64(packed[i/8:]))
+		}
+	}
+	if offset%8 == 0 {
+		for ; i+8 <= nbits; i += 8 {
+			pos := offset + i
+			shift := pos % wordBits
+			word := &b.words[pos/wordBits]
+			*word = *word&^(0xff<<shift) | uint64(bits.Reverse8(packed[i/8]))<<shift
+		}
+	}
+	// the bits left over, or all of them when the offset isn't byte aligned
+	for ; i < nbits; i++ {
+		b.Set(offset+i, packed[i/8]&(0x80>>(i%8)) != 0)
+	}
+	return nil
+}
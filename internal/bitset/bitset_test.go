@@ -0,0 +1,150 @@
+package bitset
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const boardSize = 1000000
+
+func TestSetGetCount(t *testing.T) {
+	b := New(130)
+	assert.Equal(t, 130, b.Len())
+	assert.Equal(t, 0, b.Count())
+
+	for _, i := range []int{0, 1, 63, 64, 127, 129} {
+		b.Set(i, true)
+	}
+	b.Set(1, false)
+
+	assert.True(t, b.Get(0))
+	assert.False(t, b.Get(1))
+	assert.True(t, b.Get(63))
+	assert.True(t, b.Get(64))
+	assert.False(t, b.Get(65))
+	assert.True(t, b.Get(129))
+	assert.Equal(t, 5, b.Count())
+}
+
+func TestFromBoolsToBools(t *testing.T) {
+	bools := randomBools(1000)
+	b := FromBools(bools)
+	assert.Equal(t, bools, b.ToBools())
+	assert.Equal(t, countTrue(bools), b.Count())
+}
+
+func TestBytesFromBytes(t *testing.T) {
+	bools := randomBools(1000)
+	b := FromBools(bools)
+
+	loaded, err := FromBytes(1000, b.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, bools, loaded.ToBools())
+
+	_, err = FromBytes(1001, b.Bytes()[:8])
+	assert.Error(t, err)
+
+	// 1000 bits leaves 24 unused bits in the last word
+	data := b.Bytes()
+	data[len(data)-1] = 0x80
+	_, err = FromBytes(1000, data)
+	assert.Error(t, err)
+}
+
+func TestSetPacked(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int
+		nbits  int
+	}{
+		{name: "Word aligned", offset: 64, nbits: 200},
+		{name: "Byte aligned", offset: 24, nbits: 100},
+		{name: "Unaligned", offset: 5, nbits: 77},
+		{name: "Whole bitset", offset: 0, nbits: 512},
+		{name: "Empty", offset: 10, nbits: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the bits around the range must keep their values
+			before := randomBools(512)
+			b := FromBools(before)
+			bools := randomBools(tt.nbits)
+
+			require.NoError(t, b.SetPacked(tt.offset, pack(bools), tt.nbits))
+
+			expected := append([]bool{}, before...)
+			copy(expected[tt.offset:], bools)
+			assert.Equal(t, expected, b.ToBools())
+		})
+	}
+}
+
+func TestSetPackedOutOfRange(t *testing.T) {
+	b := New(100)
+	assert.Error(t, b.SetPacked(96, pack(make([]bool, 8)), 8))
+	assert.Error(t, b.SetPacked(-1, pack(make([]bool, 8)), 8))
+	assert.Error(t, b.SetPacked(0, []byte{0xff}, 9))
+}
+
+// BenchmarkSetPacked decodes a board sent as ten partitions of packed bits, as LoadCheckboxBitset receives it
+func BenchmarkSetPacked(b *testing.B) {
+	const partitions = 10
+	partitionSize := boardSize / partitions
+	packed := pack(randomBools(partitionSize))
+
+	b.ReportAllocs()
+	for b.Loop() {
+		board := New(boardSize)
+		for p := range partitions {
+			if err := board.SetPacked(p*partitionSize, packed, partitionSize); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// BenchmarkSetEach fills a board a checkbox at a time, as the row by row scan of GetFullCheckboxStore does
+func BenchmarkSetEach(b *testing.B) {
+	bools := randomBools(boardSize)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		board := make([]bool, boardSize)
+		for i, checked := range bools {
+			board[i] = checked
+		}
+	}
+}
+
+func randomBools(n int) []bool {
+	bools := make([]bool, n)
+	for i := range bools {
+		bools[i] = rand.IntN(2) == 1
+	}
+	return bools
+}
+
+func countTrue(bools []bool) int {
+	count := 0
+	for _, b := range bools {
+		if b {
+			count++
+		}
+	}
+	return count
+}
+
+// pack packs bools most significant bit first, like a Postgres bit string
+func pack(bools []bool) []byte {
+	packed := make([]byte, (len(bools)+7)/8)
+	for i, set := range bools {
+		if set {
+			packed[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return packed
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/spf13/viper"
 	"os"
 	"strings"
+	"time"
 )
 
 const (
@@ -54,8 +55,9 @@ func InitConfigWithFolder(configfolder string, configfile string) error {
 	}
 	v.SetConfigType("env")
 
-	// Set environment variable prefix and enable automatic env reading
-	v.SetEnvPrefix(EnvPrefix)
+	// Set environment variable prefix and enable automatic env reading. Viper adds the underscore itself, so the
+	// variables are MCBAPI_<KEY>, as ENVIRONMENT is above.
+	v.SetEnvPrefix(strings.TrimSuffix(EnvPrefix, "_"))
 	v.AutomaticEnv()
 	//v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
@@ -102,6 +104,39 @@ func GetStringWithDefault(key, defaultValue string) string {
 	return value
 }
 
+// GetIntWithDefault returns an int configuration value with a default, if the key is not set
+func GetIntWithDefault(key string, defaultValue int) int {
+	if !GetConfig().IsSet(key) {
+		return defaultValue
+	}
+	return GetConfig().GetInt(key)
+}
+
+// GetFloat64WithDefault returns a float64 configuration value with a default, if the key is not set
+func GetFloat64WithDefault(key string, defaultValue float64) float64 {
+	if !GetConfig().IsSet(key) {
+		return defaultValue
+	}
+	return GetConfig().GetFloat64(key)
+}
+
+// GetBoolWithDefault returns a bool configuration value with a default, if the key is not set
+func GetBoolWithDefault(key string, defaultValue bool) bool {
+	if !GetConfig().IsSet(key) {
+		return defaultValue
+	}
+	return GetConfig().GetBool(key)
+}
+
+// GetDurationWithDefault returns a duration configuration value, such as "30s" or "5m", with a default if the key
+// is not set
+func GetDurationWithDefault(key string, defaultValue time.Duration) time.Duration {
+	if !GetConfig().IsSet(key) {
+		return defaultValue
+	}
+	return GetConfig().GetDuration(key)
+}
+
 // DumpConfig prints the entire processed configuration
 func DumpConfig() {
 	fmt.Println("=== Configuration Dump ===")
@@ -0,0 +1,191 @@
+package conflictpolicy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Policy names, as used in the CONFLICT_POLICY config key
+const (
+	PolicyFirstWriterWins = "first_writer_wins"
+	PolicyToggle          = "toggle"
+	PolicyLastWriterWins  = "last_writer_wins"
+	PolicyOwnerUncheck    = "owner_uncheck"
+)
+
+// CheckboxState is the current persisted state of a checkbox, which a request is resolved against
+type CheckboxState struct {
+	Checked         bool
+	LastUpdatedBy   uuid.UUID
+	LastRequestTime time.Time
+}
+
+// CheckboxRequest is a single check or uncheck request made by a user
+type CheckboxRequest struct {
+	CheckboxNbr int
+	Checked     bool
+	UserUuid    uuid.UUID
+	RequestUuid uuid.UUID
+	RequestTime time.Time
+}
+
+// Decision is the outcome of resolving a request against the current state of a checkbox
+type Decision struct {
+	Apply   bool   // whether the request is written to the shared state
+	Checked bool   // the state of the checkbox once the decision is applied
+	Reason  string // human-readable explanation, mostly for logs
+}
+
+// Policy decides how conflicting check and uncheck requests against the same checkbox are handled.
+// Permits is used by the API to reject requests up front, Resolve is used by the backend inside the update transaction.
+// The API only knows whether a checkbox is checked, from its memory store, NeedsOwner says whether Permits also needs
+// the current state's LastUpdatedBy, which has to be read from the database.
+type Policy interface {
+	Name() string
+	Permits(current CheckboxState, request CheckboxRequest) bool
+	NeedsOwner(request CheckboxRequest) bool
+	Resolve(current CheckboxState, request CheckboxRequest) Decision
+}
+
+var (
+	policyInstance Policy
+	policyOnce     sync.Once
+)
+
+// GetPolicy returns the conflict policy selected by the CONFLICT_POLICY config key
+func GetPolicy() Policy {
+	policyOnce.Do(func() {
+		name := apiconfig.GetConfig().GetString("CONFLICT_POLICY")
+		policy, err := NewPolicy(name)
+		if err != nil {
+			// Default to first-writer-wins if not specified or invalid, since that is what goals.md describes
+			log.Warn().Err(err).Msgf("falling back to conflict policy %s", PolicyFirstWriterWins)
+			policy = &firstWriterWins{}
+		}
+		log.Info().Msgf("Using conflict policy %s", policy.Name())
+		policyInstance = policy
+	})
+	return policyInstance
+}
+
+// NewPolicy creates the conflict policy with the given name
+func NewPolicy(name string) (Policy, error) {
+	switch name {
+	case PolicyFirstWriterWins, "":
+		return &firstWriterWins{}, nil
+	case PolicyToggle:
+		return &toggle{}, nil
+	case PolicyLastWriterWins:
+		return &lastWriterWins{}, nil
+	case PolicyOwnerUncheck:
+		return &ownerUncheck{}, nil
+	default:
+		return nil, fmt.Errorf("unknown conflict policy '%s'", name)
+	}
+}
+
+func apply(checked bool) Decision {
+	return Decision{Apply: true, Checked: checked, Reason: "applied"}
+}
+
+func reject(current CheckboxState, reason string) Decision {
+	return Decision{Apply: false, Checked: current.Checked, Reason: reason}
+}
+
+// firstWriterWins lets the first check of a checkbox stick forever, and never allows unchecks
+type firstWriterWins struct{}
+
+func (p *firstWriterWins) Name() string {
+	return PolicyFirstWriterWins
+}
+
+func (p *firstWriterWins) Permits(_ CheckboxState, request CheckboxRequest) bool {
+	return request.Checked
+}
+
+func (p *firstWriterWins) NeedsOwner(_ CheckboxRequest) bool {
+	return false
+}
+
+func (p *firstWriterWins) Resolve(current CheckboxState, request CheckboxRequest) Decision {
+	if !request.Checked {
+		return reject(current, "unchecks are not allowed")
+	}
+	if current.Checked {
+		return reject(current, "already checked")
+	}
+	return apply(true)
+}
+
+// toggle treats every request as a click that flips the checkbox, whichever action the client asked for, since the
+// client's view of the checkbox may be stale by the time the request is processed
+type toggle struct{}
+
+func (p *toggle) Name() string {
+	return PolicyToggle
+}
+
+func (p *toggle) Permits(_ CheckboxState, _ CheckboxRequest) bool {
+	return true
+}
+
+func (p *toggle) NeedsOwner(_ CheckboxRequest) bool {
+	return false
+}
+
+func (p *toggle) Resolve(current CheckboxState, _ CheckboxRequest) Decision {
+	return apply(!current.Checked)
+}
+
+// lastWriterWins applies whichever request was made last by the client, according to RequestTime, regardless of the
+// order in which requests are processed
+type lastWriterWins struct{}
+
+func (p *lastWriterWins) Name() string {
+	return PolicyLastWriterWins
+}
+
+func (p *lastWriterWins) Permits(_ CheckboxState, _ CheckboxRequest) bool {
+	return true
+}
+
+func (p *lastWriterWins) NeedsOwner(_ CheckboxRequest) bool {
+	return false
+}
+
+func (p *lastWriterWins) Resolve(current CheckboxState, request CheckboxRequest) Decision {
+	if !request.RequestTime.After(current.LastRequestTime) {
+		return reject(current, "a later request has already been applied")
+	}
+	return apply(request.Checked)
+}
+
+// ownerUncheck allows anyone to check a checkbox, but only the user that checked it may uncheck it
+type ownerUncheck struct{}
+
+func (p *ownerUncheck) Name() string {
+	return PolicyOwnerUncheck
+}
+
+func (p *ownerUncheck) Permits(current CheckboxState, request CheckboxRequest) bool {
+	return request.Checked || !current.Checked || current.LastUpdatedBy == request.UserUuid
+}
+
+func (p *ownerUncheck) NeedsOwner(request CheckboxRequest) bool {
+	return !request.Checked
+}
+
+func (p *ownerUncheck) Resolve(current CheckboxState, request CheckboxRequest) Decision {
+	if current.Checked == request.Checked {
+		return reject(current, fmt.Sprintf("already checked=%t", current.Checked))
+	}
+	if !request.Checked && current.LastUpdatedBy != request.UserUuid {
+		return reject(current, "only the user that checked it may uncheck it")
+	}
+	return apply(request.Checked)
+}
@@ -0,0 +1,164 @@
+package conflictpolicy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	owner     = uuid.MustParse("01234567-89ab-7def-89ab-123456789abc")
+	otherUser = uuid.MustParse("550e8400-e29b-41d4-a716-446655440000")
+	baseTime  = time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		current         CheckboxState
+		request         CheckboxRequest
+		expectPermitted bool
+		expectApply     bool
+		expectChecked   bool
+	}{
+		{
+			name:            "First writer wins - check unchecked box",
+			policy:          PolicyFirstWriterWins,
+			current:         CheckboxState{Checked: false},
+			request:         CheckboxRequest{Checked: true, UserUuid: otherUser},
+			expectPermitted: true,
+			expectApply:     true,
+			expectChecked:   true,
+		},
+		{
+			name:            "First writer wins - check already checked box",
+			policy:          PolicyFirstWriterWins,
+			current:         CheckboxState{Checked: true, LastUpdatedBy: owner},
+			request:         CheckboxRequest{Checked: true, UserUuid: otherUser},
+			expectPermitted: true,
+			expectApply:     false,
+			expectChecked:   true,
+		},
+		{
+			name:            "First writer wins - uncheck is never allowed",
+			policy:          PolicyFirstWriterWins,
+			current:         CheckboxState{Checked: true, LastUpdatedBy: owner},
+			request:         CheckboxRequest{Checked: false, UserUuid: owner},
+			expectPermitted: false,
+			expectApply:     false,
+			expectChecked:   true,
+		},
+		{
+			name:            "Toggle - check on checked box flips it",
+			policy:          PolicyToggle,
+			current:         CheckboxState{Checked: true},
+			request:         CheckboxRequest{Checked: true, UserUuid: otherUser},
+			expectPermitted: true,
+			expectApply:     true,
+			expectChecked:   false,
+		},
+		{
+			name:            "Toggle - uncheck on unchecked box flips it",
+			policy:          PolicyToggle,
+			current:         CheckboxState{Checked: false},
+			request:         CheckboxRequest{Checked: false, UserUuid: otherUser},
+			expectPermitted: true,
+			expectApply:     true,
+			expectChecked:   true,
+		},
+		{
+			name:            "Last writer wins - newer request applies",
+			policy:          PolicyLastWriterWins,
+			current:         CheckboxState{Checked: true, LastRequestTime: baseTime},
+			request:         CheckboxRequest{Checked: false, RequestTime: baseTime.Add(time.Second)},
+			expectPermitted: true,
+			expectApply:     true,
+			expectChecked:   false,
+		},
+		{
+			name:            "Last writer wins - older request processed late is discarded",
+			policy:          PolicyLastWriterWins,
+			current:         CheckboxState{Checked: true, LastRequestTime: baseTime},
+			request:         CheckboxRequest{Checked: false, RequestTime: baseTime.Add(-time.Second)},
+			expectPermitted: true,
+			expectApply:     false,
+			expectChecked:   true,
+		},
+		{
+			name:            "Owner uncheck - owner may uncheck",
+			policy:          PolicyOwnerUncheck,
+			current:         CheckboxState{Checked: true, LastUpdatedBy: owner},
+			request:         CheckboxRequest{Checked: false, UserUuid: owner},
+			expectPermitted: true,
+			expectApply:     true,
+			expectChecked:   false,
+		},
+		{
+			name:            "Owner uncheck - other user may not uncheck",
+			policy:          PolicyOwnerUncheck,
+			current:         CheckboxState{Checked: true, LastUpdatedBy: owner},
+			request:         CheckboxRequest{Checked: false, UserUuid: otherUser},
+			expectPermitted: false,
+			expectApply:     false,
+			expectChecked:   true,
+		},
+		{
+			name:            "Owner uncheck - anyone may check",
+			policy:          PolicyOwnerUncheck,
+			current:         CheckboxState{Checked: false, LastUpdatedBy: owner},
+			request:         CheckboxRequest{Checked: true, UserUuid: otherUser},
+			expectPermitted: true,
+			expectApply:     true,
+			expectChecked:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(tt.policy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.policy, policy.Name())
+
+			assert.Equal(t, tt.expectPermitted, policy.Permits(tt.current, tt.request))
+
+			decision := policy.Resolve(tt.current, tt.request)
+			assert.Equal(t, tt.expectApply, decision.Apply)
+			assert.Equal(t, tt.expectChecked, decision.Checked)
+			assert.NotEmpty(t, decision.Reason)
+		})
+	}
+}
+
+func TestNeedsOwner(t *testing.T) {
+	// the API leaves LastUpdatedBy unset unless NeedsOwner, which mustn't change what Permits decides
+	for _, name := range []string{PolicyFirstWriterWins, PolicyToggle, PolicyLastWriterWins, PolicyOwnerUncheck} {
+		policy, err := NewPolicy(name)
+		require.NoError(t, err)
+		for _, checked := range []bool{true, false} {
+			request := CheckboxRequest{Checked: checked, UserUuid: otherUser}
+			for _, current := range []bool{true, false} {
+				withOwner := CheckboxState{Checked: current, LastUpdatedBy: owner}
+				withoutOwner := CheckboxState{Checked: current}
+				if !policy.NeedsOwner(request) {
+					assert.Equal(t, policy.Permits(withOwner, request), policy.Permits(withoutOwner, request),
+						"%s checked=%t current=%t", name, checked, current)
+				}
+			}
+		}
+	}
+
+	policy, err := NewPolicy(PolicyOwnerUncheck)
+	require.NoError(t, err)
+	assert.True(t, policy.NeedsOwner(CheckboxRequest{Checked: false}))
+	assert.False(t, policy.NeedsOwner(CheckboxRequest{Checked: true}))
+}
+
+func TestNewPolicyUnknown(t *testing.T) {
+	policy, err := NewPolicy("most_votes_wins")
+	assert.Error(t, err)
+	assert.Nil(t, policy)
+}
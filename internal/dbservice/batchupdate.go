@@ -0,0 +1,226 @@
+package dbservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// CheckboxUpdateResult is the outcome of a single request applied by UpdateCheckboxes. Err is set when the request
+// could not be applied, ErrDuplicateRecord when it had already been processed and ErrRecordNotFound when the checkbox
+// does not exist.
+type CheckboxUpdateResult struct {
+	Decision conflictpolicy.Decision
+	Err      apierror.APIError
+}
+
+// UpdateCheckboxes applies a batch of check and uncheck requests in a single transaction, with the same semantics as
+// calling UpdateCheckbox for each request in order, but with a fixed number of round trips to the database:
+// one multi-row UPDATE per checkbox table, and a COPY each into UPDATE_T and OUTBOX_T.
+// It returns one result per request, in the same order as the requests. If the transaction itself fails, no request
+// is applied and the APIError is returned instead.
+func (postgresCheckboxRepository) UpdateCheckboxes(ctx context.Context, policy conflictpolicy.Policy, requests []conflictpolicy.CheckboxRequest) ([]CheckboxUpdateResult, apierror.APIError) {
+	results := make([]CheckboxUpdateResult, len(requests))
+	if len(requests) == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, operationWrite)
+	defer cancel()
+
+	var updated, changed int
+	apierr := WithTx(ctx, TxOptions{}, func(tx pgx.Tx) error {
+		// a retried transaction starts over
+		results = make([]CheckboxUpdateResult, len(requests))
+
+		// Lock every checkbox in the batch, in checkbox order so concurrent batches can't deadlock, and read their state
+		checkboxNbrs := make([]int, 0, len(requests))
+		for _, request := range requests {
+			checkboxNbrs = append(checkboxNbrs, request.CheckboxNbr)
+		}
+		states, err := lockCheckboxStatesTx(ctx, tx, checkboxNbrs)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to lock checkboxes inside UpdateCheckboxes(%d requests)", len(requests))
+			return wrapDatabaseError(err, "failed to lock checkboxes")
+		}
+
+		// Record every request for an existing checkbox as processed, the ones that already were are redeliveries
+		requestUuids := make([]uuid.UUID, 0, len(requests))
+		for _, request := range requests {
+			if _, found := states[request.CheckboxNbr]; found {
+				requestUuids = append(requestUuids, request.RequestUuid)
+			}
+		}
+		newRequests, err := insertProcessedRequestsTx(ctx, tx, requestUuids)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to insert processed_request_t inside UpdateCheckboxes(%d requests)", len(requests))
+			return wrapDatabaseError(err, "failed to record processed requests")
+		}
+
+		// Resolve each request in order against the state left by the requests before it
+		applied := make(map[int]conflictpolicy.CheckboxRequest) // last applied request per checkbox
+		updateRows := make([][]any, 0, len(requests))
+		outboxRows := make([][]any, 0, 2*len(requests))
+		for i, request := range requests {
+			current, found := states[request.CheckboxNbr]
+			if !found {
+				results[i].Err = apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+				continue
+			}
+			if !newRequests[request.RequestUuid] {
+				results[i].Err = apierror.NewAPIErrorFromCode(apierror.ErrDuplicateRecord, fmt.Sprintf("request %v has already been processed", request.RequestUuid))
+				continue
+			}
+			// a request that appears twice in the same batch is only applied the first time
+			delete(newRequests, request.RequestUuid)
+
+			decision := policy.Resolve(current, request)
+			results[i].Decision = decision
+
+			recordedChecked := request.Checked
+			if decision.Apply {
+				recordedChecked = decision.Checked
+				states[request.CheckboxNbr] = conflictpolicy.CheckboxState{
+					Checked:         decision.Checked,
+					LastUpdatedBy:   request.UserUuid,
+					LastRequestTime: request.RequestTime,
+				}
+				applied[request.CheckboxNbr] = request
+			}
+
+			updateRows = append(updateRows, []any{request.CheckboxNbr, recordedChecked, request.UserUuid, request.RequestUuid, decision.Apply, request.RequestTime})
+			if decision.Apply {
+				outboxRows = append(outboxRows, outboxRow(queueservice.CheckboxUpdateEventChanged, request, decision))
+			}
+			outboxRows = append(outboxRows, outboxRow(queueservice.CheckboxUpdateEventRequestComplete, request, decision))
+		}
+
+		// Write the final state of every checkbox that changed
+		err = updateCheckboxStatesTx(ctx, tx, states, applied)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to update checkboxes inside UpdateCheckboxes(%d requests)", len(requests))
+			return wrapDatabaseError(err, "failed to update checkbox state")
+		}
+
+		// Record every attempt in UPDATE_T, and queue the update events in the outbox
+		_, err = CopyFromTx(ctx, tx, pgx.Identifier{"mcb", "update_t"},
+			[]string{"checkbox_nbr", "checked", "updated_by", "request_id", "success", "request_time"}, updateRows)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to copy update_t inside UpdateCheckboxes(%d requests)", len(requests))
+			return wrapDatabaseError(err, "failed to record checkbox updates")
+		}
+		_, err = CopyFromTx(ctx, tx, pgx.Identifier{"mcb", "outbox_t"},
+			[]string{"event_type", "checkbox_nbr", "checked", "updated_by", "request_id", "success", "reason"}, outboxRows)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to copy outbox_t inside UpdateCheckboxes(%d requests)", len(requests))
+			return wrapDatabaseError(err, "failed to queue checkbox update events")
+		}
+
+		updated, changed = len(updateRows), len(applied)
+		return nil
+	})
+	if apierr != nil {
+		return nil, apierr
+	}
+
+	log.Ctx(ctx).Debug().Msgf("UpdateCheckboxes(%d requests) completed successfully under policy %s: attempted=%d, checkboxes_changed=%d",
+		len(requests), policy.Name(), updated, changed)
+	return results, nil
+}
+
+// lockCheckboxStatesTx locks the given checkboxes for the rest of the transaction and returns their current state,
+// keyed by checkbox number. Checkboxes that don't exist are missing from the result.
+func lockCheckboxStatesTx(ctx context.Context, tx pgx.Tx, checkboxNbrs []int) (map[int]conflictpolicy.CheckboxState, error) {
+	rows, err := QueryTx(ctx, tx, "SELECT c.CHECKBOX_NBR, c.CHECKED_STATE, d.LAST_UPDATED_BY, d.LAST_REQUEST_TIME "+
+		"FROM MCB.CHECKBOX_T c "+
+		"JOIN MCB.CHECKBOX_DETAILS_T d ON c.CHECKBOX_NBR = d.CHECKBOX_NBR "+
+		"WHERE c.CHECKBOX_NBR = ANY($1) "+
+		"ORDER BY c.CHECKBOX_NBR "+
+		"FOR UPDATE", checkboxNbrs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[int]conflictpolicy.CheckboxState, len(checkboxNbrs))
+	for rows.Next() {
+		var checkboxNbr int
+		state := conflictpolicy.CheckboxState{}
+		if err := rows.Scan(&checkboxNbr, &state.Checked, &state.LastUpdatedBy, &state.LastRequestTime); err != nil {
+			return nil, err
+		}
+		states[checkboxNbr] = state
+	}
+
+	return states, rows.Err()
+}
+
+// insertProcessedRequestsTx records the given requests as processed, and returns the ones that weren't already
+func insertProcessedRequestsTx(ctx context.Context, tx pgx.Tx, requestUuids []uuid.UUID) (map[uuid.UUID]bool, error) {
+	rows, err := QueryTx(ctx, tx, "INSERT INTO MCB.PROCESSED_REQUEST_T ( REQUEST_ID ) "+
+		"SELECT * FROM UNNEST($1::UUID[]) "+
+		"ON CONFLICT ( REQUEST_ID ) DO NOTHING "+
+		"RETURNING REQUEST_ID", requestUuids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	inserted := make(map[uuid.UUID]bool, len(requestUuids))
+	for rows.Next() {
+		var requestUuid uuid.UUID
+		if err := rows.Scan(&requestUuid); err != nil {
+			return nil, err
+		}
+		inserted[requestUuid] = true
+	}
+
+	return inserted, rows.Err()
+}
+
+// updateCheckboxStatesTx writes the final state of the checkboxes in applied, along with the details of the last
+// request applied to each of them, using one multi-row UPDATE per table
+func updateCheckboxStatesTx(ctx context.Context, tx pgx.Tx, states map[int]conflictpolicy.CheckboxState, applied map[int]conflictpolicy.CheckboxRequest) error {
+	if len(applied) == 0 {
+		return nil
+	}
+
+	checkboxNbrs := make([]int, 0, len(applied))
+	checkedStates := make([]bool, 0, len(applied))
+	updatedBy := make([]uuid.UUID, 0, len(applied))
+	requestIds := make([]uuid.UUID, 0, len(applied))
+	requestTimes := make([]time.Time, 0, len(applied))
+	for checkboxNbr, request := range applied {
+		checkboxNbrs = append(checkboxNbrs, checkboxNbr)
+		checkedStates = append(checkedStates, states[checkboxNbr].Checked)
+		updatedBy = append(updatedBy, request.UserUuid)
+		requestIds = append(requestIds, request.RequestUuid)
+		requestTimes = append(requestTimes, request.RequestTime)
+	}
+
+	_, err := ExecTx(ctx, tx, "UPDATE MCB.CHECKBOX_T c "+
+		"SET CHECKED_STATE = v.CHECKED_STATE "+
+		"FROM UNNEST($1::INT[], $2::BOOLEAN[]) AS v(CHECKBOX_NBR, CHECKED_STATE) "+
+		"WHERE c.CHECKBOX_NBR = v.CHECKBOX_NBR", checkboxNbrs, checkedStates)
+	if err != nil {
+		return err
+	}
+
+	_, err = ExecTx(ctx, tx, "UPDATE MCB.CHECKBOX_DETAILS_T d "+
+		"SET LAST_UPDATED_BY = v.LAST_UPDATED_BY, LAST_REQUEST_ID = v.LAST_REQUEST_ID, "+
+		"LAST_UPDATED_DATE = $5, LAST_REQUEST_TIME = v.LAST_REQUEST_TIME "+
+		"FROM UNNEST($1::INT[], $2::UUID[], $3::UUID[], $4::TIMESTAMPTZ[]) AS v(CHECKBOX_NBR, LAST_UPDATED_BY, LAST_REQUEST_ID, LAST_REQUEST_TIME) "+
+		"WHERE d.CHECKBOX_NBR = v.CHECKBOX_NBR", checkboxNbrs, updatedBy, requestIds, requestTimes, time.Now())
+	return err
+}
+
+func outboxRow(eventType string, request conflictpolicy.CheckboxRequest, decision conflictpolicy.Decision) []any {
+	return []any{eventType, request.CheckboxNbr, decision.Checked, request.UserUuid, request.RequestUuid, decision.Apply, decision.Reason}
+}
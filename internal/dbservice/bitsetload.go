@@ -0,0 +1,88 @@
+package dbservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	checkboxCount         = 1000000
+	checkboxPartitionSize = 100000 // the range of each CHECKBOX_T partition
+)
+
+// checkboxPartitionsQuery packs each partition of CHECKBOX_T into a bit string, it takes checkboxPartitionSize as $1
+const checkboxPartitionsQuery = "SELECT CHECKBOX_NBR / $1 AS PARTITION_NBR, COUNT(*), " +
+	"STRING_AGG(CASE WHEN CHECKED_STATE THEN '1' ELSE '0' END, '' ORDER BY CHECKBOX_NBR)::VARBIT " +
+	"FROM MCB.CHECKBOX_T " +
+	"GROUP BY 1 ORDER BY 1"
+
+// LoadCheckboxBitset has Postgres pack each partition of CHECKBOX_T into a bit string, so the board arrives as ten rows
+// of 12.5KB rather than a million rows, and decodes each one straight into the bitset as it streams in. The bits only
+// line up with the checkbox numbers if every checkbox exists, so a partition with any missing fails the load.
+func (postgresCheckboxRepository) LoadCheckboxBitset(ctx context.Context, progress LoadProgressFunc) (*bitset.Bitset, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
+	rows, err := Query(ctx, checkboxPartitionsQuery, checkboxPartitionSize)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox partitions inside LoadCheckboxBitset")
+		return nil, wrapDatabaseError(err, "failed to query checkbox partitions")
+	}
+	return scanCheckboxPartitions(ctx, rows, progress)
+}
+
+// scanCheckboxPartitions decodes the rows of checkboxPartitionsQuery into a bitset, and closes them
+func scanCheckboxPartitions(ctx context.Context, rows pgx.Rows, progress LoadProgressFunc) (*bitset.Bitset, apierror.APIError) {
+	defer rows.Close()
+
+	board := bitset.New(checkboxCount)
+	loaded := 0
+	for rows.Next() {
+		var partitionNbr int
+		var count int64
+		var packed pgtype.Bits
+		err := rows.Scan(&partitionNbr, &count, &packed)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to scan checkbox partition inside scanCheckboxPartitions")
+			return nil, wrapDatabaseError(err, "failed to scan checkbox partition")
+		}
+
+		offset := partitionNbr * checkboxPartitionSize
+		expected := min(checkboxPartitionSize, checkboxCount-offset)
+		if !packed.Valid || count != int64(expected) || int(packed.Len) != expected {
+			log.Ctx(ctx).Error().Msgf("partition %d has %d checkboxes, expected %d, inside scanCheckboxPartitions", partitionNbr, count, expected)
+			return nil, apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, fmt.Sprintf("partition %d has %d checkboxes, expected %d", partitionNbr, count, expected))
+		}
+
+		err = board.SetPacked(offset, packed.Bytes, int(packed.Len))
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to decode partition %d inside scanCheckboxPartitions", partitionNbr)
+			return nil, apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, fmt.Sprintf("failed to decode partition %d", partitionNbr))
+		}
+
+		loaded += expected
+		log.Ctx(ctx).Debug().Msgf("scanCheckboxPartitions loaded partition %d, %d of %d checkboxes", partitionNbr, loaded, checkboxCount)
+		if progress != nil {
+			progress(loaded, checkboxCount)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("rows iteration error inside scanCheckboxPartitions")
+		return nil, wrapDatabaseError(err, "rows iteration error inside scanCheckboxPartitions")
+	}
+
+	// if we didnt get exactly 1,000,000 checkboxes, then something is badly wrong
+	if loaded != checkboxCount {
+		log.Ctx(ctx).Error().Msgf("expected to get %d checkboxes, got %d", checkboxCount, loaded)
+		return nil, apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, fmt.Sprintf("expected to get %d checkboxes, got %d", checkboxCount, loaded))
+	}
+
+	return board, nil
+}
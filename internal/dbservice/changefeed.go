@@ -0,0 +1,139 @@
+package dbservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	checkboxChangeChannel = "mcb_checkbox_changes"
+
+	defaultChangeFeedReconcileInterval = 5 * time.Minute
+
+	changeFeedReconnectSleepDuration = 5 * time.Second
+	changeFeedWaitTimeout            = time.Second
+)
+
+// CheckboxChange is a committed change to a checkbox, from the change feed
+type CheckboxChange struct {
+	CheckboxNbr int       `json:"checkbox_nbr"`
+	Checked     bool      `json:"checked"`
+	ChangedAt   time.Time `json:"changed_at"`
+}
+
+// ChangeFeedHandler receives the change feed. Reload is called when the listener (re)connects, since changes may
+// have been missed while it wasn't listening. It should replace the handler's state with a full load, and the changes
+// that follow are applied on top. Reconcile is called every CHECKBOX_CHANGE_FEED_RECONCILE_INTERVAL, to catch anything
+// missed while connected, and should correct only what differs from the database. It stands in for detecting gaps in
+// the feed, which sequence numbers can't do reliably, so the interval is the longest a missed change stays stale. All
+// three are called from the listener goroutine, one at a time.
+type ChangeFeedHandler interface {
+	Reload(ctx context.Context, reason string) apierror.APIError
+	Reconcile(ctx context.Context) apierror.APIError
+	Apply(change CheckboxChange)
+}
+
+// ListenCheckboxChanges streams committed checkbox changes to the handler until the context is cancelled. It holds
+// a dedicated connection outside the pool, since LISTEN is tied to the session, and reconnects if it is lost.
+func ListenCheckboxChanges(ctx context.Context, handler ChangeFeedHandler) {
+	log.Info().Msg("Checkbox change feed listener started")
+
+	for {
+		err := listenCheckboxChanges(ctx, handler)
+		if ctx.Err() != nil {
+			log.Info().Msg("Context cancelled, checkbox change feed listener shutting down")
+			return
+		}
+		log.Error().Err(err).Msgf("checkbox change feed listener failed, reconnecting in %v", changeFeedReconnectSleepDuration)
+
+		// Context-aware sleep before reconnecting
+		timer := time.NewTimer(changeFeedReconnectSleepDuration)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info().Msg("Context cancelled during sleep, checkbox change feed listener shutting down")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// listenCheckboxChanges runs one connection's worth of the change feed, and returns why it stopped
+func listenCheckboxChanges(ctx context.Context, handler ChangeFeedHandler) error {
+	if pool == nil {
+		return fmt.Errorf("database pool not initialized")
+	}
+
+	connConfig := pool.Config().ConnConfig.Copy()
+	// the session lives as long as the process, so its statements aren't traced as part of any request
+	connConfig.Tracer = nil
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect for change feed: %w", err)
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = conn.Close(closeCtx)
+	}()
+
+	// Listen before loading, so nothing committed after the load is missed. Changes committed before the load are
+	// applied again on top of it, which is harmless since they arrive in commit order.
+	_, err = conn.Exec(ctx, "LISTEN "+checkboxChangeChannel)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", checkboxChangeChannel, err)
+	}
+
+	if apierr := handler.Reload(ctx, "change feed connected"); apierr != nil {
+		return fmt.Errorf("failed to reload on connect: %w", apierr)
+	}
+
+	reconcileInterval := apiconfig.GetDurationWithDefault("CHECKBOX_CHANGE_FEED_RECONCILE_INTERVAL", defaultChangeFeedReconcileInterval)
+	nextReconcile := time.Now().Add(reconcileInterval)
+	for {
+		// wake up regularly even when idle, so the reconciliation runs on time
+		waitCtx, cancel := context.WithTimeout(ctx, changeFeedWaitTimeout)
+		notification, err := conn.WaitForNotification(waitCtx)
+		cancel()
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if waitCtx.Err() == nil {
+				return fmt.Errorf("failed waiting for notification: %w", err)
+			}
+			// a wait timeout just means nothing arrived
+			if conn.IsClosed() {
+				return fmt.Errorf("change feed connection closed: %w", err)
+			}
+		} else {
+			change := CheckboxChange{}
+			if err := json.Unmarshal([]byte(notification.Payload), &change); err != nil {
+				// can't tell what was missed, so start again from a full load
+				log.Error().Err(err).Msgf("failed to parse change feed notification '%s'", notification.Payload)
+				if apierr := handler.Reload(ctx, "unparseable notification"); apierr != nil {
+					return fmt.Errorf("failed to reload after unparseable notification: %w", apierr)
+				}
+				continue
+			}
+
+			handler.Apply(change)
+		}
+
+		if reconcileInterval > 0 && time.Now().After(nextReconcile) {
+			// a failed reconciliation leaves the memory store as the feed has kept it, and is tried again next time
+			if apierr := handler.Reconcile(ctx); apierr != nil {
+				log.Error().Err(apierr).Msg("failed to reconcile with the checkbox change feed")
+			}
+			nextReconcile = time.Now().Add(reconcileInterval)
+		}
+	}
+}
@@ -3,64 +3,163 @@ package dbservice
 import (
 	"context"
 	"fmt"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
 	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
-	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/rs/zerolog/log"
 	"time"
 )
 
-// UpdateCheckbox updates the state of a checkbox identified by its number with the specified checked status.
-// It returns an APIError if the operation fails, with contextual and stack trace information.
-func UpdateCheckbox(ctx context.Context, checkboxNbr int, checked bool, userUuid uuid.UUID, requestUuid uuid.UUID) apierror.APIError {
-	// Begin transaction
-	tx, err := BeginTx(ctx)
-	if err != nil {
-		log.Error().Err(err).Msgf("failed to begin transaction inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, userUuid, requestUuid)
-		return apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to begin transaction")
-	}
-
-	// Ensure cleanup - rollback on error
-	defer func(err2 error) {
-		if err2 != nil {
-			rollbackerr := RollbackTx(ctx, tx)
-			if rollbackerr != nil {
-				log.Error().Err(rollbackerr).Msgf(
-					"failed to rollback transaction inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, userUuid, requestUuid,
-				)
+// UpdateCheckbox resolves a check or uncheck request against the current state of the checkbox using the given
+// conflict policy, and applies it if the policy allows. The checkbox rows are locked for the duration of the
+// transaction, so concurrent requests for the same checkbox are resolved one at a time. Every attempt is recorded in
+// UPDATE_T with its SUCCESS flag. Each request is applied at most once, a redelivered request returns an
+// ErrDuplicateRecord APIError without changing anything.
+// It returns the policy decision, or an APIError if the operation fails, with contextual and stack trace information.
+func (postgresCheckboxRepository) UpdateCheckbox(ctx context.Context, policy conflictpolicy.Policy, request conflictpolicy.CheckboxRequest) (conflictpolicy.Decision, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationWrite)
+	defer cancel()
+
+	checkboxNbr, checked, userUuid, requestUuid := request.CheckboxNbr, request.Checked, request.UserUuid, request.RequestUuid
+	// the user is logged as DB_LOG_REDACT shows it in the queries
+	loggedUser := redactArg(userUuid)
+
+	var decision conflictpolicy.Decision
+	apierr := WithTx(ctx, TxOptions{}, func(tx pgx.Tx) error {
+		// Record the request as processed. If it already was, this is a redelivery of a request that has been
+		// applied, and nothing else is done. A concurrent delivery of the same request blocks here until the first
+		// one commits.
+		tag, err := ExecTx(ctx, tx, "INSERT INTO MCB.PROCESSED_REQUEST_T ( REQUEST_ID ) "+
+			"VALUES ( $1 ) ON CONFLICT ( REQUEST_ID ) DO NOTHING", requestUuid)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to insert processed_request_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return wrapDatabaseError(err, "failed to record processed request")
+		}
+		if tag.RowsAffected() == 0 {
+			log.Ctx(ctx).Info().Msgf("request %v has already been processed inside UpdateCheckbox(%d, %t, %v, %v)", requestUuid, checkboxNbr, checked, loggedUser, requestUuid)
+			return apierror.NewAPIErrorFromCode(apierror.ErrDuplicateRecord, fmt.Sprintf("request %v has already been processed", requestUuid))
+		}
+
+		// Lock the checkbox and read its current state
+		rows, err := QueryTx(ctx, tx, "SELECT c.CHECKED_STATE, d.LAST_UPDATED_BY, d.LAST_REQUEST_TIME "+
+			"FROM MCB.CHECKBOX_T c "+
+			"JOIN MCB.CHECKBOX_DETAILS_T d ON c.CHECKBOX_NBR = d.CHECKBOX_NBR "+
+			"WHERE c.CHECKBOX_NBR = $1 "+
+			"FOR UPDATE", checkboxNbr)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to lock checkbox inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return wrapDatabaseError(err, "failed to lock checkbox")
+		}
+		current, found, err := scanCheckboxState(rows)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to scan checkbox state inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return wrapDatabaseError(err, "failed to scan checkbox state")
+		}
+		if !found {
+			log.Ctx(ctx).Debug().Msgf("no checkbox found with number %d inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checkboxNbr, checked, loggedUser, requestUuid)
+			return apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+		}
+
+		decision = policy.Resolve(current, request)
+
+		if decision.Apply {
+			// Update CHECKBOX_T table
+			_, err = ExecTx(ctx, tx, "UPDATE MCB.CHECKBOX_T "+
+				"SET CHECKED_STATE = $1 WHERE CHECKBOX_NBR = $2",
+				decision.Checked, checkboxNbr)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("failed to update checkbox_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+				return wrapDatabaseError(err, "failed to update checkbox state")
+			}
+
+			// Update CHECKBOX_DETAILS_T table
+			_, err = ExecTx(ctx, tx, "UPDATE MCB.CHECKBOX_DETAILS_T "+
+				"SET LAST_UPDATED_BY = $1, LAST_REQUEST_ID = $2, LAST_UPDATED_DATE = $3, LAST_REQUEST_TIME = $4 "+
+				"WHERE CHECKBOX_NBR = $5", userUuid, requestUuid, time.Now(), request.RequestTime, checkboxNbr)
+			if err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("failed to update checkbox_details_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+				return wrapDatabaseError(err, "failed to update checkbox details")
 			}
 		}
-	}(err)
 
-	// Update CHECKBOX_T table
-	_, err = ExecTx(ctx, tx, "UPDATE MCB.CHECKBOX_T "+
-		"SET CHECKED_STATE = $1 WHERE CHECKBOX_NBR = $2",
-		checked, checkboxNbr)
-	if err != nil {
-		log.Error().Err(err).Msgf("failed to update checkbox_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, userUuid, requestUuid)
-		return apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to update checkbox state")
+		// Record the attempt in UPDATE_T, whether or not it was applied
+		recordedChecked := checked
+		if decision.Apply {
+			recordedChecked = decision.Checked
+		}
+		_, err = ExecTx(ctx, tx, "INSERT INTO MCB.UPDATE_T "+
+			"( CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REQUEST_TIME ) "+
+			"VALUES ( $1, $2, $3, $4, $5, $6 )", checkboxNbr, recordedChecked, userUuid, requestUuid, decision.Apply, request.RequestTime)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to insert update_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return wrapDatabaseError(err, "failed to record checkbox update")
+		}
+
+		// Queue the update events in the outbox, so they are published if and only if this transaction commits
+		err = insertOutboxEventsTx(ctx, tx, request, decision)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to insert outbox_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return wrapDatabaseError(err, "failed to queue checkbox update events")
+		}
+
+		return nil
+	})
+	if apierr != nil {
+		return conflictpolicy.Decision{}, apierr
 	}
 
-	// Update CHECKBOX_DETAILS_T table
-	_, err = ExecTx(ctx, tx, "UPDATE MCB.CHECKBOX_DETAILS_T "+
-		"SET LAST_UPDATED_BY = $1, LAST_REQUEST_ID = $2, LAST_UPDATED_DATE = $3 "+
-		"WHERE CHECKBOX_NBR = $4", userUuid, requestUuid, time.Now(), checkboxNbr)
+	log.Ctx(ctx).Debug().Msgf("UpdateCheckbox(%d, %t, %v, %v) completed successfully under policy %s: apply=%t, checked=%t, reason=%s",
+		checkboxNbr, checked, loggedUser, requestUuid, policy.Name(), decision.Apply, decision.Checked, decision.Reason)
+	return decision, nil
+}
+
+// GetCheckboxState returns the current state of a checkbox as seen by the conflict policies
+func (postgresCheckboxRepository) GetCheckboxState(ctx context.Context, checkboxNbr int) (conflictpolicy.CheckboxState, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationRead)
+	defer cancel()
+
+	rows, err := Query(ctx,
+		"SELECT c.CHECKED_STATE, d.LAST_UPDATED_BY, d.LAST_REQUEST_TIME "+
+			"FROM MCB.CHECKBOX_T c "+
+			"JOIN MCB.CHECKBOX_DETAILS_T d ON c.CHECKBOX_NBR = d.CHECKBOX_NBR "+
+			"WHERE c.CHECKBOX_NBR = $1",
+		checkboxNbr)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to update checkbox_details_t inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, userUuid, requestUuid)
-		return apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to update checkbox details")
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox state inside GetCheckboxState(%d)", checkboxNbr)
+		return conflictpolicy.CheckboxState{}, wrapDatabaseError(err, "failed to query checkbox state")
 	}
 
-	// Commit transaction
-	err = CommitTx(ctx, tx)
+	state, found, err := scanCheckboxState(rows)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to commit transaction inside UpdateCheckbox(%d, %t, %v, %v)", checkboxNbr, checked, userUuid, requestUuid)
-		return apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to commit transaction")
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to scan checkbox state inside GetCheckboxState(%d)", checkboxNbr)
+		return conflictpolicy.CheckboxState{}, wrapDatabaseError(err, "failed to scan checkbox state")
+	}
+	if !found {
+		log.Ctx(ctx).Debug().Msgf("no checkbox found with number %d inside GetCheckboxState(%d)", checkboxNbr, checkboxNbr)
+		return conflictpolicy.CheckboxState{}, apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
 	}
 
-	log.Debug().Msgf("UpdateCheckbox(%d, %t, %v, %v) completed successfully", checkboxNbr, checked, userUuid, requestUuid)
-	return nil
+	return state, nil
+}
+
+// scanCheckboxState reads a single CHECKED_STATE, LAST_UPDATED_BY, LAST_REQUEST_TIME row and closes the rows
+func scanCheckboxState(rows pgx.Rows) (conflictpolicy.CheckboxState, bool, error) {
+	defer rows.Close()
+
+	state := conflictpolicy.CheckboxState{}
+	if !rows.Next() {
+		return state, false, rows.Err()
+	}
+	if err := rows.Scan(&state.Checked, &state.LastUpdatedBy, &state.LastRequestTime); err != nil {
+		return state, false, err
+	}
+	return state, true, rows.Err()
 }
 
-func GetCheckboxStatus(ctx context.Context, checkboxNbr int) (bool, time.Time, apierror.APIError) {
+func (postgresCheckboxRepository) GetCheckboxStatus(ctx context.Context, checkboxNbr int) (bool, time.Time, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationRead)
+	defer cancel()
+
 	// Query both tables with a JOIN to get checkbox state and last updated date
 	rows, err := Query(ctx,
 		"SELECT c.CHECKED_STATE, d.LAST_UPDATED_DATE "+
@@ -69,14 +168,14 @@ func GetCheckboxStatus(ctx context.Context, checkboxNbr int) (bool, time.Time, a
 			"WHERE c.CHECKBOX_NBR = $1",
 		checkboxNbr)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to query checkbox status inside GetCheckboxStatus(%d)", checkboxNbr)
-		return false, time.UnixMilli(0), apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to query checkbox status")
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox status inside GetCheckboxStatus(%d)", checkboxNbr)
+		return false, time.UnixMilli(0), wrapDatabaseError(err, "failed to query checkbox status")
 	}
 	defer rows.Close()
 
 	// Check if any rows were returned
 	if !rows.Next() {
-		log.Debug().Msgf("no checkbox found with number %d inside GetCheckboxStatus(%d)", checkboxNbr, checkboxNbr)
+		log.Ctx(ctx).Debug().Msgf("no checkbox found with number %d inside GetCheckboxStatus(%d)", checkboxNbr, checkboxNbr)
 		return false, time.UnixMilli(0), apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
 	}
 
@@ -85,27 +184,30 @@ func GetCheckboxStatus(ctx context.Context, checkboxNbr int) (bool, time.Time, a
 	var lastUpdatedDate time.Time
 	err = rows.Scan(&checkedState, &lastUpdatedDate)
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to scan checkbox status result inside GetCheckboxStatus(%d)", checkboxNbr)
-		return false, time.UnixMilli(0), apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to scan checkbox status result")
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to scan checkbox status result inside GetCheckboxStatus(%d)", checkboxNbr)
+		return false, time.UnixMilli(0), wrapDatabaseError(err, "failed to scan checkbox status result")
 	}
 
 	// Check for any errors during iteration
 	if err = rows.Err(); err != nil {
-		log.Error().Err(err).Msgf("rows iteration error inside GetCheckboxStatus(%d)", checkboxNbr)
-		return false, time.UnixMilli(0), apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "database iteration error")
+		log.Ctx(ctx).Error().Err(err).Msgf("rows iteration error inside GetCheckboxStatus(%d)", checkboxNbr)
+		return false, time.UnixMilli(0), wrapDatabaseError(err, "database iteration error")
 	}
 
-	log.Debug().Msgf("GetCheckboxStatus(%d) completed successfully: checked=%t, lastUpdated=%v", checkboxNbr, checkedState, lastUpdatedDate)
+	log.Ctx(ctx).Debug().Msgf("GetCheckboxStatus(%d) completed successfully: checked=%t, lastUpdated=%v", checkboxNbr, checkedState, lastUpdatedDate)
 	return checkedState, lastUpdatedDate, nil
 }
 
-func GetFullCheckboxStore(ctx context.Context) (*[]bool, apierror.APIError) {
+func (postgresCheckboxRepository) GetFullCheckboxStore(ctx context.Context) (*[]bool, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
 	checkboxes := make([]bool, 1000000)
 
 	rows, err := Query(ctx, "SELECT CHECKED_STATE FROM MCB.CHECKBOX_T ORDER BY CHECKBOX_NBR")
 	if err != nil {
-		log.Error().Err(err).Msgf("failed to query checkbox status inside GetFullCheckboxStore")
-		return nil, apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to query checkbox status inside GetFullCheckboxStore")
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox status inside GetFullCheckboxStore")
+		return nil, wrapDatabaseError(err, "failed to query checkbox status inside GetFullCheckboxStore")
 	}
 	defer rows.Close()
 
@@ -116,31 +218,71 @@ func GetFullCheckboxStore(ctx context.Context) (*[]bool, apierror.APIError) {
 	for rows.Next() {
 		err := rows.Scan(&checkboxes[i])
 		if err != nil {
-			log.Error().Err(err).Msgf("failed to scan value from result inside GetFullCheckboxStore")
-			return nil, apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to scan value from result inside GetFullCheckboxStore")
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to scan value from result inside GetFullCheckboxStore")
+			return nil, wrapDatabaseError(err, "failed to scan value from result inside GetFullCheckboxStore")
 		}
 		i++
 	}
 
 	// if we didnt get exactly 1,000,000 rows, then something is badly wrong
 	if i != 1000000 {
-		log.Error().Msgf("expected to get %d checkboxes, got %d", 1000000, i)
+		log.Ctx(ctx).Error().Msgf("expected to get %d checkboxes, got %d", 1000000, i)
 		return nil, apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, fmt.Sprintf("expected to get %d checkboxes, got %d", 1000000, i))
 	}
 
 	// Check for any errors during iteration
 	if err = rows.Err(); err != nil {
-		log.Error().Err(err).Msgf("rows iteration error inside GetFullCheckboxStore")
-		return nil, apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "rows iteration error inside GetFullCheckboxStore")
+		log.Ctx(ctx).Error().Err(err).Msgf("rows iteration error inside GetFullCheckboxStore")
+		return nil, wrapDatabaseError(err, "rows iteration error inside GetFullCheckboxStore")
 	}
 
 	return &checkboxes, nil
 }
 
+func (postgresCheckboxRepository) GetCheckboxHistory(ctx context.Context, checkboxNbr int, limit int) ([]CheckboxUpdate, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationRead)
+	defer cancel()
+
+	rows, err := Query(ctx,
+		"SELECT UPDATE_DATE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REQUEST_TIME "+
+			"FROM MCB.UPDATE_T "+
+			"WHERE CHECKBOX_NBR = $1 "+
+			"ORDER BY UPDATE_DATE DESC LIMIT $2",
+		checkboxNbr, limit)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox history inside GetCheckboxHistory(%d, %d)", checkboxNbr, limit)
+		return nil, wrapDatabaseError(err, "failed to query checkbox history")
+	}
+	defer rows.Close()
+
+	history := make([]CheckboxUpdate, 0)
+	for rows.Next() {
+		u := CheckboxUpdate{}
+		err := rows.Scan(&u.UpdateDate, &u.CheckboxNbr, &u.Checked, &u.UpdatedBy, &u.RequestUuid, &u.Success, &u.RequestTime)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to scan checkbox history inside GetCheckboxHistory(%d, %d)", checkboxNbr, limit)
+			return nil, wrapDatabaseError(err, "failed to scan checkbox history")
+		}
+		history = append(history, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("rows iteration error inside GetCheckboxHistory(%d, %d)", checkboxNbr, limit)
+		return nil, wrapDatabaseError(err, "database iteration error")
+	}
+
+	return history, nil
+}
+
+// InitDbPool connects to the DATABASE_PROVIDER database
 func InitDbPool(ctx context.Context) apierror.APIError {
+	if DatabaseProvider() == ProviderSQLite {
+		return initSQLite(ctx)
+	}
+
 	err := InitializePool(ctx)
 	if err != nil {
-		return apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to initialize the database pool")
+		return wrapDatabaseError(err, "failed to initialize the database pool")
 	}
 	return nil
 }
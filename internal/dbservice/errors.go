@@ -0,0 +1,92 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SQLSTATEs that get their own error code, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	sqlStateSerializationFailure     = "40001"
+	sqlStateDeadlockDetected         = "40P01"
+	sqlStateUniqueViolation          = "23505"
+	sqlStateQueryCanceled            = "57014" // statement_timeout, or a cancel request
+	sqlStateLockNotAvailable         = "55P03" // lock_timeout
+	sqlStateIdleInTransactionTimeout = "25P03"
+	sqlStateAdminShutdown            = "57P01"
+	sqlStateCrashShutdown            = "57P02"
+	sqlStateCannotConnectNow         = "57P03"
+	sqlStateTooManyConnections       = "53300"
+	sqlStateClassConnectionException = "08"
+)
+
+// ClassifyError returns the apierror code for a database error: ErrDatabaseTimeout when a deadline ran out,
+// ErrDatabaseConflict for serialization failures and deadlocks, ErrDuplicateRecord for unique violations,
+// ErrDatabaseConnection when the database couldn't be reached or dropped the connection, and ErrDatabaseError for
+// anything else. SQLite errors are classified the same way. An APIError keeps the code it already has.
+func ClassifyError(err error) string {
+	var apiErr apierror.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return classifySQLState(pgErr.Code)
+	}
+	if code, ok := classifySQLiteError(err); ok {
+		return code
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err):
+		return apierror.ErrDatabaseTimeout
+	case isConnectionError(err):
+		return apierror.ErrDatabaseConnection
+	default:
+		return apierror.ErrDatabaseError
+	}
+}
+
+func classifySQLState(code string) string {
+	switch code {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return apierror.ErrDatabaseConflict
+	case sqlStateUniqueViolation:
+		return apierror.ErrDuplicateRecord
+	case sqlStateQueryCanceled, sqlStateLockNotAvailable, sqlStateIdleInTransactionTimeout:
+		return apierror.ErrDatabaseTimeout
+	case sqlStateAdminShutdown, sqlStateCrashShutdown, sqlStateCannotConnectNow, sqlStateTooManyConnections:
+		return apierror.ErrDatabaseConnection
+	}
+	if strings.HasPrefix(code, sqlStateClassConnectionException) {
+		return apierror.ErrDatabaseConnection
+	}
+	return apierror.ErrDatabaseError
+}
+
+// isConnectionError reports whether an error came from reaching the database rather than from a statement: a failed
+// connect, a network error, or the connection closing under a query
+func isConnectionError(err error) bool {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	return errors.As(err, &connectErr) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// wrapDatabaseError wraps a database error as an APIError, with the code ClassifyError gives it
+func wrapDatabaseError(err error, message string) apierror.APIError {
+	return apierror.WrapWithCodeFromConstants(err, ClassifyError(err), message)
+}
@@ -0,0 +1,126 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name              string
+		err               error
+		expectedCode      string
+		expectedRetryable bool
+	}{
+		{
+			name:              "Context deadline",
+			err:               fmt.Errorf("query execution failed: %w", context.DeadlineExceeded),
+			expectedCode:      apierror.ErrDatabaseTimeout,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Statement timeout",
+			err:               &pgconn.PgError{Code: "57014", Message: "canceling statement due to statement timeout"},
+			expectedCode:      apierror.ErrDatabaseTimeout,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Lock timeout",
+			err:               &pgconn.PgError{Code: "55P03", Message: "canceling statement due to lock timeout"},
+			expectedCode:      apierror.ErrDatabaseTimeout,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Serialization failure",
+			err:               fmt.Errorf("command execution failed in transaction: %w", &pgconn.PgError{Code: "40001"}),
+			expectedCode:      apierror.ErrDatabaseConflict,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Deadlock",
+			err:               &pgconn.PgError{Code: "40P01", Message: "deadlock detected"},
+			expectedCode:      apierror.ErrDatabaseConflict,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Unique violation",
+			err:               &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"},
+			expectedCode:      apierror.ErrDuplicateRecord,
+			expectedRetryable: false,
+		},
+		{
+			name:              "Connection exception class",
+			err:               &pgconn.PgError{Code: "08006", Message: "connection failure"},
+			expectedCode:      apierror.ErrDatabaseConnection,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Server shutting down",
+			err:               &pgconn.PgError{Code: "57P01", Message: "terminating connection due to administrator command"},
+			expectedCode:      apierror.ErrDatabaseConnection,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Too many connections",
+			err:               &pgconn.PgError{Code: "53300", Message: "sorry, too many clients already"},
+			expectedCode:      apierror.ErrDatabaseConnection,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Connection refused",
+			err:               fmt.Errorf("failed to connect: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}),
+			expectedCode:      apierror.ErrDatabaseConnection,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Connection closed under a query",
+			err:               fmt.Errorf("query execution failed: %w", io.ErrUnexpectedEOF),
+			expectedCode:      apierror.ErrDatabaseConnection,
+			expectedRetryable: true,
+		},
+		{
+			name:              "Context cancelled",
+			err:               fmt.Errorf("query execution failed: %w", context.Canceled),
+			expectedCode:      apierror.ErrDatabaseError,
+			expectedRetryable: false,
+		},
+		{
+			name:              "Syntax error",
+			err:               &pgconn.PgError{Code: "42601", Message: "syntax error"},
+			expectedCode:      apierror.ErrDatabaseError,
+			expectedRetryable: false,
+		},
+		{
+			name:              "Other error",
+			err:               errors.New("database pool not initialized"),
+			expectedCode:      apierror.ErrDatabaseError,
+			expectedRetryable: false,
+		},
+		{
+			name:              "APIError keeps its code",
+			err:               apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found"),
+			expectedCode:      apierror.ErrRecordNotFound,
+			expectedRetryable: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedCode, ClassifyError(tt.err))
+
+			apierr := wrapDatabaseError(tt.err, "failed")
+			assert.Equal(t, tt.expectedCode, apierr.ErrorCode())
+			assert.Equal(t, tt.expectedRetryable, apierror.IsRetryable(apierr))
+			assert.ErrorIs(t, apierr, tt.err)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package dbservice
+
+import (
+	"context"
+	"time"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	JobRunStatusRunning   = "running"
+	JobRunStatusSucceeded = "succeeded"
+	JobRunStatusFailed    = "failed"
+
+	maxJobErrorMessageLength = 1000
+)
+
+// AcquireJobLease takes the lease on a job for the scheduled run, so no other backend instance runs it at the same
+// time. It returns false if another instance holds the lease, or this scheduled run has already been taken.
+func AcquireJobLease(ctx context.Context, jobName string, owner string, scheduledTime time.Time, leaseDuration time.Duration) (bool, apierror.APIError) {
+	tag, err := Exec(ctx,
+		"INSERT INTO MCB.JOB_LEASE_T ( JOB_NAME, OWNER, LEASE_UNTIL, LAST_SCHEDULED_TIME ) "+
+			"VALUES ( $1, $2, NOW() + $3 * INTERVAL '1 millisecond', $4 ) "+
+			"ON CONFLICT ( JOB_NAME ) DO UPDATE "+
+			"SET OWNER = EXCLUDED.OWNER, LEASE_UNTIL = EXCLUDED.LEASE_UNTIL, LAST_SCHEDULED_TIME = EXCLUDED.LAST_SCHEDULED_TIME "+
+			"WHERE ( MCB.JOB_LEASE_T.LEASE_UNTIL < NOW() OR MCB.JOB_LEASE_T.OWNER = EXCLUDED.OWNER ) "+
+			"AND MCB.JOB_LEASE_T.LAST_SCHEDULED_TIME < EXCLUDED.LAST_SCHEDULED_TIME",
+		jobName, owner, leaseDuration.Milliseconds(), scheduledTime)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to acquire lease inside AcquireJobLease(%s, %s)", jobName, owner)
+		return false, wrapDatabaseError(err, "failed to acquire job lease")
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// ReleaseJobLease frees the lease on a job once its run has finished, if the owner still holds it
+func ReleaseJobLease(ctx context.Context, jobName string, owner string) apierror.APIError {
+	_, err := Exec(ctx,
+		"UPDATE MCB.JOB_LEASE_T SET LEASE_UNTIL = NOW() WHERE JOB_NAME = $1 AND OWNER = $2",
+		jobName, owner)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to release lease inside ReleaseJobLease(%s, %s)", jobName, owner)
+		return wrapDatabaseError(err, "failed to release job lease")
+	}
+
+	return nil
+}
+
+// StartJobRun records the start of a job run in JOB_RUN_T, and returns its run id
+func StartJobRun(ctx context.Context, jobName string, owner string, scheduledTime time.Time) (int64, apierror.APIError) {
+	rows, err := Query(ctx,
+		"INSERT INTO MCB.JOB_RUN_T ( JOB_NAME, OWNER, SCHEDULED_TIME, STATUS ) VALUES ( $1, $2, $3, $4 ) RETURNING RUN_ID",
+		jobName, owner, scheduledTime, JobRunStatusRunning)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to insert job run inside StartJobRun(%s, %s)", jobName, owner)
+		return 0, wrapDatabaseError(err, "failed to record job run")
+	}
+	defer rows.Close()
+
+	var runId int64
+	if rows.Next() {
+		err = rows.Scan(&runId)
+	} else {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to scan run id inside StartJobRun(%s, %s)", jobName, owner)
+		return 0, wrapDatabaseError(err, "failed to record job run")
+	}
+
+	return runId, nil
+}
+
+// FinishJobRun records the outcome of a job run in JOB_RUN_T
+func FinishJobRun(ctx context.Context, runId int64, rowsAffected int64, jobErr error) apierror.APIError {
+	status := JobRunStatusSucceeded
+	var errorMessage *string
+	if jobErr != nil {
+		status = JobRunStatusFailed
+		msg := jobErr.Error()
+		if len(msg) > maxJobErrorMessageLength {
+			msg = msg[:maxJobErrorMessageLength]
+		}
+		errorMessage = &msg
+	}
+
+	_, err := Exec(ctx,
+		"UPDATE MCB.JOB_RUN_T SET END_DATE = NOW(), STATUS = $2, ROWS_AFFECTED = $3, ERROR_MESSAGE = $4 WHERE RUN_ID = $1",
+		runId, status, rowsAffected, errorMessage)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to update job run inside FinishJobRun(%d)", runId)
+		return wrapDatabaseError(err, "failed to record job run outcome")
+	}
+
+	return nil
+}
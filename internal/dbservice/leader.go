@@ -0,0 +1,202 @@
+package dbservice
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultLeaderElectionInterval = 5 * time.Second
+
+// LeaderCallbacks are called as an instance gains and loses leadership. OnStartedLeading should do the leader's
+// work until its context is cancelled, which happens as soon as leadership is lost, and return once it has stopped.
+// OnStoppedLeading is called after OnStartedLeading has returned.
+type LeaderCallbacks struct {
+	OnStartedLeading func(ctx context.Context)
+	OnStoppedLeading func()
+}
+
+// LeaderElection elects one leader among all the instances running an election with the same name, using a
+// Postgres session level advisory lock. The lock is held on a dedicated connection, which is checked every interval.
+// If the check fails the leader steps down straight away, and the idle session timeout makes sure the database
+// ends the session and frees the lock even if the leader can no longer reach it to say so.
+type LeaderElection struct {
+	name      string
+	lockKey   int64
+	interval  time.Duration
+	callbacks LeaderCallbacks
+
+	mu       sync.RWMutex
+	isLeader bool
+}
+
+// NewLeaderElection creates an election, the interval is read from the LEADER_ELECTION_INTERVAL config key
+func NewLeaderElection(name string, callbacks LeaderCallbacks) *LeaderElection {
+	return &LeaderElection{
+		name:      name,
+		lockKey:   leaderLockKey(name),
+		interval:  apiconfig.GetDurationWithDefault("LEADER_ELECTION_INTERVAL", defaultLeaderElectionInterval),
+		callbacks: callbacks,
+	}
+}
+
+// leaderLockKey maps an election name to the advisory lock key, the names are prefixed so they don't collide with
+// any other use of advisory locks
+func leaderLockKey(name string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("mcb-leader:" + name))
+	return int64(h.Sum64())
+}
+
+// IsLeader reports whether this instance currently holds the leadership
+func (l *LeaderElection) IsLeader() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.isLeader
+}
+
+// Run takes part in the election until the context is cancelled, then steps down if it is the leader
+func (l *LeaderElection) Run(ctx context.Context) {
+	log.Info().Msgf("Leader election %s started, lock key %d, interval %v", l.name, l.lockKey, l.interval)
+
+	for {
+		err := l.campaign(ctx)
+		if ctx.Err() != nil {
+			log.Info().Msgf("Context cancelled, leader election %s shutting down", l.name)
+			return
+		}
+		log.Error().Err(err).Msgf("leader election %s lost its connection, retrying in %v", l.name, l.interval)
+
+		// Context-aware sleep before reconnecting
+		timer := time.NewTimer(l.interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info().Msgf("Context cancelled during sleep, leader election %s shutting down", l.name)
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// campaign runs the election on one connection, trying for the lock every interval and keeping the session alive
+// while it holds it. It returns why the connection stopped being usable, having stepped down if it was the leader.
+func (l *LeaderElection) campaign(ctx context.Context) error {
+	if pool == nil {
+		return fmt.Errorf("database pool not initialized")
+	}
+
+	connConfig := pool.Config().ConnConfig.Copy()
+	// the session lives as long as the process, so its statements aren't traced as part of any request
+	connConfig.Tracer = nil
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect for leader election: %w", err)
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), l.interval)
+		defer cancel()
+		// closing the session releases the lock, if the database can still be reached
+		_ = conn.Close(closeCtx)
+	}()
+
+	// If the keepalives stop, the database ends the session after a few missed intervals, releasing the lock.
+	// idle_session_timeout needs Postgres 14, on older versions the lock is held until TCP notices the connection
+	// is gone, which is still safe, since the leader has already stepped down by then.
+	_, err = conn.Exec(ctx, fmt.Sprintf("SET idle_session_timeout = %d", (3*l.interval).Milliseconds()))
+	if err != nil {
+		log.Warn().Err(err).Msgf("leader election %s could not set idle_session_timeout", l.name)
+	}
+
+	var leading *leaderTerm
+	defer func() {
+		if leading != nil {
+			l.stepDown(leading)
+		}
+	}()
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, l.interval)
+		if leading == nil {
+			var acquired bool
+			err = conn.QueryRow(checkCtx, "SELECT pg_try_advisory_lock($1)", l.lockKey).Scan(&acquired)
+			if err == nil && acquired {
+				leading = l.startLeading(ctx)
+			}
+		} else {
+			_, err = conn.Exec(checkCtx, "SELECT 1")
+		}
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("leader election %s check failed: %w", l.name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if leading != nil {
+				l.stepDown(leading)
+				leading = nil
+				unlockCtx, cancel := context.WithTimeout(context.Background(), l.interval)
+				_, _ = conn.Exec(unlockCtx, "SELECT pg_advisory_unlock($1)", l.lockKey)
+				cancel()
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// leaderTerm is one period of leadership, and the work running for it
+type leaderTerm struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (l *LeaderElection) startLeading(ctx context.Context) *leaderTerm {
+	log.Info().Msgf("Became leader of %s", l.name)
+
+	l.mu.Lock()
+	l.isLeader = true
+	l.mu.Unlock()
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	term := &leaderTerm{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(term.done)
+		if l.callbacks.OnStartedLeading != nil {
+			l.callbacks.OnStartedLeading(leaderCtx)
+		}
+	}()
+
+	return term
+}
+
+// stepDown stops the leader's work, and waits for it to finish, before reporting the loss of leadership
+func (l *LeaderElection) stepDown(term *leaderTerm) {
+	log.Info().Msgf("Stepping down as leader of %s", l.name)
+
+	l.mu.Lock()
+	l.isLeader = false
+	l.mu.Unlock()
+
+	term.cancel()
+	<-term.done
+
+	if l.callbacks.OnStoppedLeading != nil {
+		l.callbacks.OnStoppedLeading()
+	}
+}
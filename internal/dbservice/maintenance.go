@@ -0,0 +1,73 @@
+package dbservice
+
+import (
+	"context"
+	"time"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/rs/zerolog/log"
+)
+
+// The purges delete in batches of at most batchSize rows, each batch in its own statement, so they never hold locks
+// on a large part of a table the consumer is writing to. They return the total number of rows deleted.
+
+// PurgeUpdates deletes UPDATE_T rows older than the cutoff
+func PurgeUpdates(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError) {
+	return purgeInBatches(ctx, "PurgeUpdates", batchSize,
+		"DELETE FROM MCB.UPDATE_T WHERE ( UPDATE_DATE, REQUEST_ID ) IN ( "+
+			"SELECT UPDATE_DATE, REQUEST_ID FROM MCB.UPDATE_T WHERE UPDATE_DATE < $1 LIMIT $2 )",
+		cutoff)
+}
+
+// PurgeInactiveClients deletes CLIENT_T rows created before the cutoff, that haven't updated a checkbox since
+func PurgeInactiveClients(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError) {
+	return purgeInBatches(ctx, "PurgeInactiveClients", batchSize,
+		"DELETE FROM MCB.CLIENT_T WHERE CLIENT_UUID IN ( "+
+			"SELECT c.CLIENT_UUID FROM MCB.CLIENT_T c WHERE c.CREATE_DATE < $1 "+
+			"AND NOT EXISTS ( SELECT 1 FROM MCB.UPDATE_T u WHERE u.UPDATED_BY = c.CLIENT_UUID AND u.UPDATE_DATE >= $1 ) "+
+			"LIMIT $2 )",
+		cutoff)
+}
+
+// PurgeProcessedRequests deletes PROCESSED_REQUEST_T rows older than the cutoff. The cutoff must be further back than
+// the queue retention, or a redelivered request could be applied twice.
+func PurgeProcessedRequests(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError) {
+	return purgeInBatches(ctx, "PurgeProcessedRequests", batchSize,
+		"DELETE FROM MCB.PROCESSED_REQUEST_T WHERE REQUEST_ID IN ( "+
+			"SELECT REQUEST_ID FROM MCB.PROCESSED_REQUEST_T WHERE PROCESSED_DATE < $1 LIMIT $2 )",
+		cutoff)
+}
+
+// PurgeSentOutboxEvents deletes OUTBOX_T rows that were published before the cutoff. Unsent events are never purged.
+func PurgeSentOutboxEvents(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError) {
+	return purgeInBatches(ctx, "PurgeSentOutboxEvents", batchSize,
+		"DELETE FROM MCB.OUTBOX_T WHERE OUTBOX_ID IN ( "+
+			"SELECT OUTBOX_ID FROM MCB.OUTBOX_T WHERE SENT_DATE < $1 ORDER BY OUTBOX_ID LIMIT $2 )",
+		cutoff)
+}
+
+// PurgeJobRuns deletes JOB_RUN_T history that started before the cutoff
+func PurgeJobRuns(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError) {
+	return purgeInBatches(ctx, "PurgeJobRuns", batchSize,
+		"DELETE FROM MCB.JOB_RUN_T WHERE RUN_ID IN ( "+
+			"SELECT RUN_ID FROM MCB.JOB_RUN_T WHERE START_DATE < $1 LIMIT $2 )",
+		cutoff)
+}
+
+// purgeInBatches runs a delete statement, which takes the cutoff as $1 and the batch size as $2, until it deletes
+// less than a full batch
+func purgeInBatches(ctx context.Context, caller string, batchSize int, query string, cutoff time.Time) (int64, apierror.APIError) {
+	var total int64
+	for {
+		tag, err := Exec(ctx, query, cutoff, batchSize)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to purge rows inside %s(%v, %d), %d rows purged so far", caller, cutoff, batchSize, total)
+			return total, wrapDatabaseError(err, "failed to purge rows")
+		}
+
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package dbservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/google/uuid"
+)
+
+// MemoryCheckboxRepository is a CheckboxRepository held entirely in memory, for testing the API and backend without
+// a database. It behaves like the Postgres repository, apart from the outbox: it raises no update events.
+type MemoryCheckboxRepository struct {
+	mu         sync.Mutex
+	checkboxes []memoryCheckbox
+	processed  map[uuid.UUID]bool
+	history    map[int][]CheckboxUpdate // oldest first
+}
+
+type memoryCheckbox struct {
+	state           conflictpolicy.CheckboxState
+	lastUpdatedDate time.Time
+}
+
+// NewMemoryCheckboxRepository creates a repository of unchecked checkboxes, numbered from 0 like the database
+func NewMemoryCheckboxRepository(size int) *MemoryCheckboxRepository {
+	now := time.Now()
+	checkboxes := make([]memoryCheckbox, size)
+	for i := range checkboxes {
+		checkboxes[i] = memoryCheckbox{
+			state:           conflictpolicy.CheckboxState{LastRequestTime: time.Unix(0, 0)},
+			lastUpdatedDate: now,
+		}
+	}
+
+	return &MemoryCheckboxRepository{
+		checkboxes: checkboxes,
+		processed:  make(map[uuid.UUID]bool),
+		history:    make(map[int][]CheckboxUpdate),
+	}
+}
+
+func (m *MemoryCheckboxRepository) GetCheckboxStatus(_ context.Context, checkboxNbr int) (bool, time.Time, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkbox, apierr := m.checkbox(checkboxNbr)
+	if apierr != nil {
+		return false, time.UnixMilli(0), apierr
+	}
+	return checkbox.state.Checked, checkbox.lastUpdatedDate, nil
+}
+
+func (m *MemoryCheckboxRepository) GetCheckboxState(_ context.Context, checkboxNbr int) (conflictpolicy.CheckboxState, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkbox, apierr := m.checkbox(checkboxNbr)
+	if apierr != nil {
+		return conflictpolicy.CheckboxState{}, apierr
+	}
+	return checkbox.state, nil
+}
+
+func (m *MemoryCheckboxRepository) UpdateCheckbox(_ context.Context, policy conflictpolicy.Policy, request conflictpolicy.CheckboxRequest) (conflictpolicy.Decision, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.update(policy, request)
+}
+
+func (m *MemoryCheckboxRepository) UpdateCheckboxes(_ context.Context, policy conflictpolicy.Policy, requests []conflictpolicy.CheckboxRequest) ([]CheckboxUpdateResult, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// each request is resolved against the state left by the ones before it, as in the Postgres batch
+	results := make([]CheckboxUpdateResult, len(requests))
+	for i, request := range requests {
+		results[i].Decision, results[i].Err = m.update(policy, request)
+	}
+	return results, nil
+}
+
+func (m *MemoryCheckboxRepository) GetFullCheckboxStore(_ context.Context) (*[]bool, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	checkboxes := make([]bool, len(m.checkboxes))
+	for i, checkbox := range m.checkboxes {
+		checkboxes[i] = checkbox.state.Checked
+	}
+	return &checkboxes, nil
+}
+
+func (m *MemoryCheckboxRepository) LoadCheckboxBitset(_ context.Context, progress LoadProgressFunc) (*bitset.Bitset, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	board := bitset.New(len(m.checkboxes))
+	for i, checkbox := range m.checkboxes {
+		board.Set(i, checkbox.state.Checked)
+	}
+	if progress != nil {
+		progress(board.Len(), board.Len())
+	}
+	return board, nil
+}
+
+func (m *MemoryCheckboxRepository) GetCheckboxHistory(_ context.Context, checkboxNbr int, limit int) ([]CheckboxUpdate, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	updates := m.history[checkboxNbr]
+	history := make([]CheckboxUpdate, 0, min(limit, len(updates)))
+	for i := len(updates) - 1; i >= 0 && len(history) < limit; i-- {
+		history = append(history, updates[i])
+	}
+	return history, nil
+}
+
+// update applies a single request, the caller must hold the lock
+func (m *MemoryCheckboxRepository) update(policy conflictpolicy.Policy, request conflictpolicy.CheckboxRequest) (conflictpolicy.Decision, apierror.APIError) {
+	checkbox, apierr := m.checkbox(request.CheckboxNbr)
+	if apierr != nil {
+		return conflictpolicy.Decision{}, apierr
+	}
+	if m.processed[request.RequestUuid] {
+		return conflictpolicy.Decision{}, apierror.NewAPIErrorFromCode(apierror.ErrDuplicateRecord, fmt.Sprintf("request %v has already been processed", request.RequestUuid))
+	}
+	m.processed[request.RequestUuid] = true
+
+	now := time.Now()
+	decision := policy.Resolve(checkbox.state, request)
+
+	recordedChecked := request.Checked
+	if decision.Apply {
+		recordedChecked = decision.Checked
+		*checkbox = memoryCheckbox{
+			state: conflictpolicy.CheckboxState{
+				Checked:         decision.Checked,
+				LastUpdatedBy:   request.UserUuid,
+				LastRequestTime: request.RequestTime,
+			},
+			lastUpdatedDate: now,
+		}
+	}
+
+	requestTime := request.RequestTime
+	m.history[request.CheckboxNbr] = append(m.history[request.CheckboxNbr], CheckboxUpdate{
+		UpdateDate:  now,
+		CheckboxNbr: request.CheckboxNbr,
+		Checked:     recordedChecked,
+		UpdatedBy:   request.UserUuid,
+		RequestUuid: request.RequestUuid,
+		Success:     decision.Apply,
+		RequestTime: &requestTime,
+	})
+
+	return decision, nil
+}
+
+// checkbox returns the checkbox with the given number, the caller must hold the lock
+func (m *MemoryCheckboxRepository) checkbox(checkboxNbr int) (*memoryCheckbox, apierror.APIError) {
+	if checkboxNbr < 0 || checkboxNbr >= len(m.checkboxes) {
+		return nil, apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+	}
+	return &m.checkboxes[checkboxNbr], nil
+}
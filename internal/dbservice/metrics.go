@@ -0,0 +1,162 @@
+package dbservice
+
+import (
+	"context"
+	"time"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// Metrics is one interval of activity, as recorded in METRICS_T
+type Metrics struct {
+	IntervalStart time.Time `json:"interval_start"`
+	IntervalEnd   time.Time `json:"interval_end"`
+	CheckedCount  int       `json:"checked_count"`
+	Checks        int       `json:"checks"`
+	Unchecks      int       `json:"unchecks"`
+	FailedCount   int       `json:"failed_count"`
+	ActiveClients int       `json:"active_clients"`
+	QueueDepth    int64     `json:"queue_depth"`
+	QueueLagMs    *int64    `json:"queue_lag_ms"`
+}
+
+const metricsColumns = "INTERVAL_START, INTERVAL_END, CHECKED_COUNT, CHECKS, UNCHECKS, FAILED_COUNT, ACTIVE_CLIENTS, QUEUE_DEPTH, QUEUE_LAG_MS"
+
+// GetLastMetricsIntervalEnd returns the end of the most recent interval in METRICS_T, and false if there is none
+func GetLastMetricsIntervalEnd(ctx context.Context) (time.Time, bool, apierror.APIError) {
+	rows, err := Query(ctx, "SELECT MAX(INTERVAL_END) FROM MCB.METRICS_T")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query metrics inside GetLastMetricsIntervalEnd()")
+		return time.Time{}, false, wrapDatabaseError(err, "failed to query metrics")
+	}
+	defer rows.Close()
+
+	var last *time.Time
+	if rows.Next() {
+		err = rows.Scan(&last)
+	} else {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("failed to scan metrics inside GetLastMetricsIntervalEnd()")
+		return time.Time{}, false, wrapDatabaseError(err, "failed to scan metrics")
+	}
+
+	if last == nil {
+		return time.Time{}, false, nil
+	}
+	return *last, true, nil
+}
+
+// InsertMetrics computes the metrics for the interval from UPDATE_T and CHECKBOX_T, and appends them to METRICS_T.
+// The queue depth comes from the queue service, so is passed in. If the interval has already been recorded, for
+// example by another backend, nothing is written and false is returned.
+func InsertMetrics(ctx context.Context, intervalStart time.Time, intervalEnd time.Time, queueDepth int64) (Metrics, bool, apierror.APIError) {
+	rows, err := Query(ctx,
+		"INSERT INTO MCB.METRICS_T ( "+metricsColumns+" ) "+
+			"SELECT $1, $2, "+
+			"( SELECT COUNT(*) FROM MCB.CHECKBOX_T WHERE CHECKED_STATE ), "+
+			"COUNT(*) FILTER ( WHERE SUCCESS AND CHECKED ), "+
+			"COUNT(*) FILTER ( WHERE SUCCESS AND NOT CHECKED ), "+
+			"COUNT(*) FILTER ( WHERE NOT SUCCESS ), "+
+			"COUNT(DISTINCT UPDATED_BY), "+
+			"$3, "+
+			"( EXTRACT(EPOCH FROM MAX(UPDATE_DATE - REQUEST_TIME)) * 1000 )::BIGINT "+
+			"FROM MCB.UPDATE_T "+
+			"WHERE UPDATE_DATE >= $1 AND UPDATE_DATE < $2 "+
+			"ON CONFLICT ( INTERVAL_END ) DO NOTHING "+
+			"RETURNING "+metricsColumns,
+		intervalStart, intervalEnd, queueDepth)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to insert metrics inside InsertMetrics(%v, %v, %d)", intervalStart, intervalEnd, queueDepth)
+		return Metrics{}, false, wrapDatabaseError(err, "failed to insert metrics")
+	}
+
+	metrics, apierr := scanMetrics(rows)
+	if apierr != nil {
+		log.Error().Err(apierr).Msgf("failed to scan metrics inside InsertMetrics(%v, %v, %d)", intervalStart, intervalEnd, queueDepth)
+		return Metrics{}, false, apierr
+	}
+	if len(metrics) == 0 {
+		return Metrics{}, false, nil
+	}
+
+	return metrics[0], true, nil
+}
+
+// GetLatestMetrics returns the most recent interval in METRICS_T, and false if there is none. Metrics are only
+// computed on Postgres, so there are none with any other DATABASE_PROVIDER.
+func GetLatestMetrics(ctx context.Context) (Metrics, bool, apierror.APIError) {
+	if DatabaseProvider() != ProviderPostgres {
+		return Metrics{}, false, nil
+	}
+
+	rows, err := Query(ctx, "SELECT "+metricsColumns+" FROM MCB.METRICS_T ORDER BY INTERVAL_END DESC LIMIT 1")
+	if err != nil {
+		log.Error().Err(err).Msg("failed to query metrics inside GetLatestMetrics()")
+		return Metrics{}, false, wrapDatabaseError(err, "failed to query metrics")
+	}
+
+	metrics, apierr := scanMetrics(rows)
+	if apierr != nil {
+		log.Error().Err(apierr).Msg("failed to scan metrics inside GetLatestMetrics()")
+		return Metrics{}, false, apierr
+	}
+	if len(metrics) == 0 {
+		return Metrics{}, false, nil
+	}
+
+	return metrics[0], true, nil
+}
+
+// GetMetricsHistory returns the intervals in METRICS_T that ended in [from, to), oldest first, limited to the most
+// recent limit intervals. Like GetLatestMetrics, there are none unless the DATABASE_PROVIDER is Postgres.
+func GetMetricsHistory(ctx context.Context, from time.Time, to time.Time, limit int) ([]Metrics, apierror.APIError) {
+	if DatabaseProvider() != ProviderPostgres {
+		return []Metrics{}, nil
+	}
+
+	rows, err := Query(ctx,
+		"SELECT "+metricsColumns+" FROM ( "+
+			"SELECT "+metricsColumns+" FROM MCB.METRICS_T "+
+			"WHERE INTERVAL_END >= $1 AND INTERVAL_END < $2 "+
+			"ORDER BY INTERVAL_END DESC LIMIT $3 "+
+			") recent ORDER BY INTERVAL_END",
+		from, to, limit)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to query metrics inside GetMetricsHistory(%v, %v, %d)", from, to, limit)
+		return nil, wrapDatabaseError(err, "failed to query metrics")
+	}
+
+	metrics, apierr := scanMetrics(rows)
+	if apierr != nil {
+		log.Error().Err(apierr).Msgf("failed to scan metrics inside GetMetricsHistory(%v, %v, %d)", from, to, limit)
+		return nil, apierr
+	}
+
+	return metrics, nil
+}
+
+// scanMetrics reads every row of metricsColumns, and closes the rows
+func scanMetrics(rows pgx.Rows) ([]Metrics, apierror.APIError) {
+	defer rows.Close()
+
+	metrics := make([]Metrics, 0)
+	for rows.Next() {
+		m := Metrics{}
+		err := rows.Scan(&m.IntervalStart, &m.IntervalEnd, &m.CheckedCount, &m.Checks, &m.Unchecks, &m.FailedCount,
+			&m.ActiveClients, &m.QueueDepth, &m.QueueLagMs)
+		if err != nil {
+			return nil, wrapDatabaseError(err, "failed to scan metrics")
+		}
+		metrics = append(metrics, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapDatabaseError(err, "database iteration error")
+	}
+
+	return metrics, nil
+}
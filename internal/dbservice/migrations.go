@@ -0,0 +1,449 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/database"
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// schemaMigrationsTable has the same layout as golang-migrate's, which setup_database.sh uses: a single row
+	// holding the version of the last migration applied. It doesn't record which migrations were applied, so only
+	// the ones numbered above it are.
+	schemaMigrationsTable = "schema_migrations"
+
+	// baselineSchemaVersion is the version setup_database.sh leaves a database at. Since only the migrations above
+	// the recorded version are applied, every migration added since must be numbered above it, and above any other
+	// migration that may already have been applied somewhere.
+	baselineSchemaVersion = 900
+
+	defaultMigrationLockTimeout = 5 * time.Minute
+)
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one step of the schema, read from a pair of NNN_name.up.sql and NNN_name.down.sql files
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaVersion is the version recorded in the database, Dirty is set when a migration failed part way through
+type SchemaVersion struct {
+	Version int64
+	Dirty   bool
+}
+
+// MigrationStatus is the database's schema version, and the migrations not yet applied to it
+type MigrationStatus struct {
+	Current   *SchemaVersion // nil before the first migration
+	Supported int64
+	Pending   []Migration
+}
+
+// LoadMigrations reads the migrations embedded from database/migrations, in version order
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(database.Migrations, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	directions := make(map[int64]map[string]bool) // the up and down files found for each version, which may be empty
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNN_name.up.sql or NNN_name.down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has an invalid version: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, match[2])
+		}
+		if directions[version] == nil {
+			directions[version] = make(map[string]bool)
+		}
+		directions[version][match[3]] = true
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if !directions[migration.Version]["up"] || !directions[migration.Version]["down"] {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// SupportedSchemaVersion is the version of the newest embedded migration, which is the schema this build runs against
+func SupportedSchemaVersion() (int64, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, fmt.Errorf("no migrations are embedded")
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+// CheckSchemaVersion makes sure the database schema is one this build can run against, so the servers refuse to start
+// against a schema that is behind the version this build supports. A schema a newer build has migrated further is
+// accepted, so the tasks of the old build can still start during a rolling deploy, unless one of its migrations has
+// raised SCHEMA_COMPATIBILITY_T.OLDEST_COMPATIBLE_VERSION above this build's version. The SQLite schema is brought up
+// to date whenever it is opened, so needs no check.
+func CheckSchemaVersion(ctx context.Context) apierror.APIError {
+	if DatabaseProvider() == ProviderSQLite {
+		return nil
+	}
+	if pool == nil {
+		return apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, "database pool not initialized")
+	}
+
+	supported, err := SupportedSchemaVersion()
+	if err != nil {
+		return apierror.WrapWithCodeFromConstants(err, apierror.ErrInternalServer, "failed to load the embedded migrations")
+	}
+
+	current, err := readSchemaVersion(ctx, pool.QueryRow)
+	if err != nil {
+		return wrapDatabaseError(err, "failed to read the schema version")
+	}
+
+	return checkSchemaVersion(ctx, current, supported, func() (int64, error) {
+		var oldest int64
+		err := pool.QueryRow(ctx, "SELECT OLDEST_COMPATIBLE_VERSION FROM MCB.SCHEMA_COMPATIBILITY_T").Scan(&oldest)
+		return oldest, err
+	})
+}
+
+// checkSchemaVersion decides whether a build that supports up to the supported version can run against the current
+// schema, reading the oldest compatible version only if the schema is ahead of the build
+func checkSchemaVersion(ctx context.Context, current *SchemaVersion, supported int64, oldestCompatible func() (int64, error)) apierror.APIError {
+	switch {
+	case current == nil:
+		return apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError,
+			fmt.Sprintf("the database has no schema version, it needs migrating to version %d", supported))
+	case current.Dirty:
+		return apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError,
+			fmt.Sprintf("the database schema is dirty at version %d, a migration failed part way through", current.Version))
+	case current.Version < supported:
+		return apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError,
+			fmt.Sprintf("the database schema is at version %d, this build needs it migrated to version %d", current.Version, supported))
+	case current.Version > supported:
+		oldest, err := oldestCompatible()
+		if err != nil {
+			return wrapDatabaseError(err, "failed to read the oldest compatible schema version")
+		}
+		if supported < oldest {
+			return apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError,
+				fmt.Sprintf("the database schema is at version %d, which needs a build that supports version %d or later, this build supports version %d",
+					current.Version, oldest, supported))
+		}
+		log.Ctx(ctx).Warn().Msgf("Database schema is at version %d, ahead of this build's version %d, which it is still compatible with", current.Version, supported)
+		return nil
+	}
+
+	log.Ctx(ctx).Info().Msgf("Database schema is at supported version %d", supported)
+	return nil
+}
+
+// pendingMigrations returns the migrations above the current version, which are the ones Up applies
+func pendingMigrations(migrations []Migration, current *SchemaVersion) []Migration {
+	var pending []Migration
+	for _, migration := range migrations {
+		if current == nil || migration.Version > current.Version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// readSchemaVersion returns the recorded version, or nil if there is none. A missing table is reported as no version.
+func readSchemaVersion(ctx context.Context, queryRow func(context.Context, string, ...any) pgx.Row) (*SchemaVersion, error) {
+	current := SchemaVersion{}
+	err := queryRow(ctx, "SELECT VERSION, DIRTY FROM "+schemaMigrationsTable+" LIMIT 1").Scan(&current.Version, &current.Dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42P01" { // undefined_table
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &current, nil
+}
+
+// Migrator applies the embedded migrations. It holds its own connection, with the MIGRATION_DATABASE_USER
+// credentials since migrating needs more than the servers' user is granted, and a session advisory lock on it, so
+// only one migrator runs against the database at a time.
+type Migrator struct {
+	conn       *pgx.Conn
+	migrations []Migration
+	lockKey    int64
+}
+
+// NewMigrator connects and takes the migration lock, waiting for up to MIGRATION_LOCK_TIMEOUT for another migrator to
+// finish. Close releases it.
+func NewMigrator(ctx context.Context) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations are embedded")
+	}
+
+	user := apiconfig.GetStringWithDefault("MIGRATION_DATABASE_USER", apiconfig.GetString("DATABASE_USER"))
+	password := apiconfig.GetStringWithDefault("MIGRATION_DATABASE_PASSWORD", apiconfig.GetString("DATABASE_PASSWORD"))
+	connStr, err := connectionString(user, password)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.Connect(ctx, connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect for migrating: %w", err)
+	}
+
+	m := &Migrator{
+		conn:       conn,
+		migrations: migrations,
+		lockKey:    migrationLockKey(),
+	}
+
+	lockTimeout := apiconfig.GetDurationWithDefault("MIGRATION_LOCK_TIMEOUT", defaultMigrationLockTimeout)
+	log.Ctx(ctx).Info().Msgf("Taking the migration lock, waiting up to %v", lockTimeout)
+	lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+	defer cancel()
+	_, err = conn.Exec(lockCtx, "SELECT pg_advisory_lock($1)", m.lockKey)
+	if err != nil {
+		_ = conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+
+	// created up front, like golang-migrate does, so the grants migration can grant on it
+	_, err = conn.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+schemaMigrationsTable+" (VERSION BIGINT NOT NULL PRIMARY KEY, DIRTY BOOLEAN NOT NULL)")
+	if err != nil {
+		m.Close(ctx)
+		return nil, fmt.Errorf("failed to create %s: %w", schemaMigrationsTable, err)
+	}
+
+	return m, nil
+}
+
+// migrationLockKey is the advisory lock key, prefixed like the leader election keys so they can't collide
+func migrationLockKey() int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte("mcb-migrate"))
+	return int64(h.Sum64())
+}
+
+// Close releases the migration lock and the connection
+func (m *Migrator) Close(ctx context.Context) {
+	_, err := m.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", m.lockKey)
+	if err != nil {
+		log.Ctx(ctx).Warn().Err(err).Msg("failed to release the migration lock, closing the connection releases it")
+	}
+	_ = m.conn.Close(ctx)
+}
+
+// Status returns the current schema version and the migrations still to apply
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	current, err := readSchemaVersion(ctx, m.conn.QueryRow)
+	if err != nil {
+		return MigrationStatus{}, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+
+	return MigrationStatus{
+		Current:   current,
+		Supported: m.migrations[len(m.migrations)-1].Version,
+		Pending:   pendingMigrations(m.migrations, current),
+	}, nil
+}
+
+// Up applies every pending migration
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.Goto(ctx, m.migrations[len(m.migrations)-1].Version)
+}
+
+// Down reverts the given number of the most recently applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	current, err := m.cleanVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return fmt.Errorf("no migrations have been applied")
+	}
+
+	applied := m.appliedThrough(current.Version)
+	if steps > len(applied) {
+		return fmt.Errorf("only %d migrations have been applied, cannot revert %d", len(applied), steps)
+	}
+	target := int64(0)
+	if steps < len(applied) {
+		target = applied[len(applied)-steps-1].Version
+	}
+	return m.Goto(ctx, target)
+}
+
+// Goto migrates up or down to the given version, which must be one of the migrations, or 0 to revert them all
+func (m *Migrator) Goto(ctx context.Context, version int64) error {
+	if version != 0 && m.find(version) < 0 {
+		return fmt.Errorf("there is no migration %d", version)
+	}
+
+	current, err := m.cleanVersion(ctx)
+	if err != nil {
+		return err
+	}
+	currentVersion := int64(0)
+	if current != nil {
+		currentVersion = current.Version
+		if currentVersion != 0 && m.find(currentVersion) < 0 {
+			return fmt.Errorf("the database is at version %d, which this build does not have a migration for", currentVersion)
+		}
+	}
+
+	if version >= currentVersion {
+		for _, migration := range pendingMigrations(m.migrations, current) {
+			if migration.Version <= version {
+				if err := m.apply(ctx, migration, migration.Up, migration.Version); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		applied := m.appliedThrough(currentVersion)
+		for i := len(applied) - 1; i >= 0 && applied[i].Version > version; i-- {
+			previous := int64(0)
+			if i > 0 {
+				previous = applied[i-1].Version
+			}
+			if err := m.apply(ctx, applied[i], applied[i].Down, previous); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Ctx(ctx).Info().Msgf("Database schema is at version %d", version)
+	return nil
+}
+
+// apply runs one migration file and records the version it leaves the schema at, in one transaction, so a failed
+// migration leaves the schema as it was
+func (m *Migrator) apply(ctx context.Context, migration Migration, sql string, newVersion int64) error {
+	direction := "up"
+	if newVersion < migration.Version {
+		direction = "down"
+	}
+	log.Ctx(ctx).Info().Msgf("Migrating %s %d_%s", direction, migration.Version, migration.Name)
+	starttime := time.Now()
+
+	tx, err := m.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin migration %d: %w", migration.Version, err)
+	}
+	defer func() {
+		// a no-op once committed
+		_ = tx.Rollback(context.Background())
+	}()
+
+	// no arguments, so the file goes as a simple query, which may hold several statements. A file may also be empty,
+	// such as 030_metrics_t from before METRICS_T was designed, and then only the version moves.
+	if strings.TrimSpace(sql) != "" {
+		if _, err := tx.Exec(ctx, sql); err != nil {
+			return fmt.Errorf("migration %d_%s %s failed: %w", migration.Version, migration.Name, direction, err)
+		}
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM "+schemaMigrationsTable); err != nil {
+		return fmt.Errorf("failed to clear the schema version: %w", err)
+	}
+	if newVersion > 0 {
+		if _, err := tx.Exec(ctx, "INSERT INTO "+schemaMigrationsTable+" (VERSION, DIRTY) VALUES ($1, FALSE)", newVersion); err != nil {
+			return fmt.Errorf("failed to record schema version %d: %w", newVersion, err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+
+	log.Ctx(ctx).Info().Msgf("Migrated %s %d_%s in %v", direction, migration.Version, migration.Name, time.Since(starttime))
+	return nil
+}
+
+// cleanVersion returns the current version, refusing to go on from a dirty one, which only golang-migrate leaves
+// behind, since the Migrator applies each migration in a transaction
+func (m *Migrator) cleanVersion(ctx context.Context) (*SchemaVersion, error) {
+	current, err := readSchemaVersion(ctx, m.conn.QueryRow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the schema version: %w", err)
+	}
+	if current != nil && current.Dirty {
+		return nil, fmt.Errorf("the database schema is dirty at version %d, repair it by hand and clear the DIRTY flag in %s", current.Version, schemaMigrationsTable)
+	}
+	return current, nil
+}
+
+// appliedThrough returns the migrations up to and including version, in order
+func (m *Migrator) appliedThrough(version int64) []Migration {
+	applied := make([]Migration, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		if migration.Version <= version {
+			applied = append(applied, migration)
+		}
+	}
+	return applied
+}
+
+// find returns the index of the migration with the given version, or -1
+func (m *Migrator) find(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,203 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		// the baseline's 030_metrics_t was left empty, METRICS_T is created by a later migration
+		if migration.Version != 30 {
+			assert.NotEmpty(t, migration.Up, "migration %d", migration.Version)
+			assert.NotEmpty(t, migration.Down, "migration %d", migration.Version)
+		}
+		if i > 0 {
+			assert.Greater(t, migration.Version, migrations[i-1].Version)
+		}
+	}
+	assert.Equal(t, int64(5), migrations[0].Version)
+	assert.Equal(t, "init_schema", migrations[0].Name)
+
+	supported, err := SupportedSchemaVersion()
+	require.NoError(t, err)
+	assert.Equal(t, migrations[len(migrations)-1].Version, supported)
+}
+
+func TestLoadMigrationsValidation(t *testing.T) {
+	file := func(body string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(body)}
+	}
+
+	tests := []struct {
+		name          string
+		files         fstest.MapFS
+		expectedOrder []int64
+		expectedError string
+	}{
+		{
+			name: "Ordered by version, not name",
+			files: fstest.MapFS{
+				"migrations/100_b.up.sql":   file("up"),
+				"migrations/100_b.down.sql": file("down"),
+				"migrations/20_a.up.sql":    file("up"),
+				"migrations/20_a.down.sql":  file("down"),
+			},
+			expectedOrder: []int64{20, 100},
+		},
+		{
+			name: "Missing down",
+			files: fstest.MapFS{
+				"migrations/010_a.up.sql": file("up"),
+			},
+			expectedError: "needs both an up and a down file",
+		},
+		{
+			name: "Empty files",
+			files: fstest.MapFS{
+				"migrations/010_a.up.sql":   file(""),
+				"migrations/010_a.down.sql": file(""),
+			},
+			expectedOrder: []int64{10},
+		},
+		{
+			name: "Names differ",
+			files: fstest.MapFS{
+				"migrations/010_a.up.sql":   file("up"),
+				"migrations/010_b.down.sql": file("down"),
+			},
+			expectedError: "is named both",
+		},
+		{
+			name: "Bad file name",
+			files: fstest.MapFS{
+				"migrations/readme.txt": file("notes"),
+			},
+			expectedError: "is not named",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "migrations")
+			if tt.expectedError != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			versions := make([]int64, len(migrations))
+			for i, migration := range migrations {
+				versions[i] = migration.Version
+			}
+			assert.Equal(t, tt.expectedOrder, versions)
+		})
+	}
+}
+
+// baselineMigrations are the migrations setup_database.sh applies
+var baselineMigrations = map[int64]bool{5: true, 10: true, 15: true, 20: true, 25: true, 30: true, 200: true, 900: true}
+
+func TestMigrationsAboveBaseline(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+
+	// a new migration numbered below the baseline would never be applied to a database set up by setup_database.sh
+	for _, migration := range migrations {
+		if !baselineMigrations[migration.Version] {
+			assert.Greater(t, migration.Version, int64(baselineSchemaVersion), "migration %d_%s", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestPendingMigrationsFromBaseline(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+
+	pending := pendingMigrations(migrations, &SchemaVersion{Version: baselineSchemaVersion})
+	require.Len(t, pending, len(migrations)-len(baselineMigrations))
+	for _, migration := range pending {
+		assert.False(t, baselineMigrations[migration.Version], "migration %d_%s", migration.Version, migration.Name)
+	}
+
+	assert.Len(t, pendingMigrations(migrations, nil), len(migrations))
+	assert.Empty(t, pendingMigrations(migrations, &SchemaVersion{Version: migrations[len(migrations)-1].Version}))
+}
+
+func TestCheckSchemaVersion(t *testing.T) {
+	oldest := func(version int64) func() (int64, error) {
+		return func() (int64, error) { return version, nil }
+	}
+
+	tests := []struct {
+		name             string
+		current          *SchemaVersion
+		oldestCompatible func() (int64, error)
+		expectedError    string
+	}{
+		{
+			name:    "Same version",
+			current: &SchemaVersion{Version: 980},
+		},
+		{
+			name:             "Ahead and still compatible",
+			current:          &SchemaVersion{Version: 990},
+			oldestCompatible: oldest(980),
+		},
+		{
+			name:             "Ahead and no longer compatible",
+			current:          &SchemaVersion{Version: 990},
+			oldestCompatible: oldest(985),
+			expectedError:    "needs a build that supports version 985 or later",
+		},
+		{
+			name:             "Ahead and the oldest compatible version can't be read",
+			current:          &SchemaVersion{Version: 990},
+			oldestCompatible: func() (int64, error) { return 0, errors.New("relation does not exist") },
+			expectedError:    "failed to read the oldest compatible schema version",
+		},
+		{
+			name:          "Behind",
+			current:       &SchemaVersion{Version: 975},
+			expectedError: "needs it migrated to version 980",
+		},
+		{
+			name:          "Dirty",
+			current:       &SchemaVersion{Version: 980, Dirty: true},
+			expectedError: "dirty",
+		},
+		{
+			name:          "Never migrated",
+			expectedError: "no schema version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldestCompatible := tt.oldestCompatible
+			if oldestCompatible == nil {
+				oldestCompatible = func() (int64, error) {
+					t.Fatal("the oldest compatible version is only read when the schema is ahead")
+					return 0, nil
+				}
+			}
+
+			err := checkSchemaVersion(context.Background(), tt.current, 980, oldestCompatible)
+			if tt.expectedError == "" {
+				assert.Nil(t, err)
+				return
+			}
+			require.NotNil(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package dbservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// OutboxEvent is a checkbox update event waiting in OUTBOX_T to be published
+type OutboxEvent struct {
+	OutboxId    int64
+	EventType   string
+	CheckboxNbr int
+	Checked     bool
+	UpdatedBy   uuid.UUID
+	RequestUuid uuid.UUID
+	Success     bool
+	Reason      string
+	EventDate   time.Time
+}
+
+// insertOutboxEventsTx writes the update events for a resolved request into OUTBOX_T, as part of the caller's
+// transaction: a CheckboxChanged event if the decision was applied, and a RequestComplete event always
+func insertOutboxEventsTx(ctx context.Context, tx pgx.Tx, request conflictpolicy.CheckboxRequest, decision conflictpolicy.Decision) error {
+	for _, eventType := range outboxEventTypes(decision) {
+		_, err := ExecTx(ctx, tx, "INSERT INTO MCB.OUTBOX_T "+
+			"( EVENT_TYPE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REASON ) "+
+			"VALUES ( $1, $2, $3, $4, $5, $6, $7 )",
+			eventType, request.CheckboxNbr, decision.Checked, request.UserUuid, request.RequestUuid, decision.Apply, decision.Reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// outboxEventTypes returns the events a resolved request raises, in the order they are published
+func outboxEventTypes(decision conflictpolicy.Decision) []string {
+	eventTypes := make([]string, 0, 2)
+	if decision.Apply {
+		eventTypes = append(eventTypes, queueservice.CheckboxUpdateEventChanged)
+	}
+	return append(eventTypes, queueservice.CheckboxUpdateEventRequestComplete)
+}
+
+// GetPendingOutboxEvents returns up to limit unsent events from OUTBOX_T, oldest first, from the SQLite database if
+// that is the repository in use
+func GetPendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, apierror.APIError) {
+	if repository, ok := GetCheckboxRepository().(*SQLiteCheckboxRepository); ok {
+		return repository.getPendingOutboxEvents(ctx, limit)
+	}
+
+	rows, err := Query(ctx,
+		"SELECT OUTBOX_ID, EVENT_TYPE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REASON, EVENT_DATE "+
+			"FROM MCB.OUTBOX_T "+
+			"WHERE SENT_DATE IS NULL "+
+			"ORDER BY OUTBOX_ID "+
+			"LIMIT $1",
+		limit)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to query outbox inside GetPendingOutboxEvents(%d)", limit)
+		return nil, wrapDatabaseError(err, "failed to query outbox")
+	}
+	defer rows.Close()
+
+	events := make([]OutboxEvent, 0, limit)
+	for rows.Next() {
+		event := OutboxEvent{}
+		err := rows.Scan(&event.OutboxId, &event.EventType, &event.CheckboxNbr, &event.Checked, &event.UpdatedBy,
+			&event.RequestUuid, &event.Success, &event.Reason, &event.EventDate)
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to scan outbox event inside GetPendingOutboxEvents(%d)", limit)
+			return nil, wrapDatabaseError(err, "failed to scan outbox event")
+		}
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Error().Err(err).Msgf("rows iteration error inside GetPendingOutboxEvents(%d)", limit)
+		return nil, wrapDatabaseError(err, "database iteration error")
+	}
+
+	return events, nil
+}
+
+// MarkOutboxEventsSent records that the given outbox events have been published. SQLite has no purge job, so they are
+// deleted from its outbox instead.
+func MarkOutboxEventsSent(ctx context.Context, outboxIds []int64) apierror.APIError {
+	if len(outboxIds) == 0 {
+		return nil
+	}
+	if repository, ok := GetCheckboxRepository().(*SQLiteCheckboxRepository); ok {
+		return repository.deleteOutboxEvents(ctx, outboxIds)
+	}
+
+	_, err := Exec(ctx, "UPDATE MCB.OUTBOX_T SET SENT_DATE = NOW() WHERE OUTBOX_ID = ANY($1)", outboxIds)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to mark %d outbox events sent inside MarkOutboxEventsSent", len(outboxIds))
+		return wrapDatabaseError(err, "failed to mark outbox events sent")
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package dbservice
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// queryTracer records a span for each query, batch and copy run through pgx, and times it, see recordStatement. The
+// statement is recorded on the span without its arguments, which may hold user data. A query's time runs until its
+// rows are closed, so it includes reading them.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = startDbSpan(ctx, conn, spanNameForSQL(data.SQL), semconv.DBQueryText(data.SQL))
+	return withStatementStart(ctx, statementSummary(data.SQL), data.SQL, data.Args)
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	tracing.RecordError(span, data.Err)
+	span.End()
+	recordStatement(ctx, data.Err, data.CommandTag.RowsAffected())
+}
+
+func (queryTracer) TraceBatchStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	size := 0
+	if data.Batch != nil {
+		size = data.Batch.Len()
+	}
+	ctx, _ = startDbSpan(ctx, conn, "batch", attribute.Int("db.operation.batch.size", size))
+	return withStatementStart(ctx, "BATCH", fmt.Sprintf("batch of %d statements", size), nil)
+}
+
+func (queryTracer) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	// one event per statement on the batch span, rather than a span each
+	span := trace.SpanFromContext(ctx)
+	attrs := []attribute.KeyValue{semconv.DBQueryText(data.SQL)}
+	if data.Err != nil {
+		attrs = append(attrs, attribute.String("error", data.Err.Error()))
+	}
+	span.AddEvent("batch query", trace.WithAttributes(attrs...))
+}
+
+func (queryTracer) TraceBatchEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchEndData) {
+	span := trace.SpanFromContext(ctx)
+	tracing.RecordError(span, data.Err)
+	span.End()
+	recordStatement(ctx, data.Err, 0)
+}
+
+func (queryTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	ctx, _ = startDbSpan(ctx, conn, "copy "+data.TableName.Sanitize(),
+		semconv.DBCollectionName(data.TableName.Sanitize()),
+		semconv.DBOperationName("copy"))
+	return withStatementStart(ctx, "COPY "+strings.ToUpper(strings.Join(data.TableName, ".")), "COPY "+data.TableName.Sanitize(), nil)
+}
+
+func (queryTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	tracing.RecordError(span, data.Err)
+	span.End()
+	recordStatement(ctx, data.Err, data.CommandTag.RowsAffected())
+}
+
+func startDbSpan(ctx context.Context, conn *pgx.Conn, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.DBSystemPostgreSQL)
+	if conn != nil {
+		attrs = append(attrs, semconv.DBNamespace(conn.Config().Database))
+	}
+	return tracing.StartSpan(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// spanNameForSQL names a query's span after its first keyword, eg SELECT or UPDATE, which keeps the span names few
+// enough to group by
+func spanNameForSQL(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
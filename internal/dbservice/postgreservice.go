@@ -3,6 +3,7 @@ package dbservice
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -13,6 +14,14 @@ import (
 	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
 )
 
+const (
+	defaultPoolMaxConns          = 25
+	defaultPoolMinConns          = 5
+	defaultPoolMaxConnLifetime   = time.Hour
+	defaultPoolMaxConnIdleTime   = 30 * time.Minute
+	defaultPoolHealthCheckPeriod = 30 * time.Second
+)
+
 var pool *pgxpool.Pool
 
 // InitializePool creates and configures the PostgreSQL connection pool
@@ -22,35 +31,41 @@ func InitializePool(ctx context.Context) error {
 	}
 
 	appconfig := apiconfig.GetConfig()
-	dburl := appconfig.GetString("DATABASE_URL")
-	dbuser := appconfig.GetString("DATABASE_USER")
-	dbpassword := appconfig.GetString("DATABASE_PASSWORD")
-
-	if dburl == "" {
-		return fmt.Errorf("DATABASE_URL is required")
-	}
-	if dbuser == "" {
-		return fmt.Errorf("DATABASE_USER is required")
-	}
-	if dbpassword == "" {
-		return fmt.Errorf("DATABASE_PASSWORD is required")
+	connStr, err := connectionString(appconfig.GetString("DATABASE_USER"), appconfig.GetString("DATABASE_PASSWORD"))
+	if err != nil {
+		return err
 	}
 
-	// Build connection string with credentials
-	connStr := fmt.Sprintf("%s?user=%s&password=%s", dburl, dbuser, dbpassword)
-
 	// Configure connection pool
 	config, err := pgxpool.ParseConfig(connStr)
 	if err != nil {
 		return fmt.Errorf("failed to parse database config: %w", err)
 	}
 
-	// Set pool configuration with static defaults
-	config.MaxConns = 25
-	config.MinConns = 5
-	config.MaxConnLifetime = time.Hour
-	config.MaxConnIdleTime = 30 * time.Minute
-	config.HealthCheckPeriod = 30 * time.Second
+	// Set pool configuration, the defaults suit a single API server or backend against a small instance
+	config.MaxConns = int32(apiconfig.GetIntWithDefault("DB_POOL_MAX_CONNS", defaultPoolMaxConns))
+	config.MinConns = int32(apiconfig.GetIntWithDefault("DB_POOL_MIN_CONNS", defaultPoolMinConns))
+	config.MaxConnLifetime = apiconfig.GetDurationWithDefault("DB_POOL_MAX_CONN_LIFETIME", defaultPoolMaxConnLifetime)
+	config.MaxConnIdleTime = apiconfig.GetDurationWithDefault("DB_POOL_MAX_CONN_IDLE_TIME", defaultPoolMaxConnIdleTime)
+	config.HealthCheckPeriod = apiconfig.GetDurationWithDefault("DB_POOL_HEALTH_CHECK_PERIOD", defaultPoolHealthCheckPeriod)
+	if config.MaxConns < 1 {
+		return fmt.Errorf("DB_POOL_MAX_CONNS must be at least 1, got %d", config.MaxConns)
+	}
+	if config.MinConns < 0 || config.MinConns > config.MaxConns {
+		return fmt.Errorf("DB_POOL_MIN_CONNS must be between 0 and DB_POOL_MAX_CONNS (%d), got %d", config.MaxConns, config.MinConns)
+	}
+
+	// the server cancels any statement running longer than DB_STATEMENT_TIMEOUT, a backstop for the per-operation
+	// context deadlines, which rely on the client being able to send the cancel
+	if statementTimeout := apiconfig.GetDurationWithDefault("DB_STATEMENT_TIMEOUT", 0); statementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(statementTimeout.Milliseconds(), 10)
+	}
+
+	// record a span for every statement and time it, see queryTracer
+	if err := initializeStatementLog(); err != nil {
+		return err
+	}
+	config.ConnConfig.Tracer = queryTracer{}
 
 	// Create the pool
 	pool, err = pgxpool.NewWithConfig(ctx, config)
@@ -63,33 +78,57 @@ func InitializePool(ctx context.Context) error {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	log.Info().
+	// reads can go to replicas, see readPool
+	if err := initializeReplicaPools(ctx, config); err != nil {
+		return err
+	}
+
+	log.Ctx(ctx).Info().
 		Int32("max_conns", config.MaxConns).
 		Int32("min_conns", config.MinConns).
 		Dur("max_conn_lifetime", config.MaxConnLifetime).
 		Dur("max_conn_idle_time", config.MaxConnIdleTime).
+		Dur("health_check_period", config.HealthCheckPeriod).
 		Msg("PostgreSQL connection pool initialized successfully")
 
 	return nil
 }
 
-// Query executes a parameterized query that returns zero to many rows
+// connectionString builds the connection string for DATABASE_URL, with the given credentials
+func connectionString(dbuser string, dbpassword string) (string, error) {
+	dburl := apiconfig.GetString("DATABASE_URL")
+
+	if dburl == "" {
+		return "", fmt.Errorf("DATABASE_URL is required")
+	}
+	if dbuser == "" {
+		return "", fmt.Errorf("DATABASE_USER is required")
+	}
+	if dbpassword == "" {
+		return "", fmt.Errorf("DATABASE_PASSWORD is required")
+	}
+
+	return withCredentials(dburl, dbuser, dbpassword), nil
+}
+
+// withCredentials adds the credentials to a database url
+func withCredentials(dburl string, dbuser string, dbpassword string) string {
+	return fmt.Sprintf("%s?user=%s&password=%s", dburl, dbuser, dbpassword)
+}
+
+// Query executes a parameterized query that returns zero to many rows. It goes to a replica if the context is for a
+// kind of operation that replicas serve, see readPool.
 func Query(ctx context.Context, query string, args ...any) (pgx.Rows, error) {
 	if pool == nil {
 		return nil, fmt.Errorf("database pool not initialized")
 	}
 
-	log.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing query")
-
-	rows, err := pool.Query(ctx, query, args...)
+	rows, err := readPool(ctx).Query(ctx, query, args...)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Str("query", query).
-			Interface("args", args).
+			Interface("args", redactArgs(args)).
 			Msg("Query execution failed")
 		return nil, fmt.Errorf("query execution failed: %w", err)
 	}
@@ -104,31 +143,23 @@ func Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, er
 		return pgconn.CommandTag{}, fmt.Errorf("database pool not initialized")
 	}
 
-	log.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing command")
-
 	tag, err := pool.Exec(ctx, query, args...)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Str("query", query).
-			Interface("args", args).
+			Interface("args", redactArgs(args)).
 			Msg("Command execution failed")
 		return pgconn.CommandTag{}, fmt.Errorf("command execution failed: %w", err)
 	}
 
-	log.Debug().
-		Str("query", query).
-		Int64("rows_affected", tag.RowsAffected()).
-		Msg("Command executed successfully")
-
 	return tag, nil
 }
 
-// ClosePool closes the connection pool
+// ClosePool closes the connection pool, or the sqlite database
 func ClosePool() {
+	closeSQLite()
+	closeReplicaPools()
 	if pool != nil {
 		pool.Close()
 		pool = nil
@@ -150,17 +181,17 @@ func BeginTx(ctx context.Context) (pgx.Tx, error) {
 		return nil, fmt.Errorf("database pool not initialized")
 	}
 
-	log.Debug().Msg("Beginning database transaction")
+	log.Ctx(ctx).Debug().Msg("Beginning database transaction")
 
 	tx, err := pool.Begin(ctx)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Msg("Failed to begin transaction")
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 
-	log.Debug().Msg("Transaction started successfully")
+	log.Ctx(ctx).Debug().Msg("Transaction started successfully")
 	return tx, nil
 }
 
@@ -170,17 +201,12 @@ func QueryTx(ctx context.Context, tx pgx.Tx, query string, args ...any) (pgx.Row
 		return nil, fmt.Errorf("transaction is nil")
 	}
 
-	log.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing query in transaction")
-
 	rows, err := tx.Query(ctx, query, args...)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Str("query", query).
-			Interface("args", args).
+			Interface("args", redactArgs(args)).
 			Msg("Query execution failed in transaction")
 		return nil, fmt.Errorf("query execution failed in transaction: %w", err)
 	}
@@ -195,26 +221,16 @@ func ExecTx(ctx context.Context, tx pgx.Tx, query string, args ...any) (pgconn.C
 		return pgconn.CommandTag{}, fmt.Errorf("transaction is nil")
 	}
 
-	log.Debug().
-		Str("query", query).
-		Interface("args", args).
-		Msg("Executing command in transaction")
-
 	tag, err := tx.Exec(ctx, query, args...)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Str("query", query).
-			Interface("args", args).
+			Interface("args", redactArgs(args)).
 			Msg("Command execution failed in transaction")
 		return pgconn.CommandTag{}, fmt.Errorf("command execution failed in transaction: %w", err)
 	}
 
-	log.Debug().
-		Str("query", query).
-		Int64("rows_affected", tag.RowsAffected()).
-		Msg("Command executed successfully in transaction")
-
 	return tag, nil
 }
 
@@ -224,17 +240,17 @@ func CommitTx(ctx context.Context, tx pgx.Tx) error {
 		return fmt.Errorf("transaction is nil")
 	}
 
-	log.Debug().Msg("Committing transaction")
+	log.Ctx(ctx).Debug().Msg("Committing transaction")
 
 	err := tx.Commit(ctx)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Msg("Failed to commit transaction")
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	log.Debug().Msg("Transaction committed successfully")
+	log.Ctx(ctx).Debug().Msg("Transaction committed successfully")
 	return nil
 }
 
@@ -244,16 +260,47 @@ func RollbackTx(ctx context.Context, tx pgx.Tx) error {
 		return fmt.Errorf("transaction is nil")
 	}
 
-	log.Debug().Msg("Rolling back transaction")
+	log.Ctx(ctx).Debug().Msg("Rolling back transaction")
 
 	err := tx.Rollback(ctx)
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Msg("Failed to rollback transaction")
 		return fmt.Errorf("failed to rollback transaction: %w", err)
 	}
 
-	log.Debug().Msg("Transaction rolled back successfully")
+	log.Ctx(ctx).Debug().Msg("Transaction rolled back successfully")
 	return nil
 }
+
+// CopyFromTx bulk inserts rows into a table within a transaction using the COPY protocol,
+// and returns the number of rows copied
+func CopyFromTx(ctx context.Context, tx pgx.Tx, tableName pgx.Identifier, columns []string, rows [][]any) (int64, error) {
+	if tx == nil {
+		return 0, fmt.Errorf("transaction is nil")
+	}
+
+	log.Ctx(ctx).Debug().
+		Strs("table", tableName).
+		Strs("columns", columns).
+		Int("rows", len(rows)).
+		Msg("Copying rows in transaction")
+
+	copied, err := tx.CopyFrom(ctx, tableName, columns, pgx.CopyFromRows(rows))
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Strs("table", tableName).
+			Int("rows", len(rows)).
+			Msg("Copy failed in transaction")
+		return 0, fmt.Errorf("copy failed in transaction: %w", err)
+	}
+
+	log.Ctx(ctx).Debug().
+		Strs("table", tableName).
+		Int64("rows_copied", copied).
+		Msg("Copy completed successfully in transaction")
+
+	return copied, nil
+}
@@ -0,0 +1,60 @@
+package dbservice
+
+import (
+	"context"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/rs/zerolog/log"
+)
+
+// Values for the DATABASE_PROVIDER config key
+const (
+	ProviderPostgres = "postgres" // the default
+	ProviderSQLite   = "sqlite"   // a single node, see SQLiteCheckboxRepository
+)
+
+const (
+	defaultSQLitePath        = "work/mcb.db"
+	defaultSQLiteBusyTimeout = 5 * time.Second
+)
+
+var sqliteRepository *SQLiteCheckboxRepository
+
+// DatabaseProvider returns the database in use, from the DATABASE_PROVIDER config key
+func DatabaseProvider() string {
+	if apiconfig.GetStringWithDefault("DATABASE_PROVIDER", ProviderPostgres) == ProviderSQLite {
+		return ProviderSQLite
+	}
+	return ProviderPostgres
+}
+
+// initSQLite opens the SQLITE_PATH database and makes it the checkbox repository, which holds the outbox too. The
+// change feed, leader election, metrics and snapshots are Postgres only, so aren't available.
+func initSQLite(ctx context.Context) apierror.APIError {
+	if sqliteRepository != nil {
+		return nil // Already initialized
+	}
+
+	path := apiconfig.GetStringWithDefault("SQLITE_PATH", defaultSQLitePath)
+	busyTimeout := apiconfig.GetDurationWithDefault("SQLITE_BUSY_TIMEOUT", defaultSQLiteBusyTimeout)
+	repository, err := NewSQLiteCheckboxRepository(ctx, path, checkboxCount, busyTimeout)
+	if err != nil {
+		return wrapDatabaseError(err, "failed to open the sqlite database")
+	}
+
+	sqliteRepository = repository
+	SetCheckboxRepository(repository)
+	log.Ctx(ctx).Info().Str("path", path).Msg("SQLite database opened")
+	return nil
+}
+
+// closeSQLite closes the sqlite database, if it is open
+func closeSQLite() {
+	if sqliteRepository != nil {
+		_ = sqliteRepository.Close()
+		sqliteRepository = nil
+		log.Info().Msg("SQLite database closed")
+	}
+}
@@ -0,0 +1,273 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultSlowQueryThreshold = 500 * time.Millisecond
+	defaultLogRedact          = "uuid,ip"
+
+	redactedUUID = "<uuid>"
+	redactedIP   = "<ip>"
+)
+
+// redactionRule replaces an argument that may identify a user, and reports whether it did
+type redactionRule func(arg any) (any, bool)
+
+var redactionRules = map[string]redactionRule{
+	"uuid": redactUUID,
+	"ip":   redactIP,
+}
+
+var (
+	slowQueryThreshold = defaultSlowQueryThreshold
+	activeRedactions   = mustParseRedactions(defaultLogRedact)
+)
+
+// statementDuration is made on the global meter, which records to the provider InitMetricsFromConfig sets up, even
+// if that happens later. It is a no-op instrument if the meter can't make it.
+var statementDuration, _ = tracing.Meter().Float64Histogram("db.client.operation.duration",
+	metric.WithDescription("Duration of database statements"),
+	metric.WithUnit("s"),
+	metric.WithExplicitBucketBoundaries(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120))
+
+// initializeStatementLog reads DB_SLOW_QUERY_THRESHOLD and DB_LOG_REDACT
+func initializeStatementLog() error {
+	slowQueryThreshold = apiconfig.GetDurationWithDefault("DB_SLOW_QUERY_THRESHOLD", defaultSlowQueryThreshold)
+
+	rules, err := parseRedactions(apiconfig.GetStringWithDefault("DB_LOG_REDACT", defaultLogRedact))
+	if err != nil {
+		return err
+	}
+	activeRedactions = rules
+	return nil
+}
+
+// parseRedactions reads DB_LOG_REDACT, the comma separated redaction rules applied to logged arguments, or none
+func parseRedactions(value string) ([]redactionRule, error) {
+	var rules []redactionRule
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || name == "none" {
+			continue
+		}
+		rule, ok := redactionRules[name]
+		if !ok {
+			return nil, fmt.Errorf("DB_LOG_REDACT can only hold uuid, ip or none, got %q", name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func mustParseRedactions(value string) []redactionRule {
+	rules, err := parseRedactions(value)
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+// redactArgs returns a copy of a statement's arguments fit for the log, with the active redaction rules applied
+func redactArgs(args []any) []any {
+	if len(activeRedactions) == 0 || len(args) == 0 {
+		return args
+	}
+	redacted := make([]any, len(args))
+	for i, arg := range args {
+		redacted[i] = redactArg(arg)
+	}
+	return redacted
+}
+
+func redactArg(arg any) any {
+	for _, rule := range activeRedactions {
+		if replacement, ok := rule(arg); ok {
+			return replacement
+		}
+	}
+	return arg
+}
+
+// RedactUser returns a user's UUID as it is logged under DB_LOG_REDACT, for log lines outside the database. When UUIDs
+// are redacted so is a value that isn't a valid one, since it is still whatever the client sent as its user.
+func RedactUser(userUuid string) any {
+	if redactArg(uuid.Nil) == redactedUUID {
+		return redactedUUID
+	}
+	return userUuid
+}
+
+// redactUUID replaces user, request and trace UUIDs, whether passed as UUIDs or strings
+func redactUUID(arg any) (any, bool) {
+	switch v := arg.(type) {
+	case uuid.UUID, *uuid.UUID:
+		return redactedUUID, true
+	case []uuid.UUID:
+		return redactAll(len(v), redactedUUID), true
+	case string:
+		if len(v) == 36 && uuid.Validate(v) == nil {
+			return redactedUUID, true
+		}
+	case []string:
+		return redactStrings(v, redactUUID)
+	}
+	return arg, false
+}
+
+// redactIP replaces client IP addresses, whether passed as addresses or strings
+func redactIP(arg any) (any, bool) {
+	switch v := arg.(type) {
+	case net.IP, netip.Addr, netip.Prefix, *netip.Addr, *net.IPNet:
+		return redactedIP, true
+	case string:
+		if _, err := netip.ParseAddr(v); err == nil {
+			return redactedIP, true
+		}
+		if _, err := netip.ParsePrefix(v); err == nil {
+			return redactedIP, true
+		}
+	case []string:
+		return redactStrings(v, redactIP)
+	}
+	return arg, false
+}
+
+// redactStrings applies a rule to each of a slice of strings, returning a copy if any were replaced
+func redactStrings(values []string, rule redactionRule) (any, bool) {
+	var redacted []string
+	for i, value := range values {
+		replacement, ok := rule(value)
+		if !ok {
+			continue
+		}
+		if redacted == nil {
+			redacted = append([]string(nil), values...)
+		}
+		redacted[i] = fmt.Sprint(replacement)
+	}
+	if redacted == nil {
+		return values, false
+	}
+	return redacted, true
+}
+
+func redactAll(n int, replacement string) []string {
+	redacted := make([]string, n)
+	for i := range redacted {
+		redacted[i] = replacement
+	}
+	return redacted
+}
+
+// statementSummary names a statement for its latency metric, by its first keyword and the first MCB table it names,
+// eg "SELECT MCB.CHECKBOX_T", which keeps the names few enough to group by
+func statementSummary(sql string) string {
+	operation := spanNameForSQL(sql)
+	for _, field := range strings.Fields(sql) {
+		field = strings.ToUpper(strings.TrimLeft(field, "("))
+		if !strings.HasPrefix(field, "MCB.") {
+			continue
+		}
+		end := len("MCB.")
+		for end < len(field) && (field[end] == '_' || field[end] >= 'A' && field[end] <= 'Z' || field[end] >= '0' && field[end] <= '9') {
+			end++
+		}
+		return operation + " " + field[:end]
+	}
+	return operation
+}
+
+// statementStart is what recordStatement needs from the start of a statement
+type statementStart struct {
+	sql     string
+	summary string
+	args    []any
+	start   time.Time
+}
+
+type statementStartKey struct{}
+
+// withStatementStart records a statement's start on its context, for recordStatement at its end
+func withStatementStart(ctx context.Context, summary string, sql string, args []any) context.Context {
+	return context.WithValue(ctx, statementStartKey{}, statementStart{sql: sql, summary: summary, args: args, start: time.Now()})
+}
+
+// recordStatement times a statement started by withStatementStart. Its duration goes to the db.client.operation.duration
+// histogram, and to the debug log with its arguments redacted. A statement over DB_SLOW_QUERY_THRESHOLD is logged at
+// warn, with the trace ID to find the rest of its request by.
+func recordStatement(ctx context.Context, err error, rowsAffected int64) {
+	started, ok := ctx.Value(statementStartKey{}).(statementStart)
+	if !ok {
+		return
+	}
+	duration := time.Since(started.start)
+
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemPostgreSQL,
+		semconv.DBOperationName(spanNameForSQL(started.summary)),
+		attribute.String("db.query.summary", started.summary),
+	}
+	if err != nil {
+		attrs = append(attrs, semconv.ErrorTypeKey.String(statementErrorType(err)))
+	}
+	statementDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(attrs...))
+
+	if slowQueryThreshold > 0 && duration >= slowQueryThreshold {
+		event := log.Ctx(ctx).Warn()
+		// a context with a trace ID has a logger that already adds it
+		if tracing.GetTraceIDFromContext(ctx) == "" {
+			if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+				event = event.Str(tracing.TraceIDKey, uuid.UUID(spanContext.TraceID()).String())
+			}
+		}
+		event.
+			Str("query", started.sql).
+			Interface("args", redactArgs(started.args)).
+			Dur("duration", duration).
+			Dur("threshold", slowQueryThreshold).
+			AnErr("query_error", err).
+			Msg("Slow query")
+		return
+	}
+
+	log.Ctx(ctx).Debug().
+		Str("query", started.sql).
+		Interface("args", redactArgs(started.args)).
+		Dur("duration", duration).
+		Int64("rows_affected", rowsAffected).
+		AnErr("query_error", err).
+		Msg("Query finished")
+}
+
+// statementErrorType is a failed statement's SQLSTATE, or what kind of failure it was if the server didn't give one
+func statementErrorType(err error) string {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr):
+		return pgErr.Code
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return semconv.ErrorTypeOther.Value.AsString()
+	}
+}
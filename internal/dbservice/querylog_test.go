@@ -0,0 +1,104 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestParseRedactions(t *testing.T) {
+	rules, err := parseRedactions("uuid, ip")
+	require.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	rules, err = parseRedactions("none")
+	require.NoError(t, err)
+	assert.Empty(t, rules)
+
+	_, err = parseRedactions("uuid,email")
+	assert.Error(t, err)
+}
+
+func TestRedactArgs(t *testing.T) {
+	user := uuid.New()
+	args := []any{
+		42,
+		user,
+		user.String(),
+		[]uuid.UUID{user, user},
+		net.ParseIP("10.0.0.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		"192.168.1.20",
+		[]string{"keep", user.String()},
+		"SUCCESS",
+		true,
+	}
+
+	assert.Equal(t, []any{
+		42,
+		redactedUUID,
+		redactedUUID,
+		[]string{redactedUUID, redactedUUID},
+		redactedIP,
+		redactedIP,
+		redactedIP,
+		[]string{"keep", redactedUUID},
+		"SUCCESS",
+		true,
+	}, redactArgs(args))
+	assert.Equal(t, user, args[1], "the arguments themselves are left alone")
+
+	defer func(rules []redactionRule) { activeRedactions = rules }(activeRedactions)
+	activeRedactions = mustParseRedactions("ip")
+	assert.Equal(t, []any{user, redactedIP}, redactArgs([]any{user, "10.0.0.1"}))
+	activeRedactions = mustParseRedactions("none")
+	assert.Equal(t, []any{user, "10.0.0.1"}, redactArgs([]any{user, "10.0.0.1"}))
+}
+
+func TestStatementSummary(t *testing.T) {
+	assert.Equal(t, "SELECT MCB.CHECKBOX_T", statementSummary("select CHECKED_STATE from mcb.checkbox_t where CHECKBOX_NBR = $1"))
+	assert.Equal(t, "INSERT MCB.UPDATE_T", statementSummary("INSERT INTO MCB.UPDATE_T( UPDATE_UUID ) VALUES ( $1 )"))
+	assert.Equal(t, "UPDATE MCB.CHECKBOX_T", statementSummary("\n\tUPDATE MCB.CHECKBOX_T SET CHECKED_STATE = $1"))
+	assert.Equal(t, "SELECT", statementSummary("SELECT pg_try_advisory_lock($1)"))
+	assert.Equal(t, "query", statementSummary(""))
+}
+
+func TestRecordStatement(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	otel.SetMeterProvider(provider)
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	sql := "SELECT CHECKED_STATE FROM MCB.CHECKBOX_T WHERE CHECKBOX_NBR = $1"
+	ctx := withStatementStart(context.Background(), statementSummary(sql), sql, []any{7})
+	recordStatement(ctx, nil, 1)
+	recordStatement(ctx, &pgconn.PgError{Code: sqlStateSerializationFailure}, 0)
+	recordStatement(ctx, errors.New("lost connection"), 0)
+	recordStatement(context.Background(), nil, 0) // not started, not recorded
+
+	var metrics metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &metrics))
+	require.Len(t, metrics.ScopeMetrics, 1)
+	require.Len(t, metrics.ScopeMetrics[0].Metrics, 1)
+	histogram, ok := metrics.ScopeMetrics[0].Metrics[0].Data.(metricdata.Histogram[float64])
+	require.True(t, ok)
+
+	counts := map[string]uint64{}
+	for _, point := range histogram.DataPoints {
+		summary, _ := point.Attributes.Value("db.query.summary")
+		assert.Equal(t, "SELECT MCB.CHECKBOX_T", summary.AsString())
+		errorType, _ := point.Attributes.Value("error.type")
+		counts[errorType.AsString()] += point.Count
+	}
+	assert.Equal(t, map[string]uint64{"": 1, sqlStateSerializationFailure: 1, "_OTHER": 1}, counts)
+}
@@ -0,0 +1,224 @@
+package dbservice
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+)
+
+const (
+	defaultReplicaMaxLag           = 2 * time.Second
+	defaultReplicaLagCheckInterval = 5 * time.Second
+	defaultReplicaOperations       = string(operationRead)
+)
+
+// replicaLagQuery is how far the replica's replay is behind the primary, 0 if it has replayed all it has received,
+// or if it isn't a replica at all, and whether it is still streaming from the primary. A replica whose WAL receiver
+// has stopped has replayed all it received too, so reports no lag however stale it is. Without pg_read_all_stats the
+// receiver's status reads as NULL, so then a running receiver is taken to be streaming.
+const replicaLagQuery = "SELECT COALESCE(CASE WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 " +
+	"ELSE EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()) END, 0)::FLOAT8, " +
+	"NOT pg_is_in_recovery() OR EXISTS ( SELECT 1 FROM pg_stat_wal_receiver WHERE COALESCE(STATUS, 'streaming') = 'streaming' )"
+
+// replica is a read replica's pool, and what the last lag check found
+type replica struct {
+	url    string
+	pool   *pgxpool.Pool
+	usable atomic.Bool // the last lag check succeeded, streaming and within DB_REPLICA_MAX_LAG
+}
+
+var (
+	replicas          []*replica
+	replicaOperations []queryOperation
+	replicaNext       atomic.Uint64 // round robin over the usable replicas
+	replicaStop       context.CancelFunc
+	replicaWg         sync.WaitGroup
+)
+
+type forcePrimaryKey struct{}
+
+type queryOperationKey struct{}
+
+// ForcePrimary returns a context whose reads go to the primary, for reads that must see the latest writes, such as a
+// status check after a change
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryKey{}, true)
+}
+
+// withOperation tags the context with the kind of operation its queries are for, which decides whether they can go
+// to a replica
+func withOperation(ctx context.Context, operation queryOperation) context.Context {
+	return context.WithValue(ctx, queryOperationKey{}, operation)
+}
+
+// initializeReplicaPools opens a pool on each of DB_REPLICA_URLS when DB_REPLICA_ENABLED, with the primary's pool
+// settings, and starts checking their lag. A replica that can't be reached is left out, its reads go to the primary.
+func initializeReplicaPools(ctx context.Context, primaryConfig *pgxpool.Config) error {
+	if !apiconfig.GetBoolWithDefault("DB_REPLICA_ENABLED", false) {
+		return nil
+	}
+
+	operations, err := parseReplicaOperations(apiconfig.GetStringWithDefault("DB_REPLICA_OPERATIONS", defaultReplicaOperations))
+	if err != nil {
+		return err
+	}
+	replicaOperations = operations
+
+	appconfig := apiconfig.GetConfig()
+	for _, url := range strings.Split(apiconfig.GetString("DB_REPLICA_URLS"), ",") {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+
+		config, err := pgxpool.ParseConfig(withCredentials(url, appconfig.GetString("DATABASE_USER"), appconfig.GetString("DATABASE_PASSWORD")))
+		if err != nil {
+			return fmt.Errorf("failed to parse replica database config for %s: %w", url, err)
+		}
+		config.MaxConns = int32(apiconfig.GetIntWithDefault("DB_REPLICA_POOL_MAX_CONNS", int(primaryConfig.MaxConns)))
+		config.MinConns = min(primaryConfig.MinConns, config.MaxConns)
+		config.MaxConnLifetime = primaryConfig.MaxConnLifetime
+		config.MaxConnIdleTime = primaryConfig.MaxConnIdleTime
+		config.HealthCheckPeriod = primaryConfig.HealthCheckPeriod
+		if config.MaxConns < 1 {
+			return fmt.Errorf("DB_REPLICA_POOL_MAX_CONNS must be at least 1, got %d", config.MaxConns)
+		}
+		for k, v := range primaryConfig.ConnConfig.RuntimeParams {
+			config.ConnConfig.RuntimeParams[k] = v
+		}
+		config.ConnConfig.Tracer = queryTracer{}
+
+		replicaPool, err := pgxpool.NewWithConfig(ctx, config)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("failed to create replica connection pool for %s, its reads will go to the primary", url)
+			continue
+		}
+		replicas = append(replicas, &replica{url: url, pool: replicaPool})
+	}
+	if len(replicas) == 0 {
+		log.Ctx(ctx).Warn().Msg("DB_REPLICA_ENABLED is set, but there are no DB_REPLICA_URLS, all reads will go to the primary")
+		return nil
+	}
+
+	maxLag := apiconfig.GetDurationWithDefault("DB_REPLICA_MAX_LAG", defaultReplicaMaxLag)
+	interval := apiconfig.GetDurationWithDefault("DB_REPLICA_LAG_CHECK_INTERVAL", defaultReplicaLagCheckInterval)
+	if interval <= 0 {
+		return fmt.Errorf("DB_REPLICA_LAG_CHECK_INTERVAL must be positive, got %v", interval)
+	}
+
+	// the first check before any reads are routed, so a lagging replica isn't used until it catches up
+	checkReplicaLag(ctx, maxLag)
+
+	var monitorCtx context.Context
+	monitorCtx, replicaStop = context.WithCancel(context.WithoutCancel(ctx))
+	replicaWg.Add(1)
+	go func() {
+		defer replicaWg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-monitorCtx.Done():
+				return
+			case <-ticker.C:
+				checkReplicaLag(monitorCtx, maxLag)
+			}
+		}
+	}()
+
+	log.Ctx(ctx).Info().
+		Int("replicas", len(replicas)).
+		Interface("operations", replicaOperations).
+		Dur("max_lag", maxLag).
+		Msg("PostgreSQL replica connection pools initialized")
+	return nil
+}
+
+// parseReplicaOperations reads DB_REPLICA_OPERATIONS, the comma separated kinds of operation that read from replicas
+func parseReplicaOperations(value string) ([]queryOperation, error) {
+	var operations []queryOperation
+	for _, name := range strings.Split(value, ",") {
+		operation := queryOperation(strings.TrimSpace(name))
+		switch operation {
+		case "":
+			continue
+		case operationRead, operationFullLoad:
+			operations = append(operations, operation)
+		default:
+			return nil, fmt.Errorf("DB_REPLICA_OPERATIONS can only hold %s and %s, got %q", operationRead, operationFullLoad, name)
+		}
+	}
+	return operations, nil
+}
+
+// checkReplicaLag measures each replica's lag, marking those streaming from the primary within maxLag usable
+func checkReplicaLag(ctx context.Context, maxLag time.Duration) {
+	for _, r := range replicas {
+		checkCtx, cancel := context.WithTimeout(ctx, maxLag+time.Second)
+		var lagSeconds float64
+		var streaming bool
+		err := r.pool.QueryRow(checkCtx, replicaLagQuery).Scan(&lagSeconds, &streaming)
+		cancel()
+
+		lag := time.Duration(lagSeconds * float64(time.Second))
+		usable := err == nil && streaming && lag <= maxLag
+		if wasUsable := r.usable.Swap(usable); wasUsable != usable {
+			if usable {
+				log.Info().Msgf("Replica %s is usable again, lag %v", r.url, lag)
+			} else if err != nil {
+				log.Warn().Err(err).Msgf("Replica %s lag check failed, its reads go to the primary", r.url)
+			} else if !streaming {
+				log.Warn().Msgf("Replica %s is not streaming from the primary, its reads go to the primary", r.url)
+			} else {
+				log.Warn().Msgf("Replica %s is %v behind, more than DB_REPLICA_MAX_LAG %v, its reads go to the primary", r.url, lag, maxLag)
+			}
+		}
+	}
+}
+
+// readPool returns the pool a query should go to. Queries go to a usable replica, in turn, if the context is tagged
+// with an operation in DB_REPLICA_OPERATIONS and not forced to the primary, and to the primary otherwise.
+func readPool(ctx context.Context) *pgxpool.Pool {
+	if len(replicas) == 0 {
+		return pool
+	}
+	if forced, _ := ctx.Value(forcePrimaryKey{}).(bool); forced {
+		return pool
+	}
+	operation, _ := ctx.Value(queryOperationKey{}).(queryOperation)
+	if !slices.Contains(replicaOperations, operation) {
+		return pool
+	}
+
+	start := replicaNext.Add(1)
+	for i := range uint64(len(replicas)) {
+		r := replicas[(start+i)%uint64(len(replicas))]
+		if r.usable.Load() {
+			return r.pool
+		}
+	}
+	log.Ctx(ctx).Debug().Msg("No replica is usable, reading from the primary")
+	return pool
+}
+
+// closeReplicaPools stops the lag checks and closes the replica pools
+func closeReplicaPools() {
+	if replicaStop != nil {
+		replicaStop()
+		replicaWg.Wait()
+		replicaStop = nil
+	}
+	for _, r := range replicas {
+		r.pool.Close()
+	}
+	replicas = nil
+}
@@ -0,0 +1,52 @@
+package dbservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReplicaOperations(t *testing.T) {
+	operations, err := parseReplicaOperations("read, full_load")
+	require.NoError(t, err)
+	assert.Equal(t, []queryOperation{operationRead, operationFullLoad}, operations)
+
+	operations, err = parseReplicaOperations("")
+	require.NoError(t, err)
+	assert.Empty(t, operations)
+
+	_, err = parseReplicaOperations("read,write")
+	assert.Error(t, err)
+}
+
+func TestReadPool(t *testing.T) {
+	primary := &pgxpool.Pool{}
+	lagging := &replica{url: "lagging", pool: &pgxpool.Pool{}}
+	current := &replica{url: "current", pool: &pgxpool.Pool{}}
+	current.usable.Store(true)
+
+	savedPool, savedReplicas, savedOperations := pool, replicas, replicaOperations
+	t.Cleanup(func() {
+		pool, replicas, replicaOperations = savedPool, savedReplicas, savedOperations
+	})
+	pool = primary
+	replicaOperations = []queryOperation{operationRead}
+
+	replicas = nil
+	assert.Same(t, primary, readPool(withOperation(context.Background(), operationRead)), "no replicas")
+
+	replicas = []*replica{lagging, current}
+	for range 4 {
+		assert.Same(t, current.pool, readPool(withOperation(context.Background(), operationRead)), "only the usable replica")
+	}
+	assert.Same(t, primary, readPool(context.Background()), "untagged")
+	assert.Same(t, primary, readPool(withOperation(context.Background(), operationWrite)), "write")
+	assert.Same(t, primary, readPool(withOperation(context.Background(), operationFullLoad)), "not in DB_REPLICA_OPERATIONS")
+	assert.Same(t, primary, readPool(ForcePrimary(withOperation(context.Background(), operationRead))), "forced")
+
+	current.usable.Store(false)
+	assert.Same(t, primary, readPool(withOperation(context.Background(), operationRead)), "no usable replica")
+}
@@ -0,0 +1,72 @@
+package dbservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/google/uuid"
+)
+
+// CheckboxRepository is where the checkboxes are persisted. The API handlers and the workers use it rather than the
+// database directly, so they can run against the in-memory implementation in tests.
+type CheckboxRepository interface {
+	// GetCheckboxStatus returns whether a checkbox is checked, and when it was last changed
+	GetCheckboxStatus(ctx context.Context, checkboxNbr int) (bool, time.Time, apierror.APIError)
+	// GetCheckboxState returns the current state of a checkbox as seen by the conflict policies
+	GetCheckboxState(ctx context.Context, checkboxNbr int) (conflictpolicy.CheckboxState, apierror.APIError)
+	// UpdateCheckbox resolves a request with the conflict policy and applies it if the policy allows, recording the
+	// attempt in the checkbox's history. A request is applied at most once, a repeat returns ErrDuplicateRecord.
+	UpdateCheckbox(ctx context.Context, policy conflictpolicy.Policy, request conflictpolicy.CheckboxRequest) (conflictpolicy.Decision, apierror.APIError)
+	// UpdateCheckboxes applies a batch of requests all or nothing, with the same results as UpdateCheckbox for each
+	UpdateCheckboxes(ctx context.Context, policy conflictpolicy.Policy, requests []conflictpolicy.CheckboxRequest) ([]CheckboxUpdateResult, apierror.APIError)
+	// GetFullCheckboxStore returns the checked state of every checkbox, in checkbox number order
+	GetFullCheckboxStore(ctx context.Context) (*[]bool, apierror.APIError)
+	// LoadCheckboxBitset returns the checked state of every checkbox as a bitset, a lighter load than
+	// GetFullCheckboxStore. progress, if not nil, is called as each part of the board arrives.
+	LoadCheckboxBitset(ctx context.Context, progress LoadProgressFunc) (*bitset.Bitset, apierror.APIError)
+	// GetCheckboxHistory returns the most recent attempts to change a checkbox, newest first
+	GetCheckboxHistory(ctx context.Context, checkboxNbr int, limit int) ([]CheckboxUpdate, apierror.APIError)
+}
+
+// LoadProgressFunc is told how many of the total checkboxes have been loaded so far
+type LoadProgressFunc func(loaded int, total int)
+
+// CheckboxUpdate is one attempt to change a checkbox, as recorded in UPDATE_T. Success is false for requests the
+// conflict policy did not apply.
+type CheckboxUpdate struct {
+	UpdateDate  time.Time  `json:"update_date"`
+	CheckboxNbr int        `json:"checkbox_nbr"`
+	Checked     bool       `json:"checked"`
+	UpdatedBy   uuid.UUID  `json:"updated_by"`
+	RequestUuid uuid.UUID  `json:"request_uuid"`
+	Success     bool       `json:"success"`
+	RequestTime *time.Time `json:"request_time"`
+}
+
+var (
+	repositoryMu       sync.RWMutex
+	repositoryInstance CheckboxRepository = postgresCheckboxRepository{}
+)
+
+// GetCheckboxRepository returns the repository in use, which is Postgres unless replaced with SetCheckboxRepository
+func GetCheckboxRepository() CheckboxRepository {
+	repositoryMu.RLock()
+	defer repositoryMu.RUnlock()
+
+	return repositoryInstance
+}
+
+// SetCheckboxRepository replaces the repository in use, eg with a MemoryCheckboxRepository in tests
+func SetCheckboxRepository(repository CheckboxRepository) {
+	repositoryMu.Lock()
+	defer repositoryMu.Unlock()
+
+	repositoryInstance = repository
+}
+
+// postgresCheckboxRepository is the CheckboxRepository on the database pool
+type postgresCheckboxRepository struct{}
@@ -0,0 +1,191 @@
+package dbservice
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// CheckboxSnapshot is a snapshot of the whole board, as recorded in CHECKBOX_SNAPSHOT_T. It holds every change
+// committed before SnapshotDate, and may hold some committed after it.
+type CheckboxSnapshot struct {
+	Version      int64
+	SnapshotDate time.Time
+	CheckedCount int
+	Board        *bitset.Bitset
+}
+
+// WriteCheckboxSnapshot reads the board and appends it to CHECKBOX_SNAPSHOT_T as the newest snapshot. Its
+// SNAPSHOT_DATE is the start of the transaction, so it is never later than the board it goes with.
+// The returned snapshot doesn't include the board.
+func WriteCheckboxSnapshot(ctx context.Context) (CheckboxSnapshot, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
+	var snapshot CheckboxSnapshot
+	apierr := WithTx(ctx, TxOptions{}, func(tx pgx.Tx) error {
+		rows, err := QueryTx(ctx, tx, checkboxPartitionsQuery, checkboxPartitionSize)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to query checkbox partitions inside WriteCheckboxSnapshot()")
+			return wrapDatabaseError(err, "failed to query checkbox partitions")
+		}
+		board, apierr := scanCheckboxPartitions(ctx, rows, nil)
+		if apierr != nil {
+			return apierr
+		}
+
+		encoded, err := encodeSnapshotBoard(board)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to compress board inside WriteCheckboxSnapshot()")
+			return apierror.WrapWithCodeFromConstants(err, apierror.ErrInternalServer, "failed to compress board")
+		}
+
+		snapshot = CheckboxSnapshot{CheckedCount: board.Count()}
+		err = tx.QueryRow(ctx, "INSERT INTO MCB.CHECKBOX_SNAPSHOT_T ( BOARD_SIZE, CHECKED_COUNT, BOARD ) "+
+			"VALUES ( $1, $2, $3 ) RETURNING SNAPSHOT_VERSION, SNAPSHOT_DATE",
+			board.Len(), snapshot.CheckedCount, encoded).Scan(&snapshot.Version, &snapshot.SnapshotDate)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("failed to insert checkbox_snapshot_t inside WriteCheckboxSnapshot()")
+			return wrapDatabaseError(err, "failed to insert checkbox snapshot")
+		}
+		return nil
+	})
+	if apierr != nil {
+		return CheckboxSnapshot{}, apierr
+	}
+
+	log.Ctx(ctx).Info().Msgf("Wrote checkbox snapshot version %d, %d checked", snapshot.Version, snapshot.CheckedCount)
+	return snapshot, nil
+}
+
+// GetLatestCheckboxSnapshot returns the newest snapshot in CHECKBOX_SNAPSHOT_T, and false if there is none
+func GetLatestCheckboxSnapshot(ctx context.Context) (*CheckboxSnapshot, bool, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
+	rows, err := Query(ctx, "SELECT SNAPSHOT_VERSION, SNAPSHOT_DATE, BOARD_SIZE, CHECKED_COUNT, BOARD "+
+		"FROM MCB.CHECKBOX_SNAPSHOT_T "+
+		"ORDER BY SNAPSHOT_VERSION DESC LIMIT 1")
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to query checkbox_snapshot_t inside GetLatestCheckboxSnapshot()")
+		return nil, false, wrapDatabaseError(err, "failed to query checkbox snapshot")
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("rows iteration error inside GetLatestCheckboxSnapshot()")
+			return nil, false, wrapDatabaseError(err, "rows iteration error inside GetLatestCheckboxSnapshot")
+		}
+		return nil, false, nil
+	}
+
+	var snapshot CheckboxSnapshot
+	var boardSize int
+	var encoded []byte
+	err = rows.Scan(&snapshot.Version, &snapshot.SnapshotDate, &boardSize, &snapshot.CheckedCount, &encoded)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("failed to scan checkbox_snapshot_t inside GetLatestCheckboxSnapshot()")
+		return nil, false, wrapDatabaseError(err, "failed to scan checkbox snapshot")
+	}
+
+	snapshot.Board, err = decodeSnapshotBoard(boardSize, encoded)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to decode snapshot version %d inside GetLatestCheckboxSnapshot()", snapshot.Version)
+		return nil, false, apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, fmt.Sprintf("failed to decode snapshot version %d", snapshot.Version))
+	}
+	if snapshot.Board.Count() != snapshot.CheckedCount {
+		log.Ctx(ctx).Error().Msgf("snapshot version %d has %d checked, expected %d, inside GetLatestCheckboxSnapshot()", snapshot.Version, snapshot.Board.Count(), snapshot.CheckedCount)
+		return nil, false, apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, fmt.Sprintf("snapshot version %d is corrupt", snapshot.Version))
+	}
+
+	return &snapshot, true, nil
+}
+
+// ApplyCheckboxChangesSince brings a board up to date with the checkboxes UPDATE_T shows have changed since the given
+// time, by reading their current state from CHECKBOX_T. Reading the current state, rather than replaying the
+// updates, doesn't depend on the order of updates made in the same transaction. It returns the number of checkboxes
+// read. since must be within the UPDATE_T retention, or some changes will be missed.
+func ApplyCheckboxChangesSince(ctx context.Context, board *bitset.Bitset, since time.Time) (int, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
+	rows, err := Query(ctx, "SELECT CHECKBOX_NBR, CHECKED_STATE FROM MCB.CHECKBOX_T "+
+		"WHERE CHECKBOX_NBR IN ( SELECT CHECKBOX_NBR FROM MCB.UPDATE_T WHERE SUCCESS AND UPDATE_DATE >= $1 )", since)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query changed checkboxes inside ApplyCheckboxChangesSince(%v)", since)
+		return 0, wrapDatabaseError(err, "failed to query changed checkboxes")
+	}
+	defer rows.Close()
+
+	changed := 0
+	for rows.Next() {
+		var checkboxNbr int
+		var checked bool
+		err = rows.Scan(&checkboxNbr, &checked)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to scan changed checkbox inside ApplyCheckboxChangesSince(%v)", since)
+			return changed, wrapDatabaseError(err, "failed to scan changed checkbox")
+		}
+		if checkboxNbr < 0 || checkboxNbr >= board.Len() {
+			log.Ctx(ctx).Error().Msgf("checkbox %d is outside the board inside ApplyCheckboxChangesSince(%v)", checkboxNbr, since)
+			return changed, apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, fmt.Sprintf("checkbox %d is outside the board", checkboxNbr))
+		}
+
+		board.Set(checkboxNbr, checked)
+		changed++
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("rows iteration error inside ApplyCheckboxChangesSince(%v)", since)
+		return changed, wrapDatabaseError(err, "rows iteration error inside ApplyCheckboxChangesSince")
+	}
+
+	return changed, nil
+}
+
+// PurgeCheckboxSnapshots deletes CHECKBOX_SNAPSHOT_T rows older than the cutoff, apart from the newest
+func PurgeCheckboxSnapshots(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError) {
+	return purgeInBatches(ctx, "PurgeCheckboxSnapshots", batchSize,
+		"DELETE FROM MCB.CHECKBOX_SNAPSHOT_T WHERE SNAPSHOT_VERSION IN ( "+
+			"SELECT SNAPSHOT_VERSION FROM MCB.CHECKBOX_SNAPSHOT_T WHERE SNAPSHOT_DATE < $1 "+
+			"AND SNAPSHOT_VERSION < ( SELECT MAX(SNAPSHOT_VERSION) FROM MCB.CHECKBOX_SNAPSHOT_T ) "+
+			"LIMIT $2 )",
+		cutoff)
+}
+
+// encodeSnapshotBoard gzips the bitset's bytes
+func encodeSnapshotBoard(board *bitset.Bitset) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(board.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSnapshotBoard reverses encodeSnapshotBoard
+func decodeSnapshotBoard(size int, encoded []byte) (*bitset.Bitset, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(encoded))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+	return bitset.FromBytes(size, data)
+}
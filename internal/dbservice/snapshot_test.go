@@ -0,0 +1,36 @@
+package dbservice
+
+import (
+	"testing"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotBoardRoundTrip(t *testing.T) {
+	board := bitset.New(checkboxCount)
+	for i := 0; i < checkboxCount; i += 7 {
+		board.Set(i, true)
+	}
+
+	encoded, err := encodeSnapshotBoard(board)
+	require.NoError(t, err)
+	assert.Less(t, len(encoded), len(board.Bytes()))
+
+	decoded, err := decodeSnapshotBoard(checkboxCount, encoded)
+	require.NoError(t, err)
+	assert.Equal(t, board.Bytes(), decoded.Bytes())
+	assert.Equal(t, board.Count(), decoded.Count())
+}
+
+func TestDecodeSnapshotBoardRejectsBadData(t *testing.T) {
+	_, err := decodeSnapshotBoard(checkboxCount, []byte("not gzip"))
+	assert.Error(t, err)
+
+	// a board of the wrong size
+	encoded, err := encodeSnapshotBoard(bitset.New(1000))
+	require.NoError(t, err)
+	_, err = decodeSnapshotBoard(checkboxCount, encoded)
+	assert.Error(t, err)
+}
@@ -0,0 +1,452 @@
+package dbservice
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/database"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteCheckboxRepository is a CheckboxRepository on a SQLite database file, for running on a single node without
+// Postgres. It behaves like the Postgres repository, including writing the update events to its own OUTBOX_T, which
+// GetPendingOutboxEvents and MarkOutboxEventsSent use while it is the repository in use.
+// Transactions take the write lock when they begin, so the API server and backend can share the file, each waiting
+// up to the busy timeout for the other's writes.
+type SQLiteCheckboxRepository struct {
+	db   *sql.DB
+	size int
+}
+
+// NewSQLiteCheckboxRepository opens the SQLite database at path, creating it with size unchecked checkboxes if it
+// doesn't exist yet
+func NewSQLiteCheckboxRepository(ctx context.Context, path string, size int, busyTimeout time.Duration) (*SQLiteCheckboxRepository, error) {
+	query := url.Values{}
+	query.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "synchronous(NORMAL)")
+	query.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+
+	r := &SQLiteCheckboxRepository{db: db, size: size}
+	if err := r.initialize(ctx); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// initialize creates the tables if they don't exist, and the checkboxes if there are none
+func (r *SQLiteCheckboxRepository) initialize(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, database.SQLiteSchema); err != nil {
+		return fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM CHECKBOX_T").Scan(&count); err != nil {
+		return fmt.Errorf("failed to count checkboxes: %w", err)
+	}
+	if count == r.size {
+		return nil
+	}
+	if count != 0 {
+		return fmt.Errorf("sqlite database has %d checkboxes, expected %d", count, r.size)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().UnixMicro()
+	_, err = tx.ExecContext(ctx, "WITH RECURSIVE N(I) AS ( SELECT 0 UNION ALL SELECT I + 1 FROM N WHERE I + 1 < ? ) "+
+		"INSERT INTO CHECKBOX_T ( CHECKBOX_NBR, CHECKED_STATE ) SELECT I, FALSE FROM N", r.size)
+	if err != nil {
+		return fmt.Errorf("failed to create checkboxes: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO CHECKBOX_DETAILS_T "+
+		"( CHECKBOX_NBR, INIT_DATE, LAST_UPDATED_BY, LAST_REQUEST_ID, LAST_UPDATED_DATE, LAST_REQUEST_TIME ) "+
+		"SELECT CHECKBOX_NBR, ?, ?, ?, ?, 0 FROM CHECKBOX_T", now, uuid.Nil, uuid.Nil, now)
+	if err != nil {
+		return fmt.Errorf("failed to create checkbox details: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit checkboxes: %w", err)
+	}
+
+	log.Ctx(ctx).Info().Msgf("Created %d checkboxes in the sqlite database", r.size)
+	return nil
+}
+
+// Close closes the database
+func (r *SQLiteCheckboxRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLiteCheckboxRepository) GetCheckboxStatus(ctx context.Context, checkboxNbr int) (bool, time.Time, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationRead)
+	defer cancel()
+
+	var checked bool
+	var lastUpdatedDate int64
+	err := r.db.QueryRowContext(ctx, "SELECT c.CHECKED_STATE, d.LAST_UPDATED_DATE "+
+		"FROM CHECKBOX_T c "+
+		"JOIN CHECKBOX_DETAILS_T d ON c.CHECKBOX_NBR = d.CHECKBOX_NBR "+
+		"WHERE c.CHECKBOX_NBR = ?", checkboxNbr).Scan(&checked, &lastUpdatedDate)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Ctx(ctx).Debug().Msgf("no checkbox found with number %d inside SQLiteCheckboxRepository.GetCheckboxStatus(%d)", checkboxNbr, checkboxNbr)
+		return false, time.UnixMilli(0), apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox status inside SQLiteCheckboxRepository.GetCheckboxStatus(%d)", checkboxNbr)
+		return false, time.UnixMilli(0), wrapDatabaseError(err, "failed to query checkbox status")
+	}
+
+	return checked, time.UnixMicro(lastUpdatedDate), nil
+}
+
+func (r *SQLiteCheckboxRepository) GetCheckboxState(ctx context.Context, checkboxNbr int) (conflictpolicy.CheckboxState, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationRead)
+	defer cancel()
+
+	state, err := getSQLiteCheckboxState(ctx, r.db, checkboxNbr)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Ctx(ctx).Debug().Msgf("no checkbox found with number %d inside SQLiteCheckboxRepository.GetCheckboxState(%d)", checkboxNbr, checkboxNbr)
+		return conflictpolicy.CheckboxState{}, apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox state inside SQLiteCheckboxRepository.GetCheckboxState(%d)", checkboxNbr)
+		return conflictpolicy.CheckboxState{}, wrapDatabaseError(err, "failed to query checkbox state")
+	}
+	return state, nil
+}
+
+func (r *SQLiteCheckboxRepository) UpdateCheckbox(ctx context.Context, policy conflictpolicy.Policy, request conflictpolicy.CheckboxRequest) (conflictpolicy.Decision, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationWrite)
+	defer cancel()
+
+	var decision conflictpolicy.Decision
+	apierr := r.withTx(ctx, func(tx *sql.Tx) apierror.APIError {
+		var apierr apierror.APIError
+		decision, apierr = updateSQLiteCheckboxTx(ctx, tx, policy, request, time.Now())
+		return apierr
+	})
+	if apierr != nil {
+		return conflictpolicy.Decision{}, apierr
+	}
+	return decision, nil
+}
+
+func (r *SQLiteCheckboxRepository) UpdateCheckboxes(ctx context.Context, policy conflictpolicy.Policy, requests []conflictpolicy.CheckboxRequest) ([]CheckboxUpdateResult, apierror.APIError) {
+	results := make([]CheckboxUpdateResult, len(requests))
+	if len(requests) == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, operationWrite)
+	defer cancel()
+
+	// each request is resolved against the state left by the ones before it, as in the Postgres batch
+	now := time.Now()
+	apierr := r.withTx(ctx, func(tx *sql.Tx) apierror.APIError {
+		for i, request := range requests {
+			decision, apierr := updateSQLiteCheckboxTx(ctx, tx, policy, request, now)
+			if apierr != nil && !apierror.IsErrorType(apierr, apierror.ErrRecordNotFound) && !apierror.IsErrorType(apierr, apierror.ErrDuplicateRecord) {
+				return apierr
+			}
+			results[i] = CheckboxUpdateResult{Decision: decision, Err: apierr}
+		}
+		return nil
+	})
+	if apierr != nil {
+		return nil, apierr
+	}
+	return results, nil
+}
+
+func (r *SQLiteCheckboxRepository) GetFullCheckboxStore(ctx context.Context) (*[]bool, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
+	checkboxes := make([]bool, r.size)
+	i := 0
+	apierr := r.scanCheckboxes(ctx, "GetFullCheckboxStore", "SELECT CHECKED_STATE FROM CHECKBOX_T ORDER BY CHECKBOX_NBR", func(rows *sql.Rows) error {
+		if i >= r.size {
+			return fmt.Errorf("more than %d checkboxes", r.size)
+		}
+		err := rows.Scan(&checkboxes[i])
+		i++
+		return err
+	})
+	if apierr != nil {
+		return nil, apierr
+	}
+
+	if i != r.size {
+		log.Ctx(ctx).Error().Msgf("expected to get %d checkboxes, got %d", r.size, i)
+		return nil, apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, fmt.Sprintf("expected to get %d checkboxes, got %d", r.size, i))
+	}
+	return &checkboxes, nil
+}
+
+// LoadCheckboxBitset reads only the checked checkboxes, the rest of the bitset is already unset
+func (r *SQLiteCheckboxRepository) LoadCheckboxBitset(ctx context.Context, progress LoadProgressFunc) (*bitset.Bitset, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationFullLoad)
+	defer cancel()
+
+	board := bitset.New(r.size)
+	apierr := r.scanCheckboxes(ctx, "LoadCheckboxBitset", "SELECT CHECKBOX_NBR FROM CHECKBOX_T WHERE CHECKED_STATE ORDER BY CHECKBOX_NBR", func(rows *sql.Rows) error {
+		var checkboxNbr int
+		if err := rows.Scan(&checkboxNbr); err != nil {
+			return err
+		}
+		if checkboxNbr < 0 || checkboxNbr >= r.size {
+			return fmt.Errorf("checkbox %d is outside the board", checkboxNbr)
+		}
+		board.Set(checkboxNbr, true)
+		return nil
+	})
+	if apierr != nil {
+		return nil, apierr
+	}
+
+	if progress != nil {
+		progress(r.size, r.size)
+	}
+	return board, nil
+}
+
+func (r *SQLiteCheckboxRepository) GetCheckboxHistory(ctx context.Context, checkboxNbr int, limit int) ([]CheckboxUpdate, apierror.APIError) {
+	ctx, cancel := withQueryTimeout(ctx, operationRead)
+	defer cancel()
+
+	history := make([]CheckboxUpdate, 0, limit)
+	apierr := r.scanCheckboxes(ctx, fmt.Sprintf("GetCheckboxHistory(%d, %d)", checkboxNbr, limit),
+		"SELECT UPDATE_DATE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REQUEST_TIME "+
+			"FROM UPDATE_T "+
+			"WHERE CHECKBOX_NBR = ? "+
+			"ORDER BY UPDATE_DATE DESC, ROWID DESC LIMIT ?",
+		func(rows *sql.Rows) error {
+			var update CheckboxUpdate
+			var updateDate int64
+			var requestTime sql.NullInt64
+			err := rows.Scan(&updateDate, &update.CheckboxNbr, &update.Checked, &update.UpdatedBy, &update.RequestUuid, &update.Success, &requestTime)
+			if err != nil {
+				return err
+			}
+			update.UpdateDate = time.UnixMicro(updateDate)
+			if requestTime.Valid {
+				t := time.UnixMicro(requestTime.Int64)
+				update.RequestTime = &t
+			}
+			history = append(history, update)
+			return nil
+		}, checkboxNbr, limit)
+	if apierr != nil {
+		return nil, apierr
+	}
+	return history, nil
+}
+
+// getPendingOutboxEvents returns up to limit events from OUTBOX_T, oldest first
+func (r *SQLiteCheckboxRepository) getPendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, apierror.APIError) {
+	events := make([]OutboxEvent, 0, limit)
+	apierr := r.scanCheckboxes(ctx, fmt.Sprintf("getPendingOutboxEvents(%d)", limit),
+		"SELECT OUTBOX_ID, EVENT_TYPE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REASON, EVENT_DATE "+
+			"FROM OUTBOX_T "+
+			"ORDER BY OUTBOX_ID LIMIT ?",
+		func(rows *sql.Rows) error {
+			var event OutboxEvent
+			var eventDate int64
+			err := rows.Scan(&event.OutboxId, &event.EventType, &event.CheckboxNbr, &event.Checked, &event.UpdatedBy,
+				&event.RequestUuid, &event.Success, &event.Reason, &eventDate)
+			if err != nil {
+				return err
+			}
+			event.EventDate = time.UnixMicro(eventDate)
+			events = append(events, event)
+			return nil
+		}, limit)
+	if apierr != nil {
+		return nil, apierr
+	}
+	return events, nil
+}
+
+// deleteOutboxEvents deletes the given events from OUTBOX_T once they are published, there is no purge job to do it
+func (r *SQLiteCheckboxRepository) deleteOutboxEvents(ctx context.Context, outboxIds []int64) apierror.APIError {
+	return r.withTx(ctx, func(tx *sql.Tx) apierror.APIError {
+		for _, outboxId := range outboxIds {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM OUTBOX_T WHERE OUTBOX_ID = ?", outboxId); err != nil {
+				log.Ctx(ctx).Error().Err(err).Msgf("failed to delete outbox event %d inside SQLiteCheckboxRepository.deleteOutboxEvents", outboxId)
+				return wrapDatabaseError(err, "failed to delete outbox event")
+			}
+		}
+		return nil
+	})
+}
+
+// withTx runs fn in a transaction, committing it if fn returns nil and rolling it back otherwise
+func (r *SQLiteCheckboxRepository) withTx(ctx context.Context, fn func(tx *sql.Tx) apierror.APIError) apierror.APIError {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to begin sqlite transaction")
+		return wrapDatabaseError(err, "failed to begin transaction")
+	}
+	// a no-op once committed
+	defer func() { _ = tx.Rollback() }()
+
+	if apierr := fn(tx); apierr != nil {
+		return apierr
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to commit sqlite transaction")
+		return wrapDatabaseError(err, "failed to commit transaction")
+	}
+	return nil
+}
+
+// scanCheckboxes runs a query and calls scan for each row. caller names the repository method in the logs.
+func (r *SQLiteCheckboxRepository) scanCheckboxes(ctx context.Context, caller string, query string, scan func(rows *sql.Rows) error, args ...any) apierror.APIError {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkboxes inside SQLiteCheckboxRepository.%s", caller)
+		return wrapDatabaseError(err, "failed to query checkboxes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to scan checkbox inside SQLiteCheckboxRepository.%s", caller)
+			return wrapDatabaseError(err, "failed to scan checkbox")
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("rows iteration error inside SQLiteCheckboxRepository.%s", caller)
+		return wrapDatabaseError(err, "database iteration error")
+	}
+	return nil
+}
+
+// sqliteQueryer is a *sql.DB or *sql.Tx
+type sqliteQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// getSQLiteCheckboxState reads the state of a checkbox, returning sql.ErrNoRows if there is no such checkbox
+func getSQLiteCheckboxState(ctx context.Context, q sqliteQueryer, checkboxNbr int) (conflictpolicy.CheckboxState, error) {
+	var state conflictpolicy.CheckboxState
+	var lastRequestTime int64
+	err := q.QueryRowContext(ctx, "SELECT c.CHECKED_STATE, d.LAST_UPDATED_BY, d.LAST_REQUEST_TIME "+
+		"FROM CHECKBOX_T c "+
+		"JOIN CHECKBOX_DETAILS_T d ON c.CHECKBOX_NBR = d.CHECKBOX_NBR "+
+		"WHERE c.CHECKBOX_NBR = ?", checkboxNbr).Scan(&state.Checked, &state.LastUpdatedBy, &lastRequestTime)
+	state.LastRequestTime = time.UnixMicro(lastRequestTime)
+	return state, err
+}
+
+// updateSQLiteCheckboxTx resolves and applies a single request in the transaction, which already holds the write
+// lock, so nothing else can change the checkbox in between
+func updateSQLiteCheckboxTx(ctx context.Context, tx *sql.Tx, policy conflictpolicy.Policy, request conflictpolicy.CheckboxRequest, now time.Time) (conflictpolicy.Decision, apierror.APIError) {
+	checkboxNbr, checked, userUuid, requestUuid := request.CheckboxNbr, request.Checked, request.UserUuid, request.RequestUuid
+	// the user is logged as DB_LOG_REDACT shows it in the queries
+	loggedUser := redactArg(userUuid)
+
+	var processed bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS ( SELECT 1 FROM UPDATE_T WHERE REQUEST_ID = ? )", requestUuid).Scan(&processed)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query update_t inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+		return conflictpolicy.Decision{}, wrapDatabaseError(err, "failed to check for a processed request")
+	}
+
+	current, err := getSQLiteCheckboxState(ctx, tx, checkboxNbr)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Ctx(ctx).Debug().Msgf("no checkbox found with number %d inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checkboxNbr, checked, loggedUser, requestUuid)
+		return conflictpolicy.Decision{}, apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+	}
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to query checkbox state inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+		return conflictpolicy.Decision{}, wrapDatabaseError(err, "failed to query checkbox state")
+	}
+	if processed {
+		log.Ctx(ctx).Info().Msgf("request %v has already been processed inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", requestUuid, checkboxNbr, checked, loggedUser, requestUuid)
+		return conflictpolicy.Decision{}, apierror.NewAPIErrorFromCode(apierror.ErrDuplicateRecord, fmt.Sprintf("request %v has already been processed", requestUuid))
+	}
+
+	decision := policy.Resolve(current, request)
+
+	recordedChecked := checked
+	if decision.Apply {
+		recordedChecked = decision.Checked
+		_, err = tx.ExecContext(ctx, "UPDATE CHECKBOX_T SET CHECKED_STATE = ? WHERE CHECKBOX_NBR = ?", decision.Checked, checkboxNbr)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to update checkbox_t inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return conflictpolicy.Decision{}, wrapDatabaseError(err, "failed to update checkbox state")
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE CHECKBOX_DETAILS_T "+
+			"SET LAST_UPDATED_BY = ?, LAST_REQUEST_ID = ?, LAST_UPDATED_DATE = ?, LAST_REQUEST_TIME = ? "+
+			"WHERE CHECKBOX_NBR = ?", userUuid, requestUuid, now.UnixMicro(), request.RequestTime.UnixMicro(), checkboxNbr)
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to update checkbox_details_t inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return conflictpolicy.Decision{}, wrapDatabaseError(err, "failed to update checkbox details")
+		}
+	}
+
+	// Record the attempt in UPDATE_T, whether or not it was applied
+	_, err = tx.ExecContext(ctx, "INSERT INTO UPDATE_T "+
+		"( UPDATE_DATE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REQUEST_TIME ) "+
+		"VALUES ( ?, ?, ?, ?, ?, ?, ? )", now.UnixMicro(), checkboxNbr, recordedChecked, userUuid, requestUuid, decision.Apply, request.RequestTime.UnixMicro())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to insert update_t inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+		return conflictpolicy.Decision{}, wrapDatabaseError(err, "failed to record checkbox update")
+	}
+
+	// Queue the update events in the outbox, so they are published if and only if this transaction commits
+	for _, eventType := range outboxEventTypes(decision) {
+		_, err = tx.ExecContext(ctx, "INSERT INTO OUTBOX_T "+
+			"( EVENT_TYPE, CHECKBOX_NBR, CHECKED, UPDATED_BY, REQUEST_ID, SUCCESS, REASON, EVENT_DATE ) "+
+			"VALUES ( ?, ?, ?, ?, ?, ?, ?, ? )", eventType, checkboxNbr, decision.Checked, userUuid, requestUuid, decision.Apply, decision.Reason, now.UnixMicro())
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msgf("failed to insert outbox_t inside updateSQLiteCheckboxTx(%d, %t, %v, %v)", checkboxNbr, checked, loggedUser, requestUuid)
+			return conflictpolicy.Decision{}, wrapDatabaseError(err, "failed to queue checkbox update events")
+		}
+	}
+
+	return decision, nil
+}
+
+// classifySQLiteError returns the APIError code for a SQLite error, and false if err isn't one
+func classifySQLiteError(err error) (string, bool) {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return "", false
+	}
+
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return apierror.ErrDuplicateRecord, true
+	}
+	switch sqliteErr.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		// still locked after the busy timeout, like a Postgres lock timeout
+		return apierror.ErrDatabaseTimeout, true
+	}
+	return apierror.ErrDatabaseError, true
+}
@@ -0,0 +1,208 @@
+package dbservice
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sqliteTestSize = 1000
+
+func newTestSQLiteRepository(t *testing.T, path string) *SQLiteCheckboxRepository {
+	repository, err := NewSQLiteCheckboxRepository(context.Background(), path, sqliteTestSize, time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repository.Close() })
+	return repository
+}
+
+func testRequest(checkboxNbr int, checked bool, user uuid.UUID) conflictpolicy.CheckboxRequest {
+	return conflictpolicy.CheckboxRequest{
+		CheckboxNbr: checkboxNbr,
+		Checked:     checked,
+		UserUuid:    user,
+		RequestUuid: uuid.New(),
+		RequestTime: time.Now().Truncate(time.Microsecond),
+	}
+}
+
+func TestSQLiteRepositoryUpdateCheckbox(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestSQLiteRepository(t, filepath.Join(t.TempDir(), "mcb.db"))
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyFirstWriterWins)
+	require.NoError(t, err)
+	alice, bob := uuid.New(), uuid.New()
+
+	checked, _, apierr := repository.GetCheckboxStatus(ctx, 42)
+	require.NoError(t, apierr)
+	assert.False(t, checked)
+
+	check := testRequest(42, true, alice)
+	decision, apierr := repository.UpdateCheckbox(ctx, policy, check)
+	require.NoError(t, apierr)
+	assert.True(t, decision.Apply)
+
+	state, apierr := repository.GetCheckboxState(ctx, 42)
+	require.NoError(t, apierr)
+	assert.Equal(t, conflictpolicy.CheckboxState{Checked: true, LastUpdatedBy: alice, LastRequestTime: check.RequestTime}, state)
+
+	// someone else can't uncheck it under first writer wins, but the attempt is recorded
+	uncheck := testRequest(42, false, bob)
+	decision, apierr = repository.UpdateCheckbox(ctx, policy, uncheck)
+	require.NoError(t, apierr)
+	assert.False(t, decision.Apply)
+
+	_, apierr = repository.UpdateCheckbox(ctx, policy, check)
+	assert.True(t, apierror.IsErrorType(apierr, apierror.ErrDuplicateRecord))
+
+	_, apierr = repository.UpdateCheckbox(ctx, policy, testRequest(sqliteTestSize, true, alice))
+	assert.True(t, apierror.IsErrorType(apierr, apierror.ErrRecordNotFound))
+	_, _, apierr = repository.GetCheckboxStatus(ctx, sqliteTestSize)
+	assert.True(t, apierror.IsErrorType(apierr, apierror.ErrRecordNotFound))
+
+	history, apierr := repository.GetCheckboxHistory(ctx, 42, 10)
+	require.NoError(t, apierr)
+	require.Len(t, history, 2)
+	assert.Equal(t, uncheck.RequestUuid, history[0].RequestUuid)
+	assert.False(t, history[0].Success)
+	assert.Equal(t, check.RequestUuid, history[1].RequestUuid)
+	assert.True(t, history[1].Success)
+	assert.True(t, history[1].Checked)
+	assert.Equal(t, alice, history[1].UpdatedBy)
+	require.NotNil(t, history[1].RequestTime)
+	assert.True(t, check.RequestTime.Equal(*history[1].RequestTime))
+}
+
+func TestSQLiteRepositoryUpdateCheckboxes(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestSQLiteRepository(t, filepath.Join(t.TempDir(), "mcb.db"))
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyToggle)
+	require.NoError(t, err)
+	user := uuid.New()
+
+	first := testRequest(7, true, user)
+	requests := []conflictpolicy.CheckboxRequest{
+		first,
+		testRequest(7, true, user), // toggles it back
+		first,                      // a duplicate in the same batch
+		testRequest(sqliteTestSize, true, user),
+		testRequest(8, true, user),
+	}
+
+	results, apierr := repository.UpdateCheckboxes(ctx, policy, requests)
+	require.NoError(t, apierr)
+	require.Len(t, results, len(requests))
+	assert.True(t, results[0].Decision.Checked)
+	assert.False(t, results[1].Decision.Checked)
+	assert.True(t, apierror.IsErrorType(results[2].Err, apierror.ErrDuplicateRecord))
+	assert.True(t, apierror.IsErrorType(results[3].Err, apierror.ErrRecordNotFound))
+	assert.NoError(t, results[4].Err)
+
+	board, apierr := repository.LoadCheckboxBitset(ctx, nil)
+	require.NoError(t, apierr)
+	assert.Equal(t, 1, board.Count())
+	assert.False(t, board.Get(7))
+	assert.True(t, board.Get(8))
+
+	full, apierr := repository.GetFullCheckboxStore(ctx)
+	require.NoError(t, apierr)
+	assert.Equal(t, board.ToBools(), *full)
+}
+
+func TestSQLiteRepositoryReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "mcb.db")
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyFirstWriterWins)
+	require.NoError(t, err)
+
+	repository, err := NewSQLiteCheckboxRepository(ctx, path, sqliteTestSize, time.Second)
+	require.NoError(t, err)
+	_, apierr := repository.UpdateCheckbox(ctx, policy, testRequest(3, true, uuid.New()))
+	require.NoError(t, apierr)
+	require.NoError(t, repository.Close())
+
+	repository = newTestSQLiteRepository(t, path)
+	checked, _, apierr := repository.GetCheckboxStatus(ctx, 3)
+	require.NoError(t, apierr)
+	assert.True(t, checked)
+
+	_, err = NewSQLiteCheckboxRepository(ctx, path, sqliteTestSize+1, time.Second)
+	assert.Error(t, err)
+}
+
+func TestSQLiteRepositoryOutbox(t *testing.T) {
+	ctx := context.Background()
+	repository := newTestSQLiteRepository(t, filepath.Join(t.TempDir(), "mcb.db"))
+	previous := GetCheckboxRepository()
+	SetCheckboxRepository(repository)
+	t.Cleanup(func() { SetCheckboxRepository(previous) })
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyFirstWriterWins)
+	require.NoError(t, err)
+	alice, bob := uuid.New(), uuid.New()
+
+	check := testRequest(42, true, alice)
+	_, apierr := repository.UpdateCheckbox(ctx, policy, check)
+	require.NoError(t, apierr)
+	uncheck := testRequest(42, false, bob)
+	_, apierr = repository.UpdateCheckbox(ctx, policy, uncheck)
+	require.NoError(t, apierr)
+	// a duplicate or a missing checkbox rolls back with no events
+	_, apierr = repository.UpdateCheckbox(ctx, policy, check)
+	require.Error(t, apierr)
+	_, apierr = repository.UpdateCheckbox(ctx, policy, testRequest(sqliteTestSize, true, alice))
+	require.Error(t, apierr)
+
+	// the applied request changes the checkbox and completes, the rejected one only completes
+	events, apierr := GetPendingOutboxEvents(ctx, 10)
+	require.NoError(t, apierr)
+	require.Len(t, events, 3)
+	assert.Equal(t, queueservice.CheckboxUpdateEventChanged, events[0].EventType)
+	assert.Equal(t, queueservice.CheckboxUpdateEventRequestComplete, events[1].EventType)
+	for _, event := range events[:2] {
+		assert.Equal(t, 42, event.CheckboxNbr)
+		assert.True(t, event.Checked)
+		assert.True(t, event.Success)
+		assert.Equal(t, alice, event.UpdatedBy)
+		assert.Equal(t, check.RequestUuid, event.RequestUuid)
+	}
+	assert.Equal(t, queueservice.CheckboxUpdateEventRequestComplete, events[2].EventType)
+	assert.False(t, events[2].Success)
+	assert.Equal(t, uncheck.RequestUuid, events[2].RequestUuid)
+	assert.NotEmpty(t, events[2].Reason)
+
+	// the sent events are gone, oldest first
+	require.NoError(t, MarkOutboxEventsSent(ctx, []int64{events[0].OutboxId, events[1].OutboxId}))
+	pending, apierr := GetPendingOutboxEvents(ctx, 10)
+	require.NoError(t, apierr)
+	assert.Equal(t, events[2:], pending)
+}
+
+func TestSQLiteRepositoryRedactsUserInLogs(t *testing.T) {
+	defer func(rules []redactionRule) { activeRedactions = rules }(activeRedactions)
+	activeRedactions = mustParseRedactions("uuid")
+	var logged bytes.Buffer
+	ctx := zerolog.New(&logged).WithContext(context.Background())
+	repository := newTestSQLiteRepository(t, filepath.Join(t.TempDir(), "mcb.db"))
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyFirstWriterWins)
+	require.NoError(t, err)
+	user := uuid.New()
+
+	// a redelivery is logged with the request, but not the user who made it
+	request := testRequest(42, true, user)
+	_, apierr := repository.UpdateCheckbox(ctx, policy, request)
+	require.NoError(t, apierr)
+	_, apierr = repository.UpdateCheckbox(ctx, policy, request)
+	require.True(t, apierror.IsErrorType(apierr, apierror.ErrDuplicateRecord))
+	assert.Contains(t, logged.String(), "has already been processed")
+	assert.NotContains(t, logged.String(), user.String())
+	assert.Contains(t, logged.String(), redactedUUID)
+}
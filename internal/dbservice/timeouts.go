@@ -0,0 +1,45 @@
+package dbservice
+
+import (
+	"context"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+)
+
+// queryOperation is the kind of work a repository call does, each has its own deadline
+type queryOperation string
+
+const (
+	operationRead     queryOperation = "read"
+	operationWrite    queryOperation = "write"
+	operationFullLoad queryOperation = "full_load"
+)
+
+const (
+	defaultReadTimeout     = 5 * time.Second
+	defaultWriteTimeout    = 10 * time.Second
+	defaultFullLoadTimeout = 2 * time.Minute
+)
+
+// withQueryTimeout bounds a repository call by the DB_READ_TIMEOUT, DB_WRITE_TIMEOUT or DB_FULL_LOAD_TIMEOUT of its
+// kind of operation. A timeout of 0 leaves the call bounded only by the caller's context. The context is also tagged
+// with the operation, so its reads can be routed to a replica.
+func withQueryTimeout(ctx context.Context, operation queryOperation) (context.Context, context.CancelFunc) {
+	ctx = withOperation(ctx, operation)
+
+	var timeout time.Duration
+	switch operation {
+	case operationRead:
+		timeout = apiconfig.GetDurationWithDefault("DB_READ_TIMEOUT", defaultReadTimeout)
+	case operationWrite:
+		timeout = apiconfig.GetDurationWithDefault("DB_WRITE_TIMEOUT", defaultWriteTimeout)
+	case operationFullLoad:
+		timeout = apiconfig.GetDurationWithDefault("DB_FULL_LOAD_TIMEOUT", defaultFullLoadTimeout)
+	}
+
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,109 @@
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTxMaxRetries   = 3
+	defaultTxRetryBackoff = 20 * time.Millisecond
+	txRollbackTimeout     = 5 * time.Second
+)
+
+// TxOptions are the options for a transaction run by WithTx. The zero value is a read write transaction at the
+// server's default isolation level, which is read committed.
+type TxOptions struct {
+	IsoLevel pgx.TxIsoLevel
+	ReadOnly bool
+}
+
+func (o TxOptions) pgxOptions() pgx.TxOptions {
+	accessMode := pgx.ReadWrite
+	if o.ReadOnly {
+		accessMode = pgx.ReadOnly
+	}
+	return pgx.TxOptions{IsoLevel: o.IsoLevel, AccessMode: accessMode}
+}
+
+// WithTx runs fn in a transaction, committing it if fn returns nil, and rolling it back if fn returns an error or
+// panics. If the transaction fails with a serialization failure or deadlock, the whole of fn is run again in a new
+// transaction, up to DB_TX_MAX_RETRIES times, waiting DB_TX_RETRY_BACKOFF before the first retry and twice as long
+// before each one after. fn must not have effects outside the transaction that can't be repeated.
+// An APIError returned by fn is returned as is, any other error is wrapped with the code ClassifyError gives it.
+func WithTx(ctx context.Context, opts TxOptions, fn func(tx pgx.Tx) error) apierror.APIError {
+	maxRetries := apiconfig.GetIntWithDefault("DB_TX_MAX_RETRIES", defaultTxMaxRetries)
+	backoff := max(apiconfig.GetDurationWithDefault("DB_TX_RETRY_BACKOFF", defaultTxRetryBackoff), 0)
+
+	for attempt := 0; ; attempt++ {
+		apierr := runTx(ctx, opts, fn)
+		if apierr == nil || !apierror.IsErrorType(apierr, apierror.ErrDatabaseConflict) || attempt >= maxRetries {
+			return apierr
+		}
+
+		// jittered, so the transactions that conflicted don't all retry at the same moment
+		wait := backoff<<attempt + rand.N(backoff+1)
+		log.Ctx(ctx).Warn().Err(apierr).Msgf("transaction conflicted, retrying in %v, retry %d of %d", wait, attempt+1, maxRetries)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return apierr
+		case <-timer.C:
+		}
+	}
+}
+
+// runTx makes a single attempt at the transaction
+func runTx(ctx context.Context, opts TxOptions, fn func(tx pgx.Tx) error) (apierr apierror.APIError) {
+	if pool == nil {
+		return apierror.NewAPIErrorFromCode(apierror.ErrDatabaseError, "database pool not initialized")
+	}
+
+	tx, err := pool.BeginTx(ctx, opts.pgxOptions())
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Failed to begin transaction")
+		return wrapDatabaseError(err, "failed to begin transaction")
+	}
+
+	committed := false
+	defer func() {
+		r := recover()
+		if !committed {
+			// the rollback gets its own deadline, since ctx may be why the transaction failed. A failed commit has
+			// already ended the transaction.
+			rollbackCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), txRollbackTimeout)
+			defer cancel()
+			if rollbackerr := tx.Rollback(rollbackCtx); rollbackerr != nil && !errors.Is(rollbackerr, pgx.ErrTxClosed) {
+				log.Ctx(ctx).Error().Err(rollbackerr).Msg("Failed to rollback transaction, its connection is closed instead")
+			} else {
+				log.Ctx(ctx).Debug().Msg("Transaction rolled back")
+			}
+		}
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		var fnerr apierror.APIError
+		if errors.As(err, &fnerr) {
+			return fnerr
+		}
+		return wrapDatabaseError(err, "transaction failed")
+	}
+
+	if err := CommitTx(ctx, tx); err != nil {
+		return wrapDatabaseError(err, "failed to commit transaction")
+	}
+	committed = true
+	return nil
+}
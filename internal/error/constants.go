@@ -27,6 +27,7 @@ const (
 	ErrDatabaseError     = "DATABASE_ERROR"
 	ErrDatabaseTimeout   = "DATABASE_TIMEOUT"
 	ErrDatabaseConnection = "DATABASE_CONNECTION_ERROR"
+	ErrDatabaseConflict  = "DATABASE_CONFLICT" // serialization failure or deadlock, retrying is expected to succeed
 	ErrRecordNotFound    = "RECORD_NOT_FOUND"
 	ErrDuplicateRecord   = "DUPLICATE_RECORD"
 	
@@ -54,12 +55,13 @@ var ErrorCodeToStatus = map[string]int{
 	// Server errors - 500 Internal Server Error
 	ErrInternalServer:       http.StatusInternalServerError,
 	ErrDatabaseError:        http.StatusInternalServerError,
-	ErrDatabaseTimeout:      http.StatusInternalServerError,
-	ErrDatabaseConnection:   http.StatusInternalServerError,
 	ErrMemoryStoreError:     http.StatusInternalServerError,
 	
 	// Service unavailable - 503
 	ErrServiceUnavailable:   http.StatusServiceUnavailable,
+	ErrDatabaseTimeout:      http.StatusServiceUnavailable,
+	ErrDatabaseConnection:   http.StatusServiceUnavailable,
+	ErrDatabaseConflict:     http.StatusServiceUnavailable,
 	ErrQueueUnavailable:     http.StatusServiceUnavailable,
 	ErrQueueTimeout:         http.StatusServiceUnavailable,
 	ErrQueueFull:           http.StatusServiceUnavailable,
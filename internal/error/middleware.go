@@ -11,7 +11,7 @@ import (
 
 // ErrorHandlingMiddleware handles panics and APIErrors in Gin handlers
 func ErrorHandlingMiddleware() gin.HandlerFunc {
-	return gin.CustomRecoveryWithWriter(gin.DefaultWriter, func(c *gin.Context, recovered any) {
+	recovery := gin.CustomRecoveryWithWriter(gin.DefaultWriter, func(c *gin.Context, recovered any) {
 		// Handle panics
 		if recovered != nil {
 			handlePanic(c, recovered)
@@ -26,6 +26,16 @@ func ErrorHandlingMiddleware() gin.HandlerFunc {
 
 		c.AbortWithStatus(http.StatusInternalServerError)
 	})
+
+	return func(c *gin.Context) {
+		recovery(c)
+
+		// The recovery handler only runs on a panic, so APIErrors set with AbortWithAPIError are written here, once
+		// the handlers have finished
+		if len(c.Errors) > 0 && !c.Writer.Written() {
+			handleAPIErrors(c)
+		}
+	}
 }
 
 // handlePanic processes panic recovery
@@ -112,6 +122,11 @@ func sendErrorResponse(c *gin.Context, apiErr APIError) {
 		errorResponse["stack_trace"] = apiErr.StackTrace()
 	}
 
+	// tell clients a transient failure, like a database timeout, is worth retrying
+	if IsRetryable(apiErr) {
+		c.Header("Retry-After", "1")
+	}
+
 	c.AbortWithStatusJSON(apiErr.StatusCode(), errorResponse)
 }
 
@@ -2,6 +2,7 @@ package error
 
 import (
 	"context"
+	"errors"
 )
 
 // NewAPIError creates a new APIError with the given code, message, and status
@@ -120,6 +121,25 @@ func IsErrorType(err error, errorCode string) bool {
 	return false
 }
 
+// retryableCodes are the transient failures, where the same request is expected to succeed if it is tried again later
+var retryableCodes = map[string]bool{
+	ErrServiceUnavailable: true,
+	ErrQueueUnavailable:   true,
+	ErrQueueTimeout:       true,
+	ErrDatabaseTimeout:    true,
+	ErrDatabaseConnection: true,
+	ErrDatabaseConflict:   true,
+}
+
+// IsRetryable checks if an error is a transient failure, worth retrying the same request for
+func IsRetryable(err error) bool {
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return retryableCodes[apiErr.ErrorCode()]
+	}
+	return false
+}
+
 // GetErrorCode extracts the error code from an error, returns empty string if not an APIError
 func GetErrorCode(err error) string {
 	if apiErr, ok := err.(APIError); ok {
@@ -106,6 +106,8 @@ func InitLogger(config LogConfig) error {
 		Logger()
 
 	log.Logger = logger
+	// log.Ctx(ctx) falls back to the global logger when the context has none, rather than discarding the event
+	zerolog.DefaultContextLogger = &log.Logger
 
 	return nil
 }
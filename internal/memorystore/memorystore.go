@@ -3,15 +3,28 @@ package memorystore
 import (
 	"context"
 	"fmt"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
 	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
 	"github.com/rs/zerolog/log"
-	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var mu sync.RWMutex // guards memoryStore
-var store []bool
-var storeLen = 0
+// Values for the MEMORY_STORE_LOAD_MODE config key
+const (
+	LoadModeBitset = "bitset" // the board packed into bit strings by Postgres (default)
+	LoadModeRows   = "rows"   // a row per checkbox
+)
+
+const (
+	defaultSnapshotMaxAge  = 12 * time.Hour
+	defaultSnapshotOverlap = time.Minute
+)
+
+// store is the board, read and written without a lock, see bitset.Atomic. Loading the board swaps in a new one.
+var store atomic.Pointer[bitset.Atomic]
 var initialized = false
 
 func Init() {
@@ -20,52 +33,158 @@ func Init() {
 	}
 
 	// allocate the memory
-	store = make([]bool, 1000000)
-	storeLen = len(store)
+	store.Store(bitset.NewAtomic(1000000))
 
 	initialized = true
 }
 
 func GetCheckboxStatus(checkboxNbr int) (bool, error) {
-	if !checkboxNbrValid(checkboxNbr) {
+	board := store.Load()
+	if !checkboxNbrValid(board, checkboxNbr) {
 		log.Error().Msgf("invalid checkbox number for call GetCheckboxStatus(%d)", checkboxNbr)
 		return false, apierror.InternalError(fmt.Sprintf("invalid checkbox number for call GetCheckboxStatus(%d", checkboxNbr))
 	}
 
-	mu.RLock()
-	checked := store[checkboxNbr]
-	mu.RUnlock()
-
-	return checked, nil
+	return board.Get(checkboxNbr), nil
 }
 
 func DoCheck(checkboxNbr int, checked bool) error {
-	if !checkboxNbrValid(checkboxNbr) {
+	board := store.Load()
+	if !checkboxNbrValid(board, checkboxNbr) {
 		log.Error().Msgf("invalid checkbox number for call DoCheck(%d, %t)", checkboxNbr, checked)
 		return apierror.InternalError(fmt.Sprintf("invalid checkbox number for call DoCheck(%d, %t)", checkboxNbr, checked))
 	}
-	mu.Lock()
-	store[checkboxNbr] = checked
-	mu.Unlock()
+	board.Set(checkboxNbr, checked)
 
 	return nil
 }
 
+// GetCheckboxRange returns the status of the checkboxes from start up to end
+func GetCheckboxRange(start int, end int) ([]bool, error) {
+	checked, err := store.Load().Range(start, end)
+	if err != nil {
+		log.Error().Err(err).Msgf("invalid checkbox range for call GetCheckboxRange(%d, %d)", start, end)
+		return nil, apierror.InternalError(fmt.Sprintf("invalid checkbox range for call GetCheckboxRange(%d, %d)", start, end))
+	}
+	return checked, nil
+}
+
+// CheckedCountRange returns the number of checked checkboxes from start up to end
+func CheckedCountRange(start int, end int) (int, error) {
+	count, err := store.Load().CountRange(start, end)
+	if err != nil {
+		log.Error().Err(err).Msgf("invalid checkbox range for call CheckedCountRange(%d, %d)", start, end)
+		return 0, apierror.InternalError(fmt.Sprintf("invalid checkbox range for call CheckedCountRange(%d, %d)", start, end))
+	}
+	return count, nil
+}
+
+// CheckedCount returns the number of checked checkboxes, and the total number of checkboxes
+func CheckedCount() (int, int) {
+	board := store.Load()
+	return board.Count(), board.Len()
+}
+
+// LoadCheckboxesFromStore replaces the memory store with the board from the database. It starts from the latest
+// snapshot if there is a recent enough one, and otherwise loads the whole board the MEMORY_STORE_LOAD_MODE way.
 func LoadCheckboxesFromStore(ctx context.Context) apierror.APIError {
-	newMemoryStore, err := dbservice.GetFullCheckboxStore(ctx)
+	newMemoryStore, err := loadBoard(ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to get full checkbox store from database")
-		return apierror.WrapWithCodeFromConstants(err, apierror.ErrDatabaseError, "failed to get full checkbox store from database")
+		return err
 	}
 
-	mu.Lock()
-	store = *newMemoryStore
-	storeLen = len(store)
-	mu.Unlock()
+	store.Store(bitset.NewAtomicFrom(newMemoryStore))
 
 	return nil
 }
 
-func checkboxNbrValid(checkboxNbr int) bool {
-	return checkboxNbr >= 0 && checkboxNbr < storeLen
+// Reconcile loads the board from the database like LoadCheckboxesFromStore, but rather than swapping it in, corrects
+// only the checkboxes of the memory store that differ from it, and returns their numbers
+func Reconcile(ctx context.Context) ([]int, apierror.APIError) {
+	loaded, err := loadBoard(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	board := store.Load()
+	if board.Len() != loaded.Len() {
+		return nil, apierror.InternalError(fmt.Sprintf("the memory store has %d checkboxes, the database %d", board.Len(), loaded.Len()))
+	}
+	var corrected []int
+	for i := range loaded.Len() {
+		if checked := loaded.Get(i); board.Get(i) != checked {
+			board.Set(i, checked)
+			corrected = append(corrected, i)
+		}
+	}
+	return corrected, nil
+}
+
+// loadBoard loads the board from the latest snapshot if there is a recent enough one, and otherwise in whole
+func loadBoard(ctx context.Context) (*bitset.Bitset, apierror.APIError) {
+	// snapshots are only written on Postgres
+	if apiconfig.GetBoolWithDefault("MEMORY_STORE_USE_SNAPSHOTS", true) && dbservice.DatabaseProvider() == dbservice.ProviderPostgres {
+		if board := loadFromSnapshot(ctx); board != nil {
+			return board, nil
+		}
+	}
+	return loadFullBoard(ctx)
+}
+
+// loadFromSnapshot loads the latest snapshot, and brings it up to date with the checkboxes changed since. It returns
+// nil if there is no usable snapshot, for the caller to load the whole board instead.
+func loadFromSnapshot(ctx context.Context) *bitset.Bitset {
+	// must be less than the UPDATE_T retention, or the changes since the snapshot may have been purged
+	maxAge := apiconfig.GetDurationWithDefault("MEMORY_STORE_SNAPSHOT_MAX_AGE", defaultSnapshotMaxAge)
+	// updates that were in flight when the snapshot was taken are recorded with an earlier UPDATE_DATE, so the
+	// changes are read from a little before it
+	overlap := apiconfig.GetDurationWithDefault("MEMORY_STORE_SNAPSHOT_OVERLAP", defaultSnapshotOverlap)
+
+	snapshot, found, err := dbservice.GetLatestCheckboxSnapshot(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to get checkbox snapshot, loading the whole board instead")
+		return nil
+	}
+	if !found {
+		log.Info().Msg("No checkbox snapshot, loading the whole board instead")
+		return nil
+	}
+	if age := time.Since(snapshot.SnapshotDate); age > maxAge {
+		log.Info().Msgf("Checkbox snapshot version %d is %v old, loading the whole board instead", snapshot.Version, age)
+		return nil
+	}
+
+	changed, err := dbservice.ApplyCheckboxChangesSince(ctx, snapshot.Board, snapshot.SnapshotDate.Add(-overlap))
+	if err != nil {
+		log.Warn().Err(err).Msgf("failed to apply changes since checkbox snapshot version %d, loading the whole board instead", snapshot.Version)
+		return nil
+	}
+
+	log.Info().Msgf("Loaded checkbox snapshot version %d from %v, and %d checkboxes changed since", snapshot.Version, snapshot.SnapshotDate, changed)
+	return snapshot.Board
+}
+
+// loadFullBoard loads the whole board the MEMORY_STORE_LOAD_MODE way
+func loadFullBoard(ctx context.Context) (*bitset.Bitset, apierror.APIError) {
+	if apiconfig.GetStringWithDefault("MEMORY_STORE_LOAD_MODE", LoadModeBitset) == LoadModeRows {
+		checkboxes, err := dbservice.GetCheckboxRepository().GetFullCheckboxStore(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to get full checkbox store from database")
+			return nil, apierror.WrapWithCodeFromConstants(err, err.ErrorCode(), "failed to get full checkbox store from database")
+		}
+		return bitset.FromBools(*checkboxes), nil
+	}
+
+	board, err := dbservice.GetCheckboxRepository().LoadCheckboxBitset(ctx, func(loaded int, total int) {
+		log.Ctx(ctx).Info().Msgf("Loading memory store, %d of %d checkboxes loaded", loaded, total)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load checkbox bitset from database")
+		return nil, apierror.WrapWithCodeFromConstants(err, err.ErrorCode(), "failed to load checkbox bitset from database")
+	}
+	return board, nil
+}
+
+func checkboxNbrValid(board *bitset.Atomic, checkboxNbr int) bool {
+	return checkboxNbr >= 0 && checkboxNbr < board.Len()
 }
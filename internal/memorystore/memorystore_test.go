@@ -0,0 +1,79 @@
+package memorystore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/bitset"
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckboxRanges(t *testing.T) {
+	store.Store(bitset.NewAtomic(1000))
+
+	for _, checkboxNbr := range []int{0, 63, 64, 500, 999} {
+		require.NoError(t, DoCheck(checkboxNbr, true))
+	}
+	require.NoError(t, DoCheck(500, false))
+
+	checked, err := GetCheckboxStatus(63)
+	require.NoError(t, err)
+	assert.True(t, checked)
+	_, err = GetCheckboxStatus(1000)
+	assert.Error(t, err)
+
+	statuses, err := GetCheckboxRange(62, 66)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, true, true, false}, statuses)
+	_, err = GetCheckboxRange(10, 1001)
+	assert.Error(t, err)
+
+	count, err := CheckedCountRange(1, 1000)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	_, err = CheckedCountRange(-1, 10)
+	assert.Error(t, err)
+
+	count, total := CheckedCount()
+	assert.Equal(t, 4, count)
+	assert.Equal(t, 1000, total)
+}
+
+func TestReconcile(t *testing.T) {
+	apiconfig.GetConfig().Set("MEMORY_STORE_USE_SNAPSHOTS", false)
+	repository := dbservice.NewMemoryCheckboxRepository(1000)
+	dbservice.SetCheckboxRepository(repository)
+	ctx := context.Background()
+	require.Nil(t, LoadCheckboxesFromStore(ctx))
+
+	// the memory store misses a change to the database, and has one the database doesn't
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyFirstWriterWins)
+	require.NoError(t, err)
+	_, apierr := repository.UpdateCheckbox(ctx, policy, conflictpolicy.CheckboxRequest{
+		CheckboxNbr: 7,
+		Checked:     true,
+		UserUuid:    uuid.New(),
+		RequestUuid: uuid.New(),
+	})
+	require.Nil(t, apierr)
+	require.NoError(t, DoCheck(900, true))
+
+	corrected, apierr := Reconcile(ctx)
+	require.Nil(t, apierr)
+	assert.Equal(t, []int{7, 900}, corrected)
+	checked, err := GetCheckboxStatus(7)
+	require.NoError(t, err)
+	assert.True(t, checked)
+	checked, err = GetCheckboxStatus(900)
+	require.NoError(t, err)
+	assert.False(t, checked)
+
+	corrected, apierr = Reconcile(ctx)
+	require.Nil(t, apierr)
+	assert.Empty(t, corrected)
+}
@@ -5,14 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
+	"strconv"
 	"sync"
 	"time"
 
 	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
 	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/rs/zerolog/log"
@@ -43,6 +46,18 @@ func (m *SqsMessage) UnmarshalBody(v any) apierror.APIError {
 
 func (a *awsQueueProvider) PullCheckboxActionMessages(ctx context.Context) ([]Message, apierror.APIError) {
 	appconfig := apiconfig.GetConfig()
+	queueUrl := appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1")
+	return a.pullMessages(ctx, queueUrl)
+}
+
+func (a *awsQueueProvider) PullCheckboxUpdateMessages(ctx context.Context) ([]Message, apierror.APIError) {
+	appconfig := apiconfig.GetConfig()
+	queueUrl := appconfig.GetString("AWS_SQS_CHECKBOXUPDATE_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXUPDATE_CONSUMER")
+	return a.pullMessages(ctx, queueUrl)
+}
+
+func (a *awsQueueProvider) pullMessages(ctx context.Context, queueUrl string) ([]Message, apierror.APIError) {
+	appconfig := apiconfig.GetConfig()
 
 	sqsClient, err := a.getSqsClient(ctx, appconfig.GetString("AWS_AUTH_PROFILE_NAME"))
 	if err != nil {
@@ -51,7 +66,6 @@ func (a *awsQueueProvider) PullCheckboxActionMessages(ctx context.Context) ([]Me
 	}
 	log.Debug().Msg("SQS client obtained")
 
-	queueUrl := appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1")
 	log.Debug().Msgf("Pulling messages from SQS queue %s", queueUrl)
 	result, sqserr := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
 		QueueUrl:            aws.String(queueUrl),
@@ -78,6 +92,7 @@ func (a *awsQueueProvider) PullCheckboxActionMessages(ctx context.Context) ([]Me
 			ReceiptHandle: aws.ToString(resultMessage.ReceiptHandle),
 			Body:          aws.ToString(resultMessage.Body),
 			Attributes:    make(map[string]string),
+			QueueUrl:      queueUrl,
 		}
 
 		// Extract FIFO-specific attributes
@@ -89,6 +104,12 @@ func (a *awsQueueProvider) PullCheckboxActionMessages(ctx context.Context) ([]Me
 		}
 
 		maps.Copy(msg.Attributes, resultMessage.Attributes)
+		// message attributes set by the publisher, like the traceparent, sit alongside the system attributes
+		for name, value := range resultMessage.MessageAttributes {
+			if value.StringValue != nil {
+				msg.Attributes[name] = aws.ToString(value.StringValue)
+			}
+		}
 
 		messages = append(messages, msg)
 	}
@@ -99,7 +120,10 @@ func (a *awsQueueProvider) PullCheckboxActionMessages(ctx context.Context) ([]Me
 func (a *awsQueueProvider) DeleteMessage(ctx context.Context, message *Message) apierror.APIError {
 	appconfig := apiconfig.GetConfig()
 
-	queueUrl := appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1")
+	queueUrl := message.QueueUrl
+	if queueUrl == "" {
+		queueUrl = appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1")
+	}
 	log.Debug().Msgf("Preparing to delete messages from SQS queue %s", queueUrl)
 
 	sqsClient, apierr := a.getSqsClient(ctx, appconfig.GetString("AWS_AUTH_PROFILE_NAME"))
@@ -121,9 +145,125 @@ func (a *awsQueueProvider) DeleteMessage(ctx context.Context, message *Message)
 	return nil
 }
 
+func (a *awsQueueProvider) ReleaseMessage(ctx context.Context, message *Message) apierror.APIError {
+	appconfig := apiconfig.GetConfig()
+
+	queueUrl := message.QueueUrl
+	if queueUrl == "" {
+		queueUrl = appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1")
+	}
+
+	sqsClient, apierr := a.getSqsClient(ctx, appconfig.GetString("AWS_AUTH_PROFILE_NAME"))
+	if apierr != nil {
+		log.Error().Err(apierr).Msg("failed to get SQS client")
+		return apierror.WrapWithCodeFromConstants(apierr, apierror.ErrQueueUnavailable, "failed to get SQS client")
+	}
+
+	// a visibility timeout of zero makes the message available to receive again immediately
+	_, err := sqsClient.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueUrl),
+		ReceiptHandle:     aws.String(message.ReceiptHandle),
+		VisibilityTimeout: 0,
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to release message ID %s from SQS queue '%s'", message.MessageId, queueUrl)
+		return apierror.WrapWithCodeFromConstants(err, apierror.ErrQueueUnavailable, fmt.Sprintf("failed to release message ID %s from SQS queue '%s'", message.MessageId, queueUrl))
+	}
+
+	return nil
+}
+
+func (a *awsQueueProvider) EnforceQueueRetention(ctx context.Context, retention time.Duration) (int64, apierror.APIError) {
+	appconfig := apiconfig.GetConfig()
+	queueUrls := []string{
+		appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1"),
+		appconfig.GetString("AWS_SQS_CHECKBOXUPDATE_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXUPDATE_CONSUMER"),
+	}
+
+	sqsClient, apierr := a.getSqsClient(ctx, appconfig.GetString("AWS_AUTH_PROFILE_NAME"))
+	if apierr != nil {
+		log.Error().Err(apierr).Msg("failed to get SQS client")
+		return 0, apierror.WrapWithCodeFromConstants(apierr, apierror.ErrQueueUnavailable, "failed to get SQS client")
+	}
+
+	wanted := strconv.FormatInt(int64(retention.Seconds()), 10)
+	var changed int64
+	for _, queueUrl := range queueUrls {
+		result, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+			QueueUrl: aws.String(queueUrl),
+			AttributeNames: []types.QueueAttributeName{
+				types.QueueAttributeNameMessageRetentionPeriod,
+			},
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to get attributes of SQS queue '%s'", queueUrl)
+			return changed, apierror.WrapWithCodeFromConstants(err, apierror.ErrQueueUnavailable, fmt.Sprintf("failed to get attributes of SQS queue '%s'", queueUrl))
+		}
+
+		current := result.Attributes[string(types.QueueAttributeNameMessageRetentionPeriod)]
+		if current == wanted {
+			continue
+		}
+
+		_, err = sqsClient.SetQueueAttributes(ctx, &sqs.SetQueueAttributesInput{
+			QueueUrl: aws.String(queueUrl),
+			Attributes: map[string]string{
+				string(types.QueueAttributeNameMessageRetentionPeriod): wanted,
+			},
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to set retention of SQS queue '%s'", queueUrl)
+			return changed, apierror.WrapWithCodeFromConstants(err, apierror.ErrQueueUnavailable, fmt.Sprintf("failed to set retention of SQS queue '%s'", queueUrl))
+		}
+		log.Info().Msgf("Changed retention of SQS queue '%s' from %s to %s seconds", queueUrl, current, wanted)
+		changed++
+	}
+
+	return changed, nil
+}
+
+func (a *awsQueueProvider) GetCheckboxActionQueueDepth(ctx context.Context) (int64, apierror.APIError) {
+	appconfig := apiconfig.GetConfig()
+	queueUrl := appconfig.GetString("AWS_SQS_CHECKBOXACTION_BASE_URL") + appconfig.GetString("AWS_SQS_CHECKBOXACTION_CONSUMER1")
+
+	sqsClient, apierr := a.getSqsClient(ctx, appconfig.GetString("AWS_AUTH_PROFILE_NAME"))
+	if apierr != nil {
+		log.Error().Err(apierr).Msg("failed to get SQS client")
+		return 0, apierror.WrapWithCodeFromConstants(apierr, apierror.ErrQueueUnavailable, "failed to get SQS client")
+	}
+
+	result, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(queueUrl),
+		AttributeNames: []types.QueueAttributeName{
+			types.QueueAttributeNameApproximateNumberOfMessages,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to get attributes of SQS queue '%s'", queueUrl)
+		return 0, apierror.WrapWithCodeFromConstants(err, apierror.ErrQueueUnavailable, fmt.Sprintf("failed to get attributes of SQS queue '%s'", queueUrl))
+	}
+
+	depth, err := strconv.ParseInt(result.Attributes[string(types.QueueAttributeNameApproximateNumberOfMessages)], 10, 64)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to parse queue depth of SQS queue '%s'", queueUrl)
+		return 0, apierror.WrapWithCodeFromConstants(err, apierror.ErrQueueUnavailable, fmt.Sprintf("failed to parse queue depth of SQS queue '%s'", queueUrl))
+	}
+
+	return depth, nil
+}
+
 func (a *awsQueueProvider) PublishCheckboxAction(ctx context.Context, message *CheckboxActionMessage) (PublishMessageResult, apierror.APIError) {
+	topicArn := apiconfig.GetConfig().GetString("AWS_SNS_CHECKBOXACTION_TOPIC_ARN")
+	return a.publish(ctx, topicArn, message, message.Header)
+}
+
+func (a *awsQueueProvider) PublishCheckboxUpdate(ctx context.Context, message *CheckboxUpdateMessage) (PublishMessageResult, apierror.APIError) {
+	topicArn := apiconfig.GetConfig().GetString("AWS_SNS_CHECKBOXUPDATE_TOPIC_ARN")
+	return a.publish(ctx, topicArn, message, message.Header)
+}
+
+func (a *awsQueueProvider) publish(ctx context.Context, topicArn string, message any, header MessageHeader) (PublishMessageResult, apierror.APIError) {
 	appconfig := apiconfig.GetConfig()
-	topicArn := appconfig.GetString("AWS_SNS_CHECKBOXACTION_TOPIC_ARN")
 
 	snsClient, err := a.getSnsClient(ctx, appconfig.GetString("AWS_AUTH_PROFILE_NAME"))
 	if err != nil {
@@ -139,11 +279,20 @@ func (a *awsQueueProvider) PublishCheckboxAction(ctx context.Context, message *C
 	publishInput := sns.PublishInput{
 		TopicArn:               aws.String(topicArn),
 		Message:                aws.String(string(jsonBytes)),
-		MessageGroupId:         aws.String(message.Header.GroupId),
-		MessageDeduplicationId: aws.String(message.Header.DeduplicationId),
+		MessageGroupId:         aws.String(header.GroupId),
+		MessageDeduplicationId: aws.String(header.DeduplicationId),
+	}
+	if header.Traceparent != "" {
+		// also carried as an attribute, for consumers that trace without reading the body
+		publishInput.MessageAttributes = map[string]snstypes.MessageAttributeValue{
+			tracing.TraceparentHeader: {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(header.Traceparent),
+			},
+		}
 	}
 
-	log.Debug().Msg("Publishing message to SNS")
+	log.Debug().Msgf("Publishing message to SNS topic %s", topicArn)
 	pubOut, baseerr := snsClient.Publish(ctx, &publishInput)
 	if baseerr != nil {
 		log.Error().Err(baseerr).Msg("failed to publish message to SNS")
@@ -0,0 +1,147 @@
+package queueservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/google/uuid"
+)
+
+const (
+	memoryQueueCheckboxAction = "memory://checkbox-action"
+	memoryQueueCheckboxUpdate = "memory://checkbox-update"
+	memoryQueueBatchSize      = 10
+)
+
+// MemoryQueueProvider is a QueueProvider held entirely in memory, for testing the API and backend without AWS.
+// Each topic feeds a single queue. A pulled message stays in flight until it is deleted or released, there is no
+// visibility timeout.
+type MemoryQueueProvider struct {
+	mu       sync.Mutex
+	queues   map[string][]*memoryQueueMessage
+	sequence int64
+}
+
+type memoryQueueMessage struct {
+	message  Message
+	inFlight bool
+}
+
+func NewMemoryQueueProvider() *MemoryQueueProvider {
+	return &MemoryQueueProvider{
+		queues: make(map[string][]*memoryQueueMessage),
+	}
+}
+
+func (m *MemoryQueueProvider) PublishCheckboxAction(_ context.Context, message *CheckboxActionMessage) (PublishMessageResult, apierror.APIError) {
+	return m.publish(memoryQueueCheckboxAction, message, message.Header)
+}
+
+func (m *MemoryQueueProvider) PullCheckboxActionMessages(_ context.Context) ([]Message, apierror.APIError) {
+	return m.pull(memoryQueueCheckboxAction), nil
+}
+
+func (m *MemoryQueueProvider) PublishCheckboxUpdate(_ context.Context, message *CheckboxUpdateMessage) (PublishMessageResult, apierror.APIError) {
+	return m.publish(memoryQueueCheckboxUpdate, message, message.Header)
+}
+
+func (m *MemoryQueueProvider) PullCheckboxUpdateMessages(_ context.Context) ([]Message, apierror.APIError) {
+	return m.pull(memoryQueueCheckboxUpdate), nil
+}
+
+func (m *MemoryQueueProvider) DeleteMessage(_ context.Context, message *Message) apierror.APIError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	queue := m.queues[message.QueueUrl]
+	for i, queued := range queue {
+		if queued.message.ReceiptHandle == message.ReceiptHandle {
+			m.queues[message.QueueUrl] = append(queue[:i], queue[i+1:]...)
+			return nil
+		}
+	}
+	return apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, fmt.Sprintf("message %s not found on queue %s", message.MessageId, message.QueueUrl))
+}
+
+func (m *MemoryQueueProvider) ReleaseMessage(_ context.Context, message *Message) apierror.APIError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, queued := range m.queues[message.QueueUrl] {
+		if queued.message.ReceiptHandle == message.ReceiptHandle {
+			queued.inFlight = false
+			return nil
+		}
+	}
+	return apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, fmt.Sprintf("message %s not found on queue %s", message.MessageId, message.QueueUrl))
+}
+
+func (m *MemoryQueueProvider) GetCheckboxActionQueueDepth(_ context.Context) (int64, apierror.APIError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var depth int64
+	for _, queued := range m.queues[memoryQueueCheckboxAction] {
+		if !queued.inFlight {
+			depth++
+		}
+	}
+	return depth, nil
+}
+
+func (m *MemoryQueueProvider) EnforceQueueRetention(_ context.Context, _ time.Duration) (int64, apierror.APIError) {
+	return 0, nil
+}
+
+func (m *MemoryQueueProvider) publish(queueUrl string, message any, header MessageHeader) (PublishMessageResult, apierror.APIError) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return PublishMessageResult{}, apierror.WrapWithCodeFromConstants(err, apierror.ErrInternalServer, "failed to marshal message to JSON")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sequence++
+	queued := &memoryQueueMessage{
+		message: Message{
+			MessageId:      uuid.NewString(),
+			ReceiptHandle:  uuid.NewString(),
+			Body:           string(body),
+			GroupId:        header.GroupId,
+			SequenceNumber: strconv.FormatInt(m.sequence, 10),
+			Attributes:     make(map[string]string),
+			QueueUrl:       queueUrl,
+		},
+	}
+	m.queues[queueUrl] = append(m.queues[queueUrl], queued)
+
+	return PublishMessageResult{
+		MessageId:      queued.message.MessageId,
+		SequenceNumber: queued.message.SequenceNumber,
+		PublishTime:    time.Now(),
+	}, nil
+}
+
+// pull marks up to a batch of the oldest waiting messages as in flight, and returns them
+func (m *MemoryQueueProvider) pull(queueUrl string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	messages := make([]Message, 0, memoryQueueBatchSize)
+	for _, queued := range m.queues[queueUrl] {
+		if len(messages) == memoryQueueBatchSize {
+			break
+		}
+		if !queued.inFlight {
+			queued.inFlight = true
+			messages = append(messages, queued.message)
+		}
+	}
+	return messages
+}
@@ -18,10 +18,21 @@ const (
 	CheckboxActionUnchecked = "unchecked"
 )
 
+const (
+	CheckboxUpdateEventChanged         = "checkbox_changed"
+	CheckboxUpdateEventRequestComplete = "request_complete"
+
+	CheckboxUpdateResultSuccess = "success"
+	CheckboxUpdateResultFailed  = "failed"
+)
+
 type MessageHeader struct {
 	PayloadSchemaVersion string `json:"payload_schema_version"`
 	GroupId              string `json:"group_id"`
 	DeduplicationId      string `json:"deduplication_id"`
+	// the trace of the request that caused the message, so the consumer can carry it on, see Message.TraceContext
+	TraceId     string `json:"trace_id,omitempty"`
+	Traceparent string `json:"traceparent,omitempty"`
 }
 
 type CheckboxActionPayload struct {
@@ -39,6 +50,25 @@ type CheckboxActionMessage struct {
 	Payload CheckboxActionPayload `json:"payload"`
 }
 
+// CheckboxUpdatePayload is published by the backend once a checkbox action has been committed to the database.
+// CheckboxUpdateEventChanged tells every API server the new state of a checkbox, and
+// CheckboxUpdateEventRequestComplete tells the requesting client whether its request succeeded or failed.
+type CheckboxUpdatePayload struct {
+	EventType   string    `json:"event_type"`
+	CheckboxNbr int       `json:"checkbox_nbr"`
+	Checked     bool      `json:"checked"`
+	UserUuid    string    `json:"user_uuid"`
+	RequestUuid string    `json:"request_uuid"`
+	Result      string    `json:"result"`
+	Reason      string    `json:"reason"`
+	EventTime   time.Time `json:"event_time"`
+}
+
+type CheckboxUpdateMessage struct {
+	Header  MessageHeader         `json:"header"`
+	Payload CheckboxUpdatePayload `json:"payload"`
+}
+
 type PublishMessageResult struct {
 	MessageId      string    `json:"message_id"`
 	SequenceNumber string    `json:"sequence_number"`
@@ -48,7 +78,12 @@ type PublishMessageResult struct {
 type QueueProvider interface {
 	PublishCheckboxAction(ctx context.Context, message *CheckboxActionMessage) (PublishMessageResult, apierror.APIError)
 	PullCheckboxActionMessages(ctx context.Context) ([]Message, apierror.APIError)
+	PublishCheckboxUpdate(ctx context.Context, message *CheckboxUpdateMessage) (PublishMessageResult, apierror.APIError)
+	PullCheckboxUpdateMessages(ctx context.Context) ([]Message, apierror.APIError)
 	DeleteMessage(ctx context.Context, message *Message) apierror.APIError
+	ReleaseMessage(ctx context.Context, message *Message) apierror.APIError
+	GetCheckboxActionQueueDepth(ctx context.Context) (int64, apierror.APIError)
+	EnforceQueueRetention(ctx context.Context, retention time.Duration) (int64, apierror.APIError)
 }
 
 type Message struct {
@@ -58,11 +93,13 @@ type Message struct {
 	GroupId        string
 	SequenceNumber string
 	Attributes     map[string]string
+	QueueUrl       string // the queue the message was pulled from, so it can be deleted from the same queue
 }
 
 var (
 	providerInstance QueueProvider
 	providerOnce     sync.Once
+	providerMu       sync.RWMutex // guards providerInstance once set
 )
 
 func (m *Message) UnmarshalBody(v any) apierror.APIError {
@@ -73,6 +110,37 @@ func (m *Message) UnmarshalBody(v any) apierror.APIError {
 	return nil
 }
 
+// TraceContext returns the context with the trace the message was published under, so the work done for it continues
+// the trace of the request that caused it. The traceparent message attribute is used if present, otherwise the header
+// in the body. The context is returned unchanged if the message carries no trace. See also StartProcessSpan.
+func (m *Message) TraceContext(ctx context.Context) context.Context {
+	body := struct {
+		Header MessageHeader `json:"header"`
+	}{}
+	_ = json.Unmarshal([]byte(m.Body), &body)
+
+	traceparent := m.Attributes[tracing.TraceparentHeader]
+	if traceparent == "" {
+		traceparent = body.Header.Traceparent
+	}
+	ctx, _ = tracing.ContextWithTraceparent(ctx, traceparent)
+
+	// the trace ID the request was logged under, which is the traceparent's unless it wasn't a UUID
+	if body.Header.TraceId != "" {
+		ctx = tracing.WithTraceID(ctx, body.Header.TraceId)
+	}
+	return ctx
+}
+
+// SetQueueProvider replaces the provider chosen by QUEUE_PROVIDER, eg with a MemoryQueueProvider in tests
+func SetQueueProvider(provider QueueProvider) {
+	providerOnce.Do(func() {})
+	providerMu.Lock()
+	defer providerMu.Unlock()
+
+	providerInstance = &tracedQueueProvider{next: provider}
+}
+
 func getQueueProvider() QueueProvider {
 	providerOnce.Do(func() {
 		config := apiconfig.GetConfig()
@@ -85,7 +153,11 @@ func getQueueProvider() QueueProvider {
 			// Default to AWS if not specified or invalid
 			providerInstance = &awsQueueProvider{}
 		}
+		providerInstance = &tracedQueueProvider{next: providerInstance}
 	})
+
+	providerMu.RLock()
+	defer providerMu.RUnlock()
 	return providerInstance
 }
 
@@ -109,6 +181,7 @@ func PublishCheckboxAction(ctx context.Context, payload CheckboxActionPayload) (
 			PayloadSchemaVersion: "1.0",
 			GroupId:              fmt.Sprintf("checkbox-%d", payload.CheckboxNbr),
 			DeduplicationId:      payload.RequestUuid,
+			TraceId:              traceID,
 		},
 		Payload: payload,
 	}
@@ -132,6 +205,49 @@ func PublishCheckboxAction(ctx context.Context, payload CheckboxActionPayload) (
 	return result, nil
 }
 
+func PublishCheckboxUpdate(ctx context.Context, payload CheckboxUpdatePayload) (PublishMessageResult, apierror.APIError) {
+	traceID := tracing.GetTraceIDFromContext(ctx)
+
+	// Log the queue operation
+	logging.LogQueueOperation(traceID, "publish_checkbox_update", map[string]any{
+		"event_type":   payload.EventType,
+		"checkbox_nbr": payload.CheckboxNbr,
+		"checked":      payload.Checked,
+		"request_uuid": payload.RequestUuid,
+		"result":       payload.Result,
+		"trace_id":     traceID,
+	})
+
+	// Create the message with header, grouped by checkbox so updates to the same checkbox stay in order
+	message := &CheckboxUpdateMessage{
+		Header: MessageHeader{
+			PayloadSchemaVersion: "1.0",
+			GroupId:              fmt.Sprintf("checkbox-%d", payload.CheckboxNbr),
+			DeduplicationId:      payload.RequestUuid + "-" + payload.EventType,
+			TraceId:              traceID,
+		},
+		Payload: payload,
+	}
+
+	// Get the provider and publish the message
+	provider := getQueueProvider()
+	result, err := provider.PublishCheckboxUpdate(ctx, message)
+	if err != nil {
+		logging.LogQueueOperation(traceID, "publish_checkbox_update_failed", map[string]any{
+			"error": err.Error(),
+		})
+		return PublishMessageResult{}, err
+	}
+
+	// Log successful publication
+	logging.LogQueueOperation(traceID, "publish_checkbox_update_success", map[string]any{
+		"message_id":      result.MessageId,
+		"sequence_number": result.SequenceNumber,
+	})
+
+	return result, nil
+}
+
 func PullCheckboxActionMessages(ctx context.Context) ([]Message, apierror.APIError) {
 	logging.LogQueueOperation(tracing.GetTraceIDFromContext(ctx), "pull_checkbox_action_messages", nil)
 	provider := getQueueProvider()
@@ -140,6 +256,14 @@ func PullCheckboxActionMessages(ctx context.Context) ([]Message, apierror.APIErr
 	return messages, err
 }
 
+func PullCheckboxUpdateMessages(ctx context.Context) ([]Message, apierror.APIError) {
+	logging.LogQueueOperation(tracing.GetTraceIDFromContext(ctx), "pull_checkbox_update_messages", nil)
+	provider := getQueueProvider()
+	messages, err := provider.PullCheckboxUpdateMessages(ctx)
+
+	return messages, err
+}
+
 func DeleteMessage(ctx context.Context, message *Message) apierror.APIError {
 	logging.LogQueueOperation(tracing.GetTraceIDFromContext(ctx), "delete_message", map[string]any{
 		"message_id":      message.MessageId,
@@ -149,3 +273,28 @@ func DeleteMessage(ctx context.Context, message *Message) apierror.APIError {
 	provider := getQueueProvider()
 	return provider.DeleteMessage(ctx, message)
 }
+
+// ReleaseMessage makes a pulled message visible on its queue again straight away, rather than after the visibility
+// timeout, so another consumer can pick it up
+func ReleaseMessage(ctx context.Context, message *Message) apierror.APIError {
+	logging.LogQueueOperation(tracing.GetTraceIDFromContext(ctx), "release_message", map[string]any{
+		"message_id":      message.MessageId,
+		"sequence_number": message.SequenceNumber,
+	})
+
+	provider := getQueueProvider()
+	return provider.ReleaseMessage(ctx, message)
+}
+
+// GetCheckboxActionQueueDepth returns the approximate number of checkbox action messages waiting to be consumed
+func GetCheckboxActionQueueDepth(ctx context.Context) (int64, apierror.APIError) {
+	provider := getQueueProvider()
+	return provider.GetCheckboxActionQueueDepth(ctx)
+}
+
+// EnforceQueueRetention sets the message retention period of the queues this service consumes, where it differs from
+// the given retention, and returns the number of queues it changed
+func EnforceQueueRetention(ctx context.Context, retention time.Duration) (int64, apierror.APIError) {
+	provider := getQueueProvider()
+	return provider.EnforceQueueRetention(ctx, retention)
+}
@@ -0,0 +1,129 @@
+package queueservice
+
+import (
+	"context"
+	"time"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedQueueProvider wraps a QueueProvider, recording a span for each call. Published messages carry the traceparent
+// of their publish span, so the consumer's span continues the trace from it, see StartProcessSpan.
+type tracedQueueProvider struct {
+	next QueueProvider
+}
+
+func (t *tracedQueueProvider) PublishCheckboxAction(ctx context.Context, message *CheckboxActionMessage) (PublishMessageResult, apierror.APIError) {
+	ctx, span := startQueueSpan(ctx, "checkbox_action publish", trace.SpanKindProducer,
+		semconv.MessagingOperationTypePublish,
+		semconv.MessagingMessageConversationID(message.Header.GroupId))
+	defer span.End()
+
+	message.Header.Traceparent = tracing.TraceparentFromContext(ctx)
+	result, err := t.next.PublishCheckboxAction(ctx, message)
+	endPublishSpan(span, result, err)
+	return result, err
+}
+
+func (t *tracedQueueProvider) PullCheckboxActionMessages(ctx context.Context) ([]Message, apierror.APIError) {
+	ctx, span := startQueueSpan(ctx, "checkbox_action receive", trace.SpanKindConsumer,
+		semconv.MessagingOperationTypeReceive)
+	defer span.End()
+
+	messages, err := t.next.PullCheckboxActionMessages(ctx)
+	span.SetAttributes(semconv.MessagingBatchMessageCount(len(messages)))
+	tracing.RecordError(span, err)
+	return messages, err
+}
+
+func (t *tracedQueueProvider) PublishCheckboxUpdate(ctx context.Context, message *CheckboxUpdateMessage) (PublishMessageResult, apierror.APIError) {
+	ctx, span := startQueueSpan(ctx, "checkbox_update publish", trace.SpanKindProducer,
+		semconv.MessagingOperationTypePublish,
+		semconv.MessagingMessageConversationID(message.Header.GroupId))
+	defer span.End()
+
+	message.Header.Traceparent = tracing.TraceparentFromContext(ctx)
+	result, err := t.next.PublishCheckboxUpdate(ctx, message)
+	endPublishSpan(span, result, err)
+	return result, err
+}
+
+func (t *tracedQueueProvider) PullCheckboxUpdateMessages(ctx context.Context) ([]Message, apierror.APIError) {
+	ctx, span := startQueueSpan(ctx, "checkbox_update receive", trace.SpanKindConsumer,
+		semconv.MessagingOperationTypeReceive)
+	defer span.End()
+
+	messages, err := t.next.PullCheckboxUpdateMessages(ctx)
+	span.SetAttributes(semconv.MessagingBatchMessageCount(len(messages)))
+	tracing.RecordError(span, err)
+	return messages, err
+}
+
+func (t *tracedQueueProvider) DeleteMessage(ctx context.Context, message *Message) apierror.APIError {
+	ctx, span := startQueueSpan(ctx, "message delete", trace.SpanKindClient,
+		semconv.MessagingOperationTypeSettle,
+		semconv.MessagingMessageID(message.MessageId))
+	defer span.End()
+
+	err := t.next.DeleteMessage(ctx, message)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedQueueProvider) ReleaseMessage(ctx context.Context, message *Message) apierror.APIError {
+	ctx, span := startQueueSpan(ctx, "message release", trace.SpanKindClient,
+		semconv.MessagingOperationTypeSettle,
+		semconv.MessagingMessageID(message.MessageId))
+	defer span.End()
+
+	err := t.next.ReleaseMessage(ctx, message)
+	tracing.RecordError(span, err)
+	return err
+}
+
+func (t *tracedQueueProvider) GetCheckboxActionQueueDepth(ctx context.Context) (int64, apierror.APIError) {
+	ctx, span := startQueueSpan(ctx, "checkbox_action depth", trace.SpanKindClient)
+	defer span.End()
+
+	depth, err := t.next.GetCheckboxActionQueueDepth(ctx)
+	span.SetAttributes(attribute.Int64("messaging.queue.depth", depth))
+	tracing.RecordError(span, err)
+	return depth, err
+}
+
+func (t *tracedQueueProvider) EnforceQueueRetention(ctx context.Context, retention time.Duration) (int64, apierror.APIError) {
+	ctx, span := startQueueSpan(ctx, "queue retention", trace.SpanKindClient,
+		attribute.String("messaging.queue.retention", retention.String()))
+	defer span.End()
+
+	changed, err := t.next.EnforceQueueRetention(ctx, retention)
+	tracing.RecordError(span, err)
+	return changed, err
+}
+
+// StartProcessSpan starts the span for processing a message pulled from a queue, continuing the trace of the request
+// that published it. The context also carries the request's trace ID, so everything logged for the message is tagged
+// with it. The caller must end the span.
+func StartProcessSpan(ctx context.Context, message *Message, operation string) (context.Context, trace.Span) {
+	return startQueueSpan(message.TraceContext(ctx), operation+" process", trace.SpanKindConsumer,
+		semconv.MessagingOperationTypeDeliver,
+		semconv.MessagingMessageID(message.MessageId),
+		semconv.MessagingMessageConversationID(message.GroupId))
+}
+
+func startQueueSpan(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, semconv.MessagingSystemAWSSqs)
+	return tracing.StartSpan(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+func endPublishSpan(span trace.Span, result PublishMessageResult, err apierror.APIError) {
+	if err != nil {
+		tracing.RecordError(span, err)
+		return
+	}
+	span.SetAttributes(semconv.MessagingMessageID(result.MessageId))
+}
@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// a Wednesday
+	base := time.Date(2025, time.January, 15, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		spec     string
+		after    time.Time
+		expected time.Time
+	}{
+		{
+			name:     "Every minute",
+			spec:     "* * * * *",
+			after:    base,
+			expected: time.Date(2025, time.January, 15, 10, 8, 0, 0, time.UTC),
+		},
+		{
+			name:     "Every 15 minutes",
+			spec:     "*/15 * * * *",
+			after:    base,
+			expected: time.Date(2025, time.January, 15, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name:     "Fixed minute rolls over to the next hour",
+			spec:     "5 * * * *",
+			after:    base,
+			expected: time.Date(2025, time.January, 15, 11, 5, 0, 0, time.UTC),
+		},
+		{
+			name:     "Exact match is not repeated",
+			spec:     "7 10 * * *",
+			after:    base,
+			expected: time.Date(2025, time.January, 16, 10, 7, 0, 0, time.UTC),
+		},
+		{
+			name:     "Daily descriptor",
+			spec:     "@daily",
+			after:    base,
+			expected: time.Date(2025, time.January, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Hour range and list",
+			spec:     "0 9-11,14 * * *",
+			after:    base,
+			expected: time.Date(2025, time.January, 15, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Day of week",
+			spec:     "0 3 * * 1",
+			after:    base,
+			expected: time.Date(2025, time.January, 20, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Sunday as 7",
+			spec:     "0 0 * * 7",
+			after:    base,
+			expected: time.Date(2025, time.January, 19, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Day of month or day of week when both are restricted",
+			spec:     "0 0 1 * 5",
+			after:    base,
+			expected: time.Date(2025, time.January, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Month rolls over to the next year",
+			spec:     "0 0 1 1 *",
+			after:    base,
+			expected: time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Leap day",
+			spec:     "0 0 29 2 *",
+			after:    base,
+			expected: time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Every interval",
+			spec:     "@every 10m",
+			after:    base,
+			expected: time.Date(2025, time.January, 15, 10, 10, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, schedule.Next(tt.after))
+		})
+	}
+}
+
+func TestScheduleNeverMatches(t *testing.T) {
+	schedule, err := ParseSchedule("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, schedule.Next(time.Now()).IsZero())
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          string
+		errorContains string
+	}{
+		{name: "Too few fields", spec: "* * * *", errorContains: "has 4 fields"},
+		{name: "Too many fields", spec: "* * * * * *", errorContains: "has 6 fields"},
+		{name: "Minute out of range", spec: "60 * * * *", errorContains: "out of range"},
+		{name: "Month zero", spec: "* * * 0 *", errorContains: "out of range"},
+		{name: "Not a number", spec: "abc * * * *", errorContains: "invalid value"},
+		{name: "Backwards range", spec: "* 5-2 * * *", errorContains: "invalid range"},
+		{name: "Zero step", spec: "*/0 * * * *", errorContains: "invalid step"},
+		{name: "Bad interval", spec: "@every soon", errorContains: "invalid @every interval"},
+		{name: "Interval too short", spec: "@every 10ms", errorContains: "less than a second"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchedule(tt.spec)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorContains)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultJobTimeout = 10 * time.Minute
+	// the lease outlives the job timeout by this much, so a job that is still cleaning up keeps its lease
+	jobLeaseMargin = time.Minute
+	// used to record the outcome of a run, since the job's own context may have timed out
+	jobBookkeepingTimeout = 10 * time.Second
+)
+
+// JobFunc runs a job once, and returns the number of rows or other items it affected
+type JobFunc func(ctx context.Context) (int64, apierror.APIError)
+
+// JobDefinition describes a maintenance job. The schedule, timeout and whether the job is enabled at all can be
+// overridden in config, with the JOB_<NAME>_SCHEDULE, JOB_<NAME>_TIMEOUT and JOB_<NAME>_ENABLED keys.
+type JobDefinition struct {
+	Name            string // lower snake case, eg purge_updates
+	DefaultSchedule string // cron expression, see ParseSchedule
+	DefaultTimeout  time.Duration
+	Run             JobFunc
+}
+
+type job struct {
+	name     string
+	schedule Schedule
+	timeout  time.Duration
+	run      JobFunc
+}
+
+// Scheduler runs maintenance jobs on their schedules. Schedulers on different backend instances share a lease per
+// job in the database, so each scheduled run of a job happens on only one of them.
+type Scheduler struct {
+	owner string
+	jobs  []job
+}
+
+// ConfigKey returns the config key for a job setting, eg ConfigKey("purge_updates", "RETENTION") is
+// JOB_PURGE_UPDATES_RETENTION
+func ConfigKey(jobName string, setting string) string {
+	return "JOB_" + strings.ToUpper(jobName) + "_" + setting
+}
+
+func NewScheduler() *Scheduler {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return &Scheduler{
+		owner: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+	}
+}
+
+// Register adds a job to the scheduler, unless it is disabled in config. It fails if the schedule is invalid.
+func (s *Scheduler) Register(def JobDefinition) error {
+	if !apiconfig.GetBoolWithDefault(ConfigKey(def.Name, "ENABLED"), true) {
+		log.Info().Msgf("Scheduled job %s is disabled", def.Name)
+		return nil
+	}
+
+	spec := def.DefaultSchedule
+	if key := ConfigKey(def.Name, "SCHEDULE"); apiconfig.GetConfig().IsSet(key) {
+		spec = apiconfig.GetConfig().GetString(key)
+	}
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule for job %s: %w", def.Name, err)
+	}
+
+	timeout := def.DefaultTimeout
+	if timeout <= 0 {
+		timeout = defaultJobTimeout
+	}
+
+	s.jobs = append(s.jobs, job{
+		name:     def.Name,
+		schedule: schedule,
+		timeout:  apiconfig.GetDurationWithDefault(ConfigKey(def.Name, "TIMEOUT"), timeout),
+		run:      def.Run,
+	})
+	log.Info().Msgf("Scheduled job %s registered with schedule '%s'", def.Name, spec)
+	return nil
+}
+
+// Run runs every registered job on its schedule until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	log.Info().Msgf("Scheduler started as %s with %d jobs", s.owner, len(s.jobs))
+
+	done := make(chan struct{}, len(s.jobs))
+	for _, j := range s.jobs {
+		go func(j job) {
+			s.runJobLoop(ctx, j)
+			done <- struct{}{}
+		}(j)
+	}
+
+	for range s.jobs {
+		<-done
+	}
+	log.Info().Msg("Scheduler stopped")
+}
+
+func (s *Scheduler) runJobLoop(ctx context.Context, j job) {
+	for {
+		scheduledTime := j.schedule.Next(time.Now())
+		if scheduledTime.IsZero() {
+			log.Warn().Msgf("Scheduled job %s has no future run times, stopping it", j.name)
+			return
+		}
+
+		// Context-aware sleep until the next run
+		timer := time.NewTimer(time.Until(scheduledTime))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.runJob(ctx, j, scheduledTime)
+	}
+}
+
+// runJob runs one scheduled run of a job, if this instance can take the job's lease for it
+func (s *Scheduler) runJob(ctx context.Context, j job, scheduledTime time.Time) {
+	acquired, err := dbservice.AcquireJobLease(ctx, j.name, s.owner, scheduledTime, j.timeout+jobLeaseMargin)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to acquire lease for scheduled job %s, skipping run scheduled at %v", j.name, scheduledTime)
+		return
+	}
+	if !acquired {
+		log.Debug().Msgf("Scheduled job %s run at %v is being handled by another instance", j.name, scheduledTime)
+		return
+	}
+	defer func() {
+		bookkeepingCtx, cancel := context.WithTimeout(context.Background(), jobBookkeepingTimeout)
+		defer cancel()
+		_ = dbservice.ReleaseJobLease(bookkeepingCtx, j.name, s.owner)
+	}()
+
+	runId, err := dbservice.StartJobRun(ctx, j.name, s.owner, scheduledTime)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to record start of scheduled job %s, skipping run scheduled at %v", j.name, scheduledTime)
+		return
+	}
+
+	log.Info().Msgf("Scheduled job %s started, run %d", j.name, runId)
+	startTime := time.Now()
+	affected, jobErr := s.invoke(ctx, j)
+
+	bookkeepingCtx, cancel := context.WithTimeout(context.Background(), jobBookkeepingTimeout)
+	defer cancel()
+	_ = dbservice.FinishJobRun(bookkeepingCtx, runId, affected, jobErr)
+
+	if jobErr != nil {
+		log.Error().Err(jobErr).Msgf("Scheduled job %s run %d failed after %v, %d affected", j.name, runId, time.Since(startTime), affected)
+		return
+	}
+	log.Info().Msgf("Scheduled job %s run %d succeeded in %v, %d affected", j.name, runId, time.Since(startTime), affected)
+}
+
+// invoke calls the job with its timeout, turning a panic into an error so it is recorded like any other failure
+func (s *Scheduler) invoke(ctx context.Context, j job) (affected int64, err apierror.APIError) {
+	jobCtx, cancel := context.WithTimeout(ctx, j.timeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = apierror.InternalError(fmt.Sprintf("panic in scheduled job %s: %v", j.name, r))
+		}
+	}()
+
+	return j.run(jobCtx)
+}
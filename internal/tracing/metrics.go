@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+const defaultMetricsExportInterval = 60 * time.Second
+
+// InitMetricsFromConfig sets up OpenTelemetry metrics for the service, with the exporter picked by the
+// METRICS_EXPORTER config key, which takes the same values as TRACING_EXPORTER. Instruments made with Meter before
+// this is called still record to the exporter. The returned function exports anything still buffered, and should be
+// called on shutdown.
+func InitMetricsFromConfig(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	appconfig := apiconfig.GetConfig()
+	exporterName := apiconfig.GetStringWithDefault("METRICS_EXPORTER", ExporterNone)
+
+	var exporter sdkmetric.Exporter
+	var err error
+	switch exporterName {
+	case ExporterNone:
+		log.Info().Msg("Metrics exporter is none, metrics are not recorded")
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdoutmetric.New(stdoutmetric.WithWriter(os.Stdout))
+	case ExporterOtlp:
+		// the endpoint and headers can also come from the standard OTEL_EXPORTER_OTLP_* environment variables
+		var opts []otlpmetrichttp.Option
+		if endpoint := appconfig.GetString("METRICS_OTLP_ENDPOINT"); endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlpmetrichttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown METRICS_EXPORTER '%s', expected none, stdout or otlp", exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s metric exporter: %w", exporterName, err)
+	}
+
+	res, err := serviceResource(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric resource: %w", err)
+	}
+
+	interval := apiconfig.GetDurationWithDefault("METRICS_EXPORT_INTERVAL", defaultMetricsExportInterval)
+	if interval <= 0 {
+		return nil, fmt.Errorf("METRICS_EXPORT_INTERVAL must be positive, got %v", interval)
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(provider)
+
+	log.Info().Msgf("Metrics enabled for %s, exporting to %s every %v", serviceName, exporterName, interval)
+	return provider.Shutdown, nil
+}
+
+// Meter returns the service's meter, to make instruments with
+func Meter() metric.Meter {
+	return otel.Meter(tracerName)
+}
@@ -2,10 +2,15 @@ package tracing
 
 import (
 	"context"
+	"net/http"
 
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/uuidservice"
 	"github.com/gin-gonic/gin"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -14,11 +19,20 @@ const (
 	RequestIDKey  = "request_id" // Alternative key name for compatibility
 )
 
-// RequestIDMiddleware generates and adds trace ID to each request
+// RequestIDMiddleware generates and adds trace ID to each request, and runs the request in an OpenTelemetry span
 func RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Check if trace ID already exists in headers (for distributed tracing)
-		traceID := c.GetHeader(TraceIDHeader)
+		ctx := c.Request.Context()
+
+		// Check if trace ID already exists in headers (for distributed tracing). A W3C traceparent wins, so the
+		// request's span joins the caller's trace, otherwise our own header is used.
+		traceID := ""
+		if id, ok := ParseTraceparent(c.GetHeader(TraceparentHeader)); ok {
+			ctx = propagator.Extract(ctx, propagation.HeaderCarrier(c.Request.Header))
+			traceID = id
+		} else {
+			traceID = c.GetHeader(TraceIDHeader)
+		}
 
 		// If no trace ID in headers, generate a new one
 		if traceID == "" {
@@ -40,8 +54,21 @@ func RequestIDMiddleware() gin.HandlerFunc {
 		c.Set(TraceIDKey, traceID)
 		c.Set(RequestIDKey, traceID) // Set both keys for compatibility
 
-		// Add trace ID to the Go context for downstream services
-		ctx := context.WithValue(c.Request.Context(), TraceIDKey, traceID)
+		// Add trace ID to the Go context for downstream services, and start the request's span, which takes the
+		// trace ID as its own when there is no parent
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched route"
+		}
+		ctx, span := StartSpan(WithTraceID(ctx, traceID), c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+			))
+		defer span.End()
 		c.Request = c.Request.WithContext(ctx)
 
 		// Add trace ID to response headers for clients
@@ -49,6 +76,12 @@ func RequestIDMiddleware() gin.HandlerFunc {
 
 		// Continue processing
 		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
 	}
 }
 
@@ -72,18 +105,21 @@ func GetTraceIDFromContext(ctx context.Context) string {
 	return ""
 }
 
-// WithTraceID adds trace ID to a Go context
+// WithTraceID adds trace ID to a Go context, along with a logger that includes it, so anything logging with
+// log.Ctx(ctx) is tagged with the trace ID
 func WithTraceID(ctx context.Context, traceID string) context.Context {
-	return context.WithValue(ctx, TraceIDKey, traceID)
+	ctx = context.WithValue(ctx, TraceIDKey, traceID)
+	return log.With().Str(TraceIDKey, traceID).Logger().WithContext(ctx)
 }
 
-// PropagateTraceID creates a new context with trace ID from gin context
+// PropagateTraceID creates a new context with trace ID from gin context. It is not cancelled with the request, but
+// keeps the request's span, so the work done on it stays part of the request's trace.
 func PropagateTraceID(c *gin.Context) context.Context {
 	traceID := GetTraceID(c)
 	if traceID == "" {
 		return c.Request.Context()
 	}
-	return WithTraceID(context.Background(), traceID)
+	return WithTraceID(context.WithoutCancel(c.Request.Context()), traceID)
 }
 
 // LogWithTraceID creates a log event with trace ID from gin context
@@ -152,8 +188,7 @@ func ConfigurableRequestIDMiddleware(config Config) gin.HandlerFunc {
 
 		// Add trace ID to the Go context if downstream propagation is enabled
 		if config.PropagateDownstream {
-			ctx := context.WithValue(c.Request.Context(), TraceIDKey, traceID)
-			c.Request = c.Request.WithContext(ctx)
+			c.Request = c.Request.WithContext(WithTraceID(c.Request.Context(), traceID))
 		}
 
 		// Add trace ID to response headers for clients
@@ -164,9 +199,11 @@ func ConfigurableRequestIDMiddleware(config Config) gin.HandlerFunc {
 	}
 }
 
-// TraceOperation logs the start and end of an operation with trace ID
+// TraceOperation logs the start and end of an operation with trace ID, and records it as a span
 func TraceOperation(c *gin.Context, operation string, fn func() error) error {
 	traceID := GetTraceID(c)
+	_, span := StartSpan(c.Request.Context(), operation)
+	defer span.End()
 
 	log.Debug().
 		Str(TraceIDKey, traceID).
@@ -174,6 +211,7 @@ func TraceOperation(c *gin.Context, operation string, fn func() error) error {
 		Msg("Operation started")
 
 	err := fn()
+	RecordError(span, err)
 
 	if err != nil {
 		log.Error().
@@ -191,9 +229,11 @@ func TraceOperation(c *gin.Context, operation string, fn func() error) error {
 	return err
 }
 
-// TraceOperationWithContext logs operation with explicit context
+// TraceOperationWithContext logs operation with explicit context, and records it as a span
 func TraceOperationWithContext(ctx context.Context, operation string, fn func() error) error {
 	traceID := GetTraceIDFromContext(ctx)
+	_, span := StartSpan(ctx, operation)
+	defer span.End()
 
 	log.Debug().
 		Str(TraceIDKey, traceID).
@@ -201,6 +241,7 @@ func TraceOperationWithContext(ctx context.Context, operation string, fn func()
 		Msg("Operation started")
 
 	err := fn()
+	RecordError(span, err)
 
 	if err != nil {
 		log.Error().
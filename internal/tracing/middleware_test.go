@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name            string
+		headers         map[string]string
+		expectedTraceID string
+	}{
+		{
+			name:            "Traceparent",
+			headers:         map[string]string{TraceparentHeader: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			expectedTraceID: "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+		},
+		{
+			name: "Traceparent wins over trace ID header",
+			headers: map[string]string{
+				TraceparentHeader: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				TraceIDHeader:     "some-other-id",
+			},
+			expectedTraceID: "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+		},
+		{
+			name: "Invalid traceparent falls back to trace ID header",
+			headers: map[string]string{
+				TraceparentHeader: "garbage",
+				TraceIDHeader:     "some-other-id",
+			},
+			expectedTraceID: "some-other-id",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contextTraceID string
+			r := gin.New()
+			r.Use(RequestIDMiddleware())
+			r.GET("/test", func(c *gin.Context) {
+				contextTraceID = GetTraceIDFromContext(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedTraceID, w.Header().Get(TraceIDHeader))
+			assert.Equal(t, tt.expectedTraceID, contextTraceID)
+		})
+	}
+}
+
+func TestRequestIDMiddlewareGeneratesTraceID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(RequestIDMiddleware())
+	r.GET("/test", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+	_, err := uuid.Parse(w.Header().Get(TraceIDHeader))
+	assert.NoError(t, err)
+}
+
+func TestTraceIDGenerator(t *testing.T) {
+	gen := newTraceIDGenerator()
+	traceID := "4bf92f35-77b3-4da6-a3ce-929d0e0e4736"
+
+	// a new trace takes the trace ID from the context
+	id, spanID := gen.NewIDs(WithTraceID(context.Background(), traceID))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", id.String())
+	assert.True(t, spanID.IsValid())
+
+	// otherwise, or if it isn't a UUID, it's random
+	for _, ctx := range []context.Context{context.Background(), WithTraceID(context.Background(), "not-a-uuid")} {
+		id, spanID = gen.NewIDs(ctx)
+		assert.True(t, id.IsValid())
+		assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", id.String())
+		assert.True(t, spanID.IsValid())
+	}
+
+	assert.NotEqual(t, gen.NewSpanID(context.Background(), trace.TraceID{}), gen.NewSpanID(context.Background(), trace.TraceID{}))
+}
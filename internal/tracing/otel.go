@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ExporterNone   = "none"   // spans are not recorded, only the trace ID is propagated
+	ExporterStdout = "stdout" // spans are written to stdout as JSON, for local testing
+	ExporterOtlp   = "otlp"   // spans are sent to an OTLP/HTTP collector
+
+	tracerName = "github.com/andrewhollamon/millioncheckboxes-api"
+)
+
+var (
+	propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+	tracer     = otel.Tracer(tracerName)
+)
+
+// InitTracerFromConfig sets up OpenTelemetry for the service, with the exporter picked by the TRACING_EXPORTER config
+// key. The returned function flushes any spans still buffered, and should be called on shutdown.
+func InitTracerFromConfig(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	appconfig := apiconfig.GetConfig()
+	exporterName := apiconfig.GetStringWithDefault("TRACING_EXPORTER", ExporterNone)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case ExporterNone:
+		log.Info().Msg("Tracing exporter is none, spans are not recorded")
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOtlp:
+		// the endpoint and headers can also come from the standard OTEL_EXPORTER_OTLP_* environment variables
+		var opts []otlptracehttp.Option
+		if endpoint := appconfig.GetString("TRACING_OTLP_ENDPOINT"); endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER '%s', expected none, stdout or otlp", exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", exporterName, err)
+	}
+
+	res, err := serviceResource(serviceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	sampleRatio := apiconfig.GetFloat64WithDefault("TRACING_SAMPLE_RATIO", 1.0)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithIDGenerator(newTraceIDGenerator()),
+	)
+	otel.SetTracerProvider(provider)
+
+	log.Info().Msgf("Tracing enabled for %s, exporting to %s, sample ratio %v", serviceName, exporterName, sampleRatio)
+	return provider.Shutdown, nil
+}
+
+// serviceResource describes the service to the exporters
+func serviceResource(serviceName string) (*resource.Resource, error) {
+	return resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+}
+
+// StartSpan starts a span on the service's tracer
+func StartSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, opts...)
+}
+
+// RecordError marks the span as failed with the error, if there is one
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// traceIDGenerator makes the trace ID of a new trace the same as the UUID trace ID already in the context, so the
+// spans and the log lines of a request share one ID. Spans with a parent get the parent's trace ID as usual.
+type traceIDGenerator struct{}
+
+func newTraceIDGenerator() traceIDGenerator {
+	return traceIDGenerator{}
+}
+
+func (g traceIDGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	traceID := trace.TraceID{}
+	if id, err := uuid.Parse(GetTraceIDFromContext(ctx)); err == nil && id != uuid.Nil {
+		traceID = trace.TraceID(id)
+	}
+	for !traceID.IsValid() {
+		_, _ = rand.Read(traceID[:])
+	}
+	return traceID, g.NewSpanID(ctx, traceID)
+}
+
+func (g traceIDGenerator) NewSpanID(_ context.Context, _ trace.TraceID) trace.SpanID {
+	spanID := trace.SpanID{}
+	for !spanID.IsValid() {
+		_, _ = rand.Read(spanID[:])
+	}
+	return spanID
+}
@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TraceparentHeader is the W3C trace context header, https://www.w3.org/TR/trace-context/
+	TraceparentHeader = "traceparent"
+
+	traceparentVersion = "00"
+	traceparentSampled = "01"
+)
+
+// FormatTraceparent builds a W3C traceparent for a trace ID, with a new parent span ID. Trace IDs here are UUIDs, which
+// are the same 16 bytes as a W3C trace-id, so the trace ID survives the round trip through ParseTraceparent. Any other
+// trace ID can't be represented, and gives an empty string.
+func FormatTraceparent(traceID string) string {
+	id, err := uuid.Parse(traceID)
+	if err != nil || id == uuid.Nil {
+		return ""
+	}
+
+	spanID := make([]byte, 8)
+	if _, err := rand.Read(spanID); err != nil {
+		log.Error().Err(err).Msg("failed to generate span id for traceparent")
+		return ""
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s", traceparentVersion, hex.EncodeToString(id[:]), hex.EncodeToString(spanID), traceparentSampled)
+}
+
+// ParseTraceparent returns the trace ID in a W3C traceparent, in UUID form, or false if the traceparent is not valid
+func ParseTraceparent(traceparent string) (string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 {
+		return "", false
+	}
+	version, traceIDHex, spanIDHex, flags := parts[0], parts[1], parts[2], parts[3]
+
+	// version ff is forbidden, and version 00 has exactly four fields, later versions may add more
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" || (version == traceparentVersion && len(parts) != 4) {
+		return "", false
+	}
+	if len(traceIDHex) != 32 || !isLowerHex(traceIDHex) || len(spanIDHex) != 16 || !isLowerHex(spanIDHex) ||
+		len(flags) != 2 || !isLowerHex(flags) {
+		return "", false
+	}
+
+	traceIDBytes, err := hex.DecodeString(traceIDHex)
+	if err != nil {
+		return "", false
+	}
+	id, err := uuid.FromBytes(traceIDBytes)
+	if err != nil || id == uuid.Nil || strings.Trim(spanIDHex, "0") == "" {
+		return "", false
+	}
+
+	return id.String(), true
+}
+
+// TraceparentFromContext returns the traceparent of the span in the context, so whatever receives it continues the
+// trace from that span. Without a span, a traceparent is built from the trace ID in the context, see FormatTraceparent.
+func TraceparentFromContext(ctx context.Context) string {
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		carrier := propagation.MapCarrier{}
+		propagator.Inject(ctx, carrier)
+		return carrier.Get(TraceparentHeader)
+	}
+	return FormatTraceparent(GetTraceIDFromContext(ctx))
+}
+
+// ContextWithTraceparent continues the trace in a traceparent received from elsewhere, setting both the remote span
+// the next span started on the context is a child of, and the trace ID. The context is returned unchanged if the
+// traceparent is not valid.
+func ContextWithTraceparent(ctx context.Context, traceparent string) (context.Context, bool) {
+	traceID, ok := ParseTraceparent(traceparent)
+	if !ok {
+		return ctx, false
+	}
+	ctx = propagator.Extract(ctx, propagation.MapCarrier{TraceparentHeader: traceparent})
+	return WithTraceID(ctx, traceID), true
+}
+
+func isLowerHex(s string) bool {
+	for _, r := range s {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
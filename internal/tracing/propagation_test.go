@@ -0,0 +1,101 @@
+package tracing
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatTraceparent(t *testing.T) {
+	traceID := "4bf92f35-77b3-4da6-a3ce-929d0e0e4736"
+
+	traceparent := FormatTraceparent(traceID)
+	assert.Regexp(t, regexp.MustCompile(`^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`), traceparent)
+
+	parsed, ok := ParseTraceparent(traceparent)
+	assert.True(t, ok)
+	assert.Equal(t, traceID, parsed)
+
+	// each call is a new span of the same trace
+	assert.NotEqual(t, traceparent, FormatTraceparent(traceID))
+
+	assert.Equal(t, "", FormatTraceparent(""))
+	assert.Equal(t, "", FormatTraceparent("not-a-uuid"))
+	assert.Equal(t, "", FormatTraceparent("00000000-0000-0000-0000-000000000000"))
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		traceparent string
+		expectedID  string
+		expectedOk  bool
+	}{
+		{
+			name:        "Valid",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expectedID:  "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+			expectedOk:  true,
+		},
+		{
+			name:        "Not sampled",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			expectedID:  "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+			expectedOk:  true,
+		},
+		{
+			name:        "Later version with extra fields",
+			traceparent: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			expectedID:  "4bf92f35-77b3-4da6-a3ce-929d0e0e4736",
+			expectedOk:  true,
+		},
+		{
+			name:        "Empty",
+			traceparent: "",
+		},
+		{
+			name:        "Version 00 with extra fields",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		},
+		{
+			name:        "Forbidden version",
+			traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "Upper case hex",
+			traceparent: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "Short trace id",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "All zero trace id",
+			traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "All zero span id",
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traceID, ok := ParseTraceparent(tt.traceparent)
+			assert.Equal(t, tt.expectedOk, ok)
+			assert.Equal(t, tt.expectedID, traceID)
+		})
+	}
+}
+
+func TestTraceparentFromContext(t *testing.T) {
+	ctx := WithTraceID(context.Background(), "4bf92f35-77b3-4da6-a3ce-929d0e0e4736")
+
+	traceID, ok := ParseTraceparent(TraceparentFromContext(ctx))
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f35-77b3-4da6-a3ce-929d0e0e4736", traceID)
+
+	assert.Equal(t, "", TraceparentFromContext(context.Background()))
+}
@@ -0,0 +1,110 @@
+package apiserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/api"
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/memorystore"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ChangeFeedPostgres keeps the memory store current from the database's change feed, see
+	// dbservice.ListenCheckboxChanges. The update queue then only carries the request results.
+	ChangeFeedPostgres = "postgres"
+	// ChangeFeedQueue keeps the memory store current from the checkbox_changed events on the update queue
+	ChangeFeedQueue = "queue"
+)
+
+// reconcileMaxBroadcast is the most corrections a reconciliation sends to the websocket clients one by one, beyond
+// which it tells them to resync instead
+const reconcileMaxBroadcast = 1000
+
+// ChangeFeedSource returns which feed keeps the memory store current, from the CHECKBOX_CHANGE_FEED config key. The
+// Postgres feed needs Postgres, so the queue feed is used with any other DATABASE_PROVIDER.
+func ChangeFeedSource() string {
+	if dbservice.DatabaseProvider() != dbservice.ProviderPostgres {
+		return ChangeFeedQueue
+	}
+	if apiconfig.GetStringWithDefault("CHECKBOX_CHANGE_FEED", ChangeFeedPostgres) == ChangeFeedQueue {
+		return ChangeFeedQueue
+	}
+	return ChangeFeedPostgres
+}
+
+// memoryStoreFeed applies the change feed to the memory store, and fans the changes out to the websocket clients
+type memoryStoreFeed struct{}
+
+// RunCheckboxChangeFeed keeps the memory store current from the database change feed until the context is cancelled
+func RunCheckboxChangeFeed(ctx context.Context) {
+	dbservice.ListenCheckboxChanges(ctx, memoryStoreFeed{})
+}
+
+func (memoryStoreFeed) Reload(ctx context.Context, reason string) apierror.APIError {
+	startTime := time.Now()
+	// the feed only carries changes from when it connected, a replica may not have all those before
+	err := memorystore.LoadCheckboxesFromStore(dbservice.ForcePrimary(ctx))
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to reload memory store (%s)", reason)
+		return err
+	}
+	log.Info().Msgf("Memory store reloaded in %v (%s)", time.Since(startTime), reason)
+
+	// the clients may have missed changes too
+	return api.BroadcastResync()
+}
+
+func (memoryStoreFeed) Reconcile(ctx context.Context) apierror.APIError {
+	startTime := time.Now()
+	corrected, err := memorystore.Reconcile(dbservice.ForcePrimary(ctx))
+	if err != nil {
+		log.Error().Err(err).Msg("failed to reconcile memory store")
+		return err
+	}
+	if len(corrected) == 0 {
+		log.Debug().Msgf("Memory store reconciled in %v, nothing to correct", time.Since(startTime))
+		return nil
+	}
+	log.Warn().Msgf("Memory store reconciled in %v, %d checkboxes corrected that the change feed missed", time.Since(startTime), len(corrected))
+
+	if len(corrected) > reconcileMaxBroadcast {
+		return api.BroadcastResync()
+	}
+	for _, checkboxNbr := range corrected {
+		checked, err := memorystore.GetCheckboxStatus(checkboxNbr)
+		if err != nil {
+			return apierror.InternalError(err.Error())
+		}
+		broadcastChange(checkboxNbr, checked, startTime)
+	}
+	return nil
+}
+
+func (memoryStoreFeed) Apply(change dbservice.CheckboxChange) {
+	err := memorystore.DoCheck(change.CheckboxNbr, change.Checked)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to apply change for checkbox %d to memory store", change.CheckboxNbr)
+		return
+	}
+
+	broadcastChange(change.CheckboxNbr, change.Checked, change.ChangedAt)
+}
+
+// broadcastChange sends a change to a checkbox to every websocket client
+func broadcastChange(checkboxNbr int, checked bool, changedAt time.Time) {
+	err := api.BroadcastCheckboxUpdate(queueservice.CheckboxUpdatePayload{
+		EventType:   queueservice.CheckboxUpdateEventChanged,
+		CheckboxNbr: checkboxNbr,
+		Checked:     checked,
+		Result:      queueservice.CheckboxUpdateResultSuccess,
+		EventTime:   changedAt,
+	})
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to broadcast change for checkbox %d", checkboxNbr)
+	}
+}
@@ -0,0 +1,133 @@
+package apiserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/api"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/memorystore"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers"
+	"github.com/rs/zerolog/log"
+)
+
+const consumeCheckboxUpdateErrorSleepDuration = time.Duration(5) * time.Second
+
+// RunCheckboxUpdateConsumer consumes the checkbox update queue of this API server until the context is cancelled.
+// The queue pull is a long poll, so there is no sleep between pulls unless the pull fails.
+func RunCheckboxUpdateConsumer(ctx context.Context) {
+	log.Info().Msg("Checkbox update consumer started")
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Context cancelled, checkbox update consumer shutting down")
+			return
+		default:
+		}
+
+		result := ConsumeCheckboxUpdateQueue(ctx)
+		if result.Result == workers.ResultEnum.Failure {
+			// Context-aware sleep, as a simple backoff when the queue is unavailable
+			timer := time.NewTimer(consumeCheckboxUpdateErrorSleepDuration)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Info().Msg("Context cancelled during sleep, checkbox update consumer shutting down")
+				return
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// ConsumeCheckboxUpdateQueue pulls one batch of checkbox updates, applies them to the memory store, and pushes them
+// to the websocket clients connected to this API server
+func ConsumeCheckboxUpdateQueue(ctx context.Context) workers.QueueConsumerResult {
+	messages, err := queueservice.PullCheckboxUpdateMessages(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to pull messages from checkbox update queue")
+		return workers.QueueConsumerResult{
+			Result:       workers.ResultEnum.Failure,
+			NumProcessed: 0,
+		}
+	}
+
+	result := workers.ResultEnum.Success
+	processed := 0
+
+	// messages are processed in order, since updates to the same checkbox must be applied in the order they happened
+	for _, message := range messages {
+		if processCheckboxUpdateMessage(ctx, message) == workers.ResultEnum.Success {
+			processed++
+		} else {
+			result = workers.ResultEnum.Failure
+		}
+	}
+
+	if len(messages) > 0 {
+		log.Debug().Msgf("Checkbox update queue: processed=%d, failed=%d", processed, len(messages)-processed)
+	}
+
+	return workers.QueueConsumerResult{
+		Result:       result,
+		NumProcessed: processed,
+	}
+}
+
+func processCheckboxUpdateMessage(ctx context.Context, message queueservice.Message) workers.Result {
+	ctx, span := queueservice.StartProcessSpan(ctx, &message, "checkbox_update")
+	defer span.End()
+
+	body := queueservice.CheckboxUpdateMessage{}
+	err := message.UnmarshalBody(&body)
+	if err != nil {
+		// it will never unmarshal, and would hold up the rest of its message group until it went to the DLQ
+		log.Error().Err(err).Str("body", message.Body).Msgf("failed to unmarshal checkbox update messageId %s, deleting it", message.MessageId)
+		return deleteCheckboxUpdateMessage(ctx, &message)
+	}
+	payload := body.Payload
+
+	// the database change feed applies the changes and sends them to the websocket clients itself, so then only the
+	// request results are taken from the queue
+	handledByChangeFeed := payload.EventType == queueservice.CheckboxUpdateEventChanged && ChangeFeedSource() == ChangeFeedPostgres
+	if !handledByChangeFeed {
+		if payload.EventType == queueservice.CheckboxUpdateEventChanged {
+			err := memorystore.DoCheck(payload.CheckboxNbr, payload.Checked)
+			if err != nil {
+				log.Error().Err(err).Msgf("failed to apply checkbox update for checkbox %d to memory store", payload.CheckboxNbr)
+				return workers.ResultEnum.Failure
+			}
+		}
+
+		if apierr := sendCheckboxUpdate(payload); apierr != nil {
+			log.Error().Err(apierr).Msgf("failed to send %s event for checkbox %d", payload.EventType, payload.CheckboxNbr)
+			return workers.ResultEnum.Failure
+		}
+	}
+
+	return deleteCheckboxUpdateMessage(ctx, &message)
+}
+
+// sendCheckboxUpdate sends a request's result only to the user who made it, and a change to every client without the
+// user and request that made it
+func sendCheckboxUpdate(payload queueservice.CheckboxUpdatePayload) apierror.APIError {
+	if payload.EventType == queueservice.CheckboxUpdateEventRequestComplete {
+		return api.SendCheckboxUpdateToUser(payload.UserUuid, payload)
+	}
+
+	payload.UserUuid = ""
+	payload.RequestUuid = ""
+	return api.BroadcastCheckboxUpdate(payload)
+}
+
+func deleteCheckboxUpdateMessage(ctx context.Context, message *queueservice.Message) workers.Result {
+	err := queueservice.DeleteMessage(ctx, message)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to delete messageId %s sequenceNumber %s", message.MessageId, message.SequenceNumber)
+		return workers.ResultEnum.Failure
+	}
+
+	return workers.ResultEnum.Success
+}
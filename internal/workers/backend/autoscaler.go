@@ -0,0 +1,153 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/rs/zerolog/log"
+)
+
+// CloudWatch namespace the scaling signal is published under, for the ECS autoscaling policies in terraform
+const scalingMetricNamespace = "MCB/Backend"
+
+// AutoscalerConfig bounds and tunes how the backend resizes its worker pool
+type AutoscalerConfig struct {
+	MinWorkers             int
+	MaxWorkers             int
+	Interval               time.Duration
+	TargetBacklogPerWorker int           // queued messages each worker is expected to keep up with
+	MaxLatency             time.Duration // above this recent processing time, the pool stops growing
+	MaxDbPoolSaturation    float64       // above this share of DB connections in use, the pool stops growing
+}
+
+// LoadAutoscalerConfig reads the autoscaler settings from config, with defaults for anything not set
+func LoadAutoscalerConfig() AutoscalerConfig {
+	cfg := AutoscalerConfig{
+		MinWorkers:             max(apiconfig.GetIntWithDefault("BACKEND_WORKERS_MIN", 2), 1),
+		MaxWorkers:             apiconfig.GetIntWithDefault("BACKEND_WORKERS_MAX", 32),
+		Interval:               apiconfig.GetDurationWithDefault("BACKEND_AUTOSCALE_INTERVAL", 15*time.Second),
+		TargetBacklogPerWorker: max(apiconfig.GetIntWithDefault("BACKEND_AUTOSCALE_TARGET_BACKLOG_PER_WORKER", 10), 1),
+		MaxLatency:             apiconfig.GetDurationWithDefault("BACKEND_AUTOSCALE_MAX_LATENCY", 2*time.Second),
+		MaxDbPoolSaturation:    apiconfig.GetFloat64WithDefault("BACKEND_AUTOSCALE_MAX_DB_POOL_SATURATION", 0.9),
+	}
+	cfg.MaxWorkers = max(cfg.MaxWorkers, cfg.MinWorkers)
+	return cfg
+}
+
+// ScalingSignal is one sample of the load on the backend, and the number of workers it calls for
+type ScalingSignal struct {
+	QueueDepth       int64
+	RecentLatency    time.Duration
+	DbPoolSaturation float64 // share of the DB pool connections in use, 0 to 1
+	Workers          int
+	DesiredWorkers   int
+}
+
+// WorkerUtilization is the desired number of workers as a percentage of the most this backend may run. Above 100%
+// this backend can't keep up on its own, which is what the ECS autoscaling policy tracks to add or remove tasks.
+func (s ScalingSignal) WorkerUtilization(cfg AutoscalerConfig) float64 {
+	return 100 * float64(s.DesiredWorkers) / float64(cfg.MaxWorkers)
+}
+
+// desiredWorkers works out the pool size for a sample. The pool is sized to the backlog, but doesn't grow while the
+// database is the bottleneck, since more workers would only make that worse. It shrinks a quarter at a time so a
+// momentary dip in the backlog doesn't throw away all the capacity.
+func desiredWorkers(cfg AutoscalerConfig, signal ScalingSignal) int {
+	desired := int(math.Ceil(float64(signal.QueueDepth) / float64(cfg.TargetBacklogPerWorker)))
+
+	dbBound := signal.DbPoolSaturation >= cfg.MaxDbPoolSaturation ||
+		(cfg.MaxLatency > 0 && signal.RecentLatency > cfg.MaxLatency)
+	if dbBound && desired > signal.Workers {
+		desired = signal.Workers
+	}
+
+	if desired < signal.Workers {
+		maxStep := max(signal.Workers/4, 1)
+		desired = max(desired, signal.Workers-maxStep)
+	}
+
+	return min(max(desired, cfg.MinWorkers), cfg.MaxWorkers)
+}
+
+// Autoscaler periodically samples the queue, worker pool and database pool, and resizes the worker pool to match
+type Autoscaler struct {
+	pool *WorkerPool
+	cfg  AutoscalerConfig
+}
+
+func NewAutoscaler(pool *WorkerPool, cfg AutoscalerConfig) *Autoscaler {
+	return &Autoscaler{
+		pool: pool,
+		cfg:  cfg,
+	}
+}
+
+func (a *Autoscaler) run(ctx context.Context) {
+	log.Info().Msgf("Worker pool autoscaler started, min=%d, max=%d, interval=%v", a.cfg.MinWorkers, a.cfg.MaxWorkers, a.cfg.Interval)
+
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Context cancelled, worker pool autoscaler shutting down")
+			return
+
+		case <-ticker.C:
+			signal, ok := a.sample(ctx)
+			if !ok {
+				continue
+			}
+			publishScalingSignal(a.cfg, signal)
+			if signal.DesiredWorkers != signal.Workers {
+				a.pool.resize(signal.DesiredWorkers)
+			}
+		}
+	}
+}
+
+func (a *Autoscaler) sample(ctx context.Context) (ScalingSignal, bool) {
+	depth, err := queueservice.GetCheckboxActionQueueDepth(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get checkbox action queue depth, skipping autoscale")
+		return ScalingSignal{}, false
+	}
+
+	signal := ScalingSignal{
+		QueueDepth:    depth,
+		RecentLatency: a.pool.stats.recentAvgTime(),
+		Workers:       a.pool.size(),
+	}
+	if poolStats := dbservice.GetPoolStats(); poolStats != nil && poolStats.MaxConns() > 0 {
+		signal.DbPoolSaturation = float64(poolStats.AcquiredConns()) / float64(poolStats.MaxConns())
+	}
+	signal.DesiredWorkers = desiredWorkers(a.cfg, signal)
+
+	return signal, true
+}
+
+// publishScalingSignal logs the signal in CloudWatch Embedded Metric Format. On ECS the awslogs driver ships the line
+// to CloudWatch Logs, which turns it into metrics without any extra API calls.
+func publishScalingSignal(cfg AutoscalerConfig, signal ScalingSignal) {
+	emf := fmt.Sprintf(`{"Timestamp":%d,"CloudWatchMetrics":[{"Namespace":"%s","Dimensions":[[]],"Metrics":[`+
+		`{"Name":"QueueDepth","Unit":"Count"},{"Name":"RecentLatency","Unit":"Milliseconds"},`+
+		`{"Name":"DbPoolSaturation","Unit":"Percent"},{"Name":"Workers","Unit":"Count"},`+
+		`{"Name":"DesiredWorkers","Unit":"Count"},{"Name":"WorkerUtilization","Unit":"Percent"}]}]}`,
+		time.Now().UnixMilli(), scalingMetricNamespace)
+
+	log.Info().
+		RawJSON("_aws", []byte(emf)).
+		Int64("QueueDepth", signal.QueueDepth).
+		Int64("RecentLatency", signal.RecentLatency.Milliseconds()).
+		Float64("DbPoolSaturation", 100*signal.DbPoolSaturation).
+		Int("Workers", signal.Workers).
+		Int("DesiredWorkers", signal.DesiredWorkers).
+		Float64("WorkerUtilization", signal.WorkerUtilization(cfg)).
+		Msg("Worker pool scaling signal")
+}
@@ -0,0 +1,90 @@
+package backend
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDesiredWorkers(t *testing.T) {
+	cfg := AutoscalerConfig{
+		MinWorkers:             2,
+		MaxWorkers:             32,
+		TargetBacklogPerWorker: 10,
+		MaxLatency:             2 * time.Second,
+		MaxDbPoolSaturation:    0.9,
+	}
+
+	tests := []struct {
+		name     string
+		signal   ScalingSignal
+		expected int
+	}{
+		{
+			name:     "Empty queue stays at minimum",
+			signal:   ScalingSignal{QueueDepth: 0, Workers: 2},
+			expected: 2,
+		},
+		{
+			name:     "Backlog grows the pool",
+			signal:   ScalingSignal{QueueDepth: 95, Workers: 4},
+			expected: 10,
+		},
+		{
+			name:     "Growth is capped at maximum",
+			signal:   ScalingSignal{QueueDepth: 10000, Workers: 16},
+			expected: 32,
+		},
+		{
+			name:     "Saturated DB pool holds the pool size",
+			signal:   ScalingSignal{QueueDepth: 200, Workers: 8, DbPoolSaturation: 0.95},
+			expected: 8,
+		},
+		{
+			name:     "High latency holds the pool size",
+			signal:   ScalingSignal{QueueDepth: 200, Workers: 8, RecentLatency: 3 * time.Second},
+			expected: 8,
+		},
+		{
+			name:     "Saturated DB pool still allows shrinking",
+			signal:   ScalingSignal{QueueDepth: 0, Workers: 8, DbPoolSaturation: 0.95},
+			expected: 6,
+		},
+		{
+			name:     "Shrinks by at most a quarter",
+			signal:   ScalingSignal{QueueDepth: 0, Workers: 20},
+			expected: 15,
+		},
+		{
+			name:     "Shrinks by at least one",
+			signal:   ScalingSignal{QueueDepth: 0, Workers: 3},
+			expected: 2,
+		},
+		{
+			name:     "Shrinking doesn't go below minimum",
+			signal:   ScalingSignal{QueueDepth: 0, Workers: 2},
+			expected: 2,
+		},
+		{
+			name:     "Shrinks straight to the backlog when within a quarter",
+			signal:   ScalingSignal{QueueDepth: 70, Workers: 8},
+			expected: 7,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, desiredWorkers(cfg, tt.signal))
+		})
+	}
+}
+
+func TestSelectWorkerByGroup(t *testing.T) {
+	first := selectWorkerByGroup(queueservice.Message{GroupId: "checkbox-42", MessageId: "a"}, 8)
+	second := selectWorkerByGroup(queueservice.Message{GroupId: "checkbox-42", MessageId: "b"}, 8)
+	assert.Equal(t, first, second)
+	assert.GreaterOrEqual(t, first, 0)
+	assert.Less(t, first, 8)
+}
@@ -2,7 +2,12 @@ package backend
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
 	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers"
 	"github.com/google/uuid"
@@ -11,12 +16,48 @@ import (
 	"time"
 )
 
-func ConsumeCheckboxActionQueue(ctx context.Context) workers.QueueConsumerResult {
+// CheckboxActionOutcome is the result of processing a single checkbox action message. Result says whether the message
+// was fully processed and removed from the queue, Err holds the reason when it wasn't, ErrDuplicateRecord when the
+// message was a redelivery of a request that had already been applied, ErrRecordNotFound when its checkbox doesn't
+// exist, or the validation error when the message couldn't be parsed.
+type CheckboxActionOutcome struct {
+	MessageId string
+	Result    workers.Result
+	Decision  conflictpolicy.Decision
+	Err       apierror.APIError
+}
+
+func (o CheckboxActionOutcome) isDuplicate() bool {
+	return apierror.IsErrorType(o.Err, apierror.ErrDuplicateRecord)
+}
+
+// isRejected reports whether the message was invalid, or for a checkbox that doesn't exist, which no redelivery can
+// apply
+func (o CheckboxActionOutcome) isRejected() bool {
+	return isPermanentFailure(o.Err)
+}
+
+// retryNow reports whether a failed message lost a serialization conflict or deadlock to another transaction, and is
+// expected to apply if it is tried again straight away
+func (o CheckboxActionOutcome) retryNow() bool {
+	return apierror.IsErrorType(o.Err, apierror.ErrDatabaseConflict)
+}
+
+// ConsumeCheckboxActionQueue pulls one batch of checkbox action messages on pullCtx, and applies them on ctx. Shutdown
+// cancels pullCtx first, so the batch already pulled can finish before ctx is cancelled at the drain deadline.
+func ConsumeCheckboxActionQueue(pullCtx context.Context, ctx context.Context) workers.QueueConsumerResult {
 	startTime := time.Now()
 	initialGoroutines := runtime.NumGoroutine()
 
-	messages, err := queueservice.PullCheckboxActionMessages(ctx)
+	messages, err := queueservice.PullCheckboxActionMessages(pullCtx)
 	if err != nil {
+		if pullCtx.Err() != nil {
+			// shutting down while waiting on the queue, so nothing was in flight
+			return workers.QueueConsumerResult{
+				Result:       workers.ResultEnum.Success,
+				NumProcessed: 0,
+			}
+		}
 		log.Error().Err(err).Msg("failed to pull messages from checkbox action queue")
 		return workers.QueueConsumerResult{
 			Result:       workers.ResultEnum.Failure,
@@ -43,11 +84,69 @@ func ConsumeCheckboxActionQueue(ctx context.Context) workers.QueueConsumerResult
 		}
 	}
 
+	var outcomes []CheckboxActionOutcome
+	if apiconfig.GetConfig().GetString("BACKEND_APPLY_MODE") == ApplyModeBatch {
+		outcomes = applyCheckboxActionBatch(ctx, messages)
+	} else {
+		outcomes = applyCheckboxActionsConcurrently(ctx, messages)
+	}
+
+	// process all the message results
+	shuttingDown := pullCtx.Err() != nil
+	drain := DrainResult{}
 	result := workers.ResultEnum.Success
 	processed := 0
+	duplicates := 0
+	rejected := 0
 	failed := 0
+	for _, outcome := range outcomes {
+		if outcome.Result == workers.ResultEnum.Success {
+			processed++
+			if outcome.isDuplicate() {
+				duplicates++
+			} else if outcome.isRejected() {
+				rejected++
+			}
+			drain.Completed++
+		} else {
+			failed++
+			result = workers.ResultEnum.Failure
+
+			// normally a failed message waits out its visibility timeout as a backoff, but during shutdown it
+			// should go straight to a backend that is still running, and a conflict can be retried straight away
+			if shuttingDown {
+				if releaseMessage(findMessage(messages, outcome.MessageId)) {
+					drain.Released++
+				} else {
+					drain.ReleaseFailed++
+				}
+			} else if outcome.retryNow() {
+				releaseMessage(findMessage(messages, outcome.MessageId))
+			}
+		}
+	}
+
+	// Log metrics
+	processingTime := time.Since(startTime)
+	finalGoroutines := runtime.NumGoroutine()
+	goroutinesDelta := finalGoroutines - initialGoroutines
+
+	log.Info().Msgf("Queue processing metrics: processed=%d, duplicates=%d, rejected=%d, failed=%d, duration=%v, goroutines_start=%d, goroutines_end=%d, goroutines_delta=%d",
+		processed, duplicates, rejected, failed, processingTime, initialGoroutines, finalGoroutines, goroutinesDelta)
+	if shuttingDown {
+		drain.log(processingTime)
+	}
+
+	return workers.QueueConsumerResult{
+		Result:       result,
+		NumProcessed: processed,
+	}
+}
+
+// applyCheckboxActionsConcurrently applies each message in its own database transaction, on its own goroutine
+func applyCheckboxActionsConcurrently(ctx context.Context, messages []queueservice.Message) []CheckboxActionOutcome {
 	messageCount := len(messages)
-	c := make(chan workers.Result, messageCount)
+	c := make(chan CheckboxActionOutcome, messageCount)
 	defer close(c)
 
 	// kick off each received queue message on separate goroutine, since they're largely io bound
@@ -58,85 +157,159 @@ func ConsumeCheckboxActionQueue(ctx context.Context) workers.QueueConsumerResult
 			defer func() {
 				if r := recover(); r != nil {
 					log.Error().Msgf("panic in processCheckboxActionMessage: %v", r)
-					c <- workers.ResultEnum.Failure
+					c <- failedOutcome(msg, apierror.InternalError(fmt.Sprintf("panic in processCheckboxActionMessage: %v", r)))
 				}
 			}()
 			processCheckboxActionMessage(ctx, msg, c)
 		}(message)
 	}
 
-	// process all the message results
+	outcomes := make([]CheckboxActionOutcome, 0, messageCount)
 	for range messageCount {
-		innerresult := <-c
-		if innerresult == workers.ResultEnum.Success {
-			processed++
-		} else {
-			failed++
-			result = workers.ResultEnum.Failure
-		}
+		outcomes = append(outcomes, <-c)
 	}
+	return outcomes
+}
 
-	// Log metrics
-	processingTime := time.Since(startTime)
-	finalGoroutines := runtime.NumGoroutine()
-	goroutinesDelta := finalGoroutines - initialGoroutines
-
-	log.Info().Msgf("Queue processing metrics: processed=%d, failed=%d, duration=%v, goroutines_start=%d, goroutines_end=%d, goroutines_delta=%d",
-		processed, failed, processingTime, initialGoroutines, finalGoroutines, goroutinesDelta)
+// processCheckboxActionMessage applies a single message in its own transaction, see processCheckboxActionWorkerMessage
+// for the worker pool version
+func processCheckboxActionMessage(ctx context.Context, message queueservice.Message, c chan CheckboxActionOutcome) {
+	// carry on the trace of the HTTP request that published the message
+	ctx, span := queueservice.StartProcessSpan(ctx, &message, "checkbox_action")
+	defer span.End()
 
-	return workers.QueueConsumerResult{
-		Result:       result,
-		NumProcessed: processed,
+	request, err := parseCheckboxActionMessage(ctx, message)
+	if err != nil {
+		c <- dropInvalidMessage(ctx, message, err)
+		return
 	}
+
+	// attempt to update the DB, resolving any conflict with the active policy
+	decision, err := dbservice.GetCheckboxRepository().UpdateCheckbox(ctx, conflictpolicy.GetPolicy(), request)
+	c <- completeCheckboxAction(ctx, message, request, decision, err)
 }
 
-// TODO update to match WorkerProcessFunc signature in workerpool.go
-func processCheckboxActionMessage(ctx context.Context, message queueservice.Message, c chan workers.Result) {
+// parseCheckboxActionMessage unpacks a checkbox action message into the request the conflict policy resolves. A
+// message that can't be parsed fails with a validation error, which isPermanentFailure treats as permanent.
+func parseCheckboxActionMessage(ctx context.Context, message queueservice.Message) (conflictpolicy.CheckboxRequest, apierror.APIError) {
 	// get the Body
 	body := queueservice.CheckboxActionMessage{}
-	err := message.UnmarshalBody(&body)
-	if err != nil {
-		log.Error().Err(err).Msg("failed to unmarshal message body")
-		c <- workers.ResultEnum.Failure
-		return
+	baseerr := json.Unmarshal([]byte(message.Body), &body)
+	if baseerr != nil {
+		log.Ctx(ctx).Warn().Err(baseerr).Msgf("failed to unmarshal body of messageId %s", message.MessageId)
+		return conflictpolicy.CheckboxRequest{}, apierror.WrapWithCodeFromConstants(baseerr, apierror.ErrValidationFailed, "failed to unmarshal message body")
 	}
 
 	// unpack everything
 	payload := body.Payload
+	if payload.Action != queueservice.CheckboxActionChecked && payload.Action != queueservice.CheckboxActionUnchecked {
+		log.Ctx(ctx).Warn().Msgf("unknown action '%s' in messageId %s", payload.Action, message.MessageId)
+		return conflictpolicy.CheckboxRequest{}, apierror.NewAPIErrorFromCode(apierror.ErrValidationFailed, fmt.Sprintf("unknown action '%s'", payload.Action))
+	}
+	if payload.CheckboxNbr < 0 {
+		log.Ctx(ctx).Warn().Msgf("invalid checkbox number %d in messageId %s", payload.CheckboxNbr, message.MessageId)
+		return conflictpolicy.CheckboxRequest{}, apierror.NewAPIErrorFromCode(apierror.ErrInvalidCheckboxNumber, fmt.Sprintf("invalid checkbox number %d", payload.CheckboxNbr))
+	}
 	userUuid, baseerr := uuid.Parse(payload.UserUuid)
 	if baseerr != nil {
-		log.Error().Err(baseerr).Msgf("failed to parse user uuid '%s'", payload.UserUuid)
-		c <- workers.ResultEnum.Failure
-		return
+		log.Ctx(ctx).Warn().Err(baseerr).Msgf("failed to parse user uuid '%v' in messageId %s", dbservice.RedactUser(payload.UserUuid), message.MessageId)
+		return conflictpolicy.CheckboxRequest{}, apierror.WrapWithCodeFromConstants(baseerr, apierror.ErrInvalidUUID, "failed to parse user uuid")
 	}
 	requestUuid, baseerr := uuid.Parse(payload.RequestUuid)
 	if baseerr != nil {
-		log.Error().Err(baseerr).Msgf("failed to parse request uuid '%s'", payload.RequestUuid)
-		c <- workers.ResultEnum.Failure
-		return
+		log.Ctx(ctx).Warn().Err(baseerr).Msgf("failed to parse request uuid '%s' in messageId %s", payload.RequestUuid, message.MessageId)
+		return conflictpolicy.CheckboxRequest{}, apierror.WrapWithCodeFromConstants(baseerr, apierror.ErrInvalidUUID, "failed to parse request uuid")
 	}
 
-	// attempt to update the DB
-	err = dbservice.UpdateCheckbox(
-		ctx,
-		payload.CheckboxNbr,
-		payload.Action == queueservice.CheckboxActionChecked,
-		userUuid,
-		requestUuid)
-	if err != nil {
-		log.Error().Err(err).Msgf("failed to update checkbox %d for requestUuid %v", payload.CheckboxNbr, requestUuid)
-		c <- workers.ResultEnum.Failure
-		return
+	return conflictpolicy.CheckboxRequest{
+		CheckboxNbr: payload.CheckboxNbr,
+		Checked:     payload.Action == queueservice.CheckboxActionChecked,
+		UserUuid:    userUuid,
+		RequestUuid: requestUuid,
+		RequestTime: payload.RequestTime,
+	}, nil
+}
+
+// dropInvalidMessage removes a message that couldn't be parsed from the queue. It would fail the same way on every
+// delivery, and until then hold up the rest of its message group.
+func dropInvalidMessage(ctx context.Context, message queueservice.Message, err apierror.APIError) CheckboxActionOutcome {
+	log.Ctx(ctx).Warn().Err(err).Msgf("dropping invalid messageId %s", message.MessageId)
+
+	deleteErr := queueservice.DeleteMessage(ctx, &message)
+	if deleteErr != nil {
+		log.Ctx(ctx).Error().Err(deleteErr).Msgf("failed to delete messageId %s sequenceNumber %s", message.MessageId, message.SequenceNumber)
+		return failedOutcome(message, deleteErr)
 	}
 
-	// remove it from the queue
-	err = queueservice.DeleteMessage(ctx, &message)
-	if err != nil {
-		log.Error().Err(err).Msgf("failed to delete messageId %s sequenceNumber %s", message.MessageId, message.SequenceNumber)
-		c <- workers.ResultEnum.Failure
-		return
+	return CheckboxActionOutcome{
+		MessageId: message.MessageId,
+		Result:    workers.ResultEnum.Success,
+		Err:       err,
+	}
+}
+
+// completeCheckboxAction logs the result of applying a request to the DB, and removes the message from the queue
+// if the request was applied, was a duplicate, or can never be applied
+func completeCheckboxAction(ctx context.Context, message queueservice.Message, request conflictpolicy.CheckboxRequest,
+	decision conflictpolicy.Decision, err apierror.APIError) CheckboxActionOutcome {
+	duplicate := apierror.IsErrorType(err, apierror.ErrDuplicateRecord)
+	rejected := isPermanentFailure(err)
+	if err != nil && !duplicate && !rejected {
+		log.Ctx(ctx).Error().Err(err).Msgf("failed to update checkbox %d for requestUuid %v", request.CheckboxNbr, request.RequestUuid)
+		return failedOutcome(message, err)
+	}
+
+	// a request the policy did not apply is still fully processed, it just had no effect on the shared state
+	if duplicate {
+		log.Ctx(ctx).Info().Msgf("checkbox %d request %v was already processed, skipping redelivered messageId %s", request.CheckboxNbr, request.RequestUuid, message.MessageId)
+	} else if rejected {
+		log.Ctx(ctx).Warn().Err(err).Msgf("checkbox %d does not exist, dropping requestUuid %v messageId %s", request.CheckboxNbr, request.RequestUuid, message.MessageId)
+	} else if decision.Apply {
+		log.Ctx(ctx).Info().Msgf("checkbox %d set to checked=%t for requestUuid %v: SUCCESS", request.CheckboxNbr, decision.Checked, request.RequestUuid)
+	} else {
+		log.Ctx(ctx).Info().Msgf("checkbox %d not changed for requestUuid %v: FAILED (%s)", request.CheckboxNbr, request.RequestUuid, decision.Reason)
 	}
 
-	c <- workers.ResultEnum.Success
-	return
+	// remove it from the queue, including duplicates and rejected requests, which have nothing left to do
+	deleteErr := queueservice.DeleteMessage(ctx, &message)
+	if deleteErr != nil {
+		log.Ctx(ctx).Error().Err(deleteErr).Msgf("failed to delete messageId %s sequenceNumber %s", message.MessageId, message.SequenceNumber)
+		return failedOutcome(message, deleteErr)
+	}
+
+	return CheckboxActionOutcome{
+		MessageId: message.MessageId,
+		Result:    workers.ResultEnum.Success,
+		Decision:  decision,
+		Err:       err,
+	}
+}
+
+// findMessage returns the message an outcome is for, since the concurrent outcomes arrive in any order
+func findMessage(messages []queueservice.Message, messageId string) queueservice.Message {
+	for _, message := range messages {
+		if message.MessageId == messageId {
+			return message
+		}
+	}
+	return queueservice.Message{MessageId: messageId}
+}
+
+// isPermanentFailure reports whether a request failed in a way that will fail the same way however often it is
+// redelivered, so its message should be deleted rather than left to block its message group
+func isPermanentFailure(err apierror.APIError) bool {
+	for _, code := range []string{apierror.ErrRecordNotFound, apierror.ErrValidationFailed, apierror.ErrInvalidUUID, apierror.ErrInvalidCheckboxNumber} {
+		if apierror.IsErrorType(err, code) {
+			return true
+		}
+	}
+	return false
+}
+
+func failedOutcome(message queueservice.Message, err apierror.APIError) CheckboxActionOutcome {
+	return CheckboxActionOutcome{
+		MessageId: message.MessageId,
+		Result:    workers.ResultEnum.Failure,
+		Err:       err,
+	}
 }
@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCheckboxActionMessage(t *testing.T) {
+	const (
+		user    = "550e8400-e29b-41d4-a716-446655440000"
+		request = "550e8400-e29b-41d4-a716-446655440001"
+	)
+	body := func(action string, checkboxNbr int, userUuid string, requestUuid string) string {
+		encoded, err := json.Marshal(queueservice.CheckboxActionMessage{Payload: queueservice.CheckboxActionPayload{
+			Action:      action,
+			CheckboxNbr: checkboxNbr,
+			UserUuid:    userUuid,
+			RequestUuid: requestUuid,
+		}})
+		require.NoError(t, err)
+		return string(encoded)
+	}
+
+	parsed, apierr := parseCheckboxActionMessage(context.Background(), queueservice.Message{Body: body(queueservice.CheckboxActionChecked, 42, user, request)})
+	require.Nil(t, apierr)
+	assert.Equal(t, 42, parsed.CheckboxNbr)
+	assert.True(t, parsed.Checked)
+	assert.Equal(t, user, parsed.UserUuid.String())
+	assert.Equal(t, request, parsed.RequestUuid.String())
+
+	// none of these can ever be applied, so they are dropped rather than redelivered
+	for name, invalid := range map[string]string{
+		"json":         "not json",
+		"action":       body("flipped", 42, user, request),
+		"checkbox":     body(queueservice.CheckboxActionChecked, -1, user, request),
+		"user uuid":    body(queueservice.CheckboxActionChecked, 42, "nope", request),
+		"request uuid": body(queueservice.CheckboxActionChecked, 42, user, "nope"),
+	} {
+		_, apierr := parseCheckboxActionMessage(context.Background(), queueservice.Message{Body: invalid})
+		require.NotNil(t, apierr, name)
+		assert.True(t, isPermanentFailure(apierr), name)
+	}
+
+	assert.True(t, isPermanentFailure(apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")))
+	assert.False(t, isPermanentFailure(apierror.NewAPIErrorFromCode(apierror.ErrDatabaseConflict, "conflict")))
+	assert.False(t, isPermanentFailure(nil))
+}
@@ -0,0 +1,52 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/rs/zerolog/log"
+)
+
+// Values for the BACKEND_APPLY_MODE config key
+const (
+	ApplyModeSingle = "single" // one transaction per message, applied concurrently (default)
+	ApplyModeBatch  = "batch"  // one transaction for all the messages of a pull
+)
+
+// applyCheckboxActionBatch applies all the messages of a pull in one database transaction. Each message still gets its
+// own outcome, and only the messages that were applied, were duplicates, were invalid or were for a checkbox that
+// doesn't exist are removed from the queue. If the transaction fails, every message fails and is left on the queue to be redelivered.
+func applyCheckboxActionBatch(ctx context.Context, messages []queueservice.Message) []CheckboxActionOutcome {
+	outcomes := make([]CheckboxActionOutcome, 0, len(messages))
+
+	// messages that can't be parsed are dropped on their own, the rest go into the batch
+	batchMessages := make([]queueservice.Message, 0, len(messages))
+	requests := make([]conflictpolicy.CheckboxRequest, 0, len(messages))
+	for _, message := range messages {
+		request, err := parseCheckboxActionMessage(message.TraceContext(ctx), message)
+		if err != nil {
+			outcomes = append(outcomes, dropInvalidMessage(message.TraceContext(ctx), message, err))
+			continue
+		}
+		batchMessages = append(batchMessages, message)
+		requests = append(requests, request)
+	}
+
+	results, err := dbservice.GetCheckboxRepository().UpdateCheckboxes(ctx, conflictpolicy.GetPolicy(), requests)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to apply batch of %d checkbox actions", len(requests))
+		for _, message := range batchMessages {
+			outcomes = append(outcomes, failedOutcome(message, err))
+		}
+		return outcomes
+	}
+
+	for i, message := range batchMessages {
+		// the batch spans many traces, but each message's outcome is logged under its own
+		outcomes = append(outcomes, completeCheckboxAction(message.TraceContext(ctx), message, requests[i], results[i].Decision, results[i].Err))
+	}
+
+	return outcomes
+}
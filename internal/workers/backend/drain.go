@@ -0,0 +1,47 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultDrainTimeout   = 20 * time.Second // comfortably inside the 30 second ECS stop timeout
+	releaseMessageTimeout = 5 * time.Second
+)
+
+// DrainResult counts what happened to the messages that were in flight when the backend started shutting down
+type DrainResult struct {
+	Completed     int // applied, or found to be duplicates or rejected, and deleted from the queue
+	Released      int // not applied, and made visible on the queue again for another backend to pick up
+	ReleaseFailed int // not applied, and left invisible on the queue until their visibility timeout expires
+}
+
+// DrainTimeout is how long in-flight messages get to finish once shutdown starts, before their work is cancelled
+func DrainTimeout() time.Duration {
+	return apiconfig.GetDurationWithDefault("BACKEND_SHUTDOWN_DRAIN_TIMEOUT", defaultDrainTimeout)
+}
+
+func (r DrainResult) log(duration time.Duration) {
+	log.Info().Msgf("Drain results: completed=%d, released=%d, release_failed=%d, duration=%v",
+		r.Completed, r.Released, r.ReleaseFailed, duration)
+}
+
+// releaseMessage makes a message that wasn't applied visible on the queue again straight away, so another backend
+// doesn't have to wait out the visibility timeout. It has its own context, since the work context has usually been
+// cancelled by the time this is needed.
+func releaseMessage(message queueservice.Message) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseMessageTimeout)
+	defer cancel()
+
+	err := queueservice.ReleaseMessage(ctx, &message)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to release messageId %s, it will be redelivered after its visibility timeout", message.MessageId)
+		return false
+	}
+	return true
+}
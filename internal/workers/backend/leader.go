@@ -0,0 +1,24 @@
+package backend
+
+import (
+	"context"
+	"sync"
+)
+
+// RunLeaderDuties runs the work only one backend should be doing at a time, until the context is cancelled because
+// this backend is no longer the leader: publishing the outbox, and the scheduled maintenance jobs
+func RunLeaderDuties(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		RunOutboxRelay(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		RunScheduler(ctx)
+	}()
+
+	wg.Wait()
+}
@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/scheduler"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultPurgeBatchSize = 10000
+
+// purgeFunc deletes rows older than the cutoff, batchSize rows at a time
+type purgeFunc func(ctx context.Context, cutoff time.Time, batchSize int) (int64, apierror.APIError)
+
+// purgeJob defines a job that purges rows older than its retention. The retention and batch size can be overridden
+// with the JOB_<NAME>_RETENTION and JOB_<NAME>_BATCHSIZE config keys.
+func purgeJob(name string, schedule string, defaultRetention time.Duration, purge purgeFunc) scheduler.JobDefinition {
+	return scheduler.JobDefinition{
+		Name:            name,
+		DefaultSchedule: schedule,
+		DefaultTimeout:  30 * time.Minute,
+		Run: func(ctx context.Context) (int64, apierror.APIError) {
+			retention := apiconfig.GetDurationWithDefault(scheduler.ConfigKey(name, "RETENTION"), defaultRetention)
+			batchSize := max(apiconfig.GetIntWithDefault(scheduler.ConfigKey(name, "BATCHSIZE"), defaultPurgeBatchSize), 1)
+			return purge(ctx, time.Now().Add(-retention), batchSize)
+		},
+	}
+}
+
+// maintenanceJobs are the jobs from the maintenance section of docs/scaling.md, plus purges of the bookkeeping tables
+// the backend itself writes, the metrics for the stats API, and the board snapshots the API servers load from
+func maintenanceJobs() []scheduler.JobDefinition {
+	return []scheduler.JobDefinition{
+		purgeJob("purge_updates", "*/15 * * * *", 24*time.Hour, dbservice.PurgeUpdates),
+		purgeJob("purge_clients", "30 * * * *", 24*time.Hour, dbservice.PurgeInactiveClients),
+		// must be kept for longer than the queue retention, so a redelivery is always recognised
+		purgeJob("purge_processed_requests", "45 * * * *", 48*time.Hour, dbservice.PurgeProcessedRequests),
+		purgeJob("purge_outbox", "*/10 * * * *", time.Hour, dbservice.PurgeSentOutboxEvents),
+		purgeJob("purge_job_runs", "@daily", 30*24*time.Hour, dbservice.PurgeJobRuns),
+		// the newest snapshot is always kept
+		purgeJob("purge_snapshots", "55 * * * *", 24*time.Hour, dbservice.PurgeCheckboxSnapshots),
+		{
+			Name:            "compute_metrics",
+			DefaultSchedule: "*/5 * * * *",
+			DefaultTimeout:  2 * time.Minute,
+			Run:             computeMetrics,
+		},
+		{
+			Name:            "checkbox_snapshot",
+			DefaultSchedule: "*/15 * * * *",
+			DefaultTimeout:  5 * time.Minute,
+			Run: func(ctx context.Context) (int64, apierror.APIError) {
+				_, err := dbservice.WriteCheckboxSnapshot(ctx)
+				if err != nil {
+					return 0, err
+				}
+				return 1, nil
+			},
+		},
+		{
+			Name:            "queue_retention",
+			DefaultSchedule: "@hourly",
+			DefaultTimeout:  time.Minute,
+			Run: func(ctx context.Context) (int64, apierror.APIError) {
+				retention := apiconfig.GetDurationWithDefault(scheduler.ConfigKey("queue_retention", "RETENTION"), 24*time.Hour)
+				return queueservice.EnforceQueueRetention(ctx, retention)
+			},
+		},
+	}
+}
+
+// computeMetrics appends the metrics for the interval since the last recorded one to METRICS_T. If the last interval
+// is missing or too old, for instance the first time the job runs, it covers just the usual interval instead.
+func computeMetrics(ctx context.Context) (int64, apierror.APIError) {
+	interval := apiconfig.GetDurationWithDefault(scheduler.ConfigKey("compute_metrics", "INTERVAL"), 5*time.Minute)
+	maxInterval := apiconfig.GetDurationWithDefault(scheduler.ConfigKey("compute_metrics", "MAX_INTERVAL"), time.Hour)
+
+	end := time.Now().Truncate(time.Minute)
+	start := end.Add(-interval)
+	lastEnd, found, err := dbservice.GetLastMetricsIntervalEnd(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if found && end.Sub(lastEnd) <= maxInterval {
+		start = lastEnd
+	}
+	if !start.Before(end) {
+		return 0, nil
+	}
+
+	queueDepth, err := queueservice.GetCheckboxActionQueueDepth(ctx)
+	if err != nil {
+		// still worth recording the rest of the metrics
+		log.Warn().Err(err).Msg("failed to get checkbox action queue depth for metrics, recording it as -1")
+		queueDepth = -1
+	}
+
+	_, inserted, err := dbservice.InsertMetrics(ctx, start, end, queueDepth)
+	if err != nil || !inserted {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// RunScheduler runs the maintenance jobs on their schedules until the context is cancelled. Only the leader runs the
+// scheduler, and the job leases still make sure a run isn't repeated when the leadership moves mid-run.
+func RunScheduler(ctx context.Context) {
+	if !apiconfig.GetBoolWithDefault("SCHEDULER_ENABLED", true) {
+		log.Info().Msg("Scheduler is disabled")
+		return
+	}
+
+	s := scheduler.NewScheduler()
+	for _, def := range maintenanceJobs() {
+		if err := s.Register(def); err != nil {
+			log.Error().Err(err).Msgf("failed to register scheduled job %s, it will not run", def.Name)
+		}
+	}
+
+	s.Run(ctx)
+}
@@ -0,0 +1,114 @@
+package backend
+
+import (
+	"context"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultOutboxRelayBatchSize = 100
+	defaultOutboxRelayInterval  = time.Second
+)
+
+// RunOutboxRelay publishes the checkbox update events written to the outbox until the context is cancelled.
+// When a full batch was relayed there is probably more waiting, so the next batch is relayed straight away.
+func RunOutboxRelay(ctx context.Context) {
+	batchSize := apiconfig.GetIntWithDefault("OUTBOX_RELAY_BATCHSIZE", defaultOutboxRelayBatchSize)
+	interval := apiconfig.GetDurationWithDefault("OUTBOX_RELAY_INTERVAL", defaultOutboxRelayInterval)
+
+	log.Info().Msgf("Outbox relay started, batchSize=%d, interval=%v", batchSize, interval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Context cancelled, outbox relay shutting down")
+			return
+		default:
+		}
+
+		result := RelayOutbox(ctx, batchSize)
+		if result.Result == workers.ResultEnum.Success && result.NumProcessed == batchSize {
+			continue
+		}
+
+		// Context-aware sleep
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Info().Msg("Context cancelled during sleep, outbox relay shutting down")
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// RelayOutbox publishes one batch of pending outbox events to the update topic, in order, and marks the published
+// ones sent. It stops at the first failure, so events for the same checkbox are never published out of order.
+// An event that was published but not marked sent is published again on the next run, and deduplicated by the queue
+// using the request UUID.
+func RelayOutbox(ctx context.Context, batchSize int) workers.QueueConsumerResult {
+	events, err := dbservice.GetPendingOutboxEvents(ctx, batchSize)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to get pending outbox events")
+		return workers.QueueConsumerResult{
+			Result:       workers.ResultEnum.Failure,
+			NumProcessed: 0,
+		}
+	}
+
+	result := workers.ResultEnum.Success
+	sentIds := make([]int64, 0, len(events))
+	for _, event := range events {
+		_, err := queueservice.PublishCheckboxUpdate(ctx, outboxEventToPayload(event))
+		if err != nil {
+			log.Error().Err(err).Msgf("failed to publish outbox event %d (%s) for checkbox %d and requestUuid %v",
+				event.OutboxId, event.EventType, event.CheckboxNbr, event.RequestUuid)
+			result = workers.ResultEnum.Failure
+			break
+		}
+		sentIds = append(sentIds, event.OutboxId)
+	}
+
+	err = dbservice.MarkOutboxEventsSent(ctx, sentIds)
+	if err != nil {
+		log.Error().Err(err).Msgf("failed to mark %d outbox events sent, they will be published again", len(sentIds))
+		return workers.QueueConsumerResult{
+			Result:       workers.ResultEnum.Failure,
+			NumProcessed: 0,
+		}
+	}
+
+	if len(events) > 0 {
+		log.Debug().Msgf("Outbox relay: published=%d, pending=%d", len(sentIds), len(events)-len(sentIds))
+	}
+
+	return workers.QueueConsumerResult{
+		Result:       result,
+		NumProcessed: len(sentIds),
+	}
+}
+
+func outboxEventToPayload(event dbservice.OutboxEvent) queueservice.CheckboxUpdatePayload {
+	result := queueservice.CheckboxUpdateResultFailed
+	if event.Success {
+		result = queueservice.CheckboxUpdateResultSuccess
+	}
+
+	return queueservice.CheckboxUpdatePayload{
+		EventType:   event.EventType,
+		CheckboxNbr: event.CheckboxNbr,
+		Checked:     event.Checked,
+		UserUuid:    event.UpdatedBy.String(),
+		RequestUuid: event.RequestUuid.String(),
+		Result:      result,
+		Reason:      event.Reason,
+		EventTime:   event.EventDate,
+	}
+}
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers"
+	"github.com/rs/zerolog/log"
+)
+
+// ApplyModePool is the BACKEND_APPLY_MODE value that runs the consumer on an autoscaling worker pool
+const ApplyModePool = "pool"
+
+const pullCheckboxActionErrorSleepDuration = time.Duration(5) * time.Second
+
+// RunCheckboxActionWorkerPool pulls checkbox action messages continuously and hands them to a pool of workers,
+// which the autoscaler resizes to the load. Once pullCtx is cancelled it stops pulling, and drains the pool: the
+// workers finish the messages dispatched to them on ctx, and anything not applied by the time ctx is cancelled is
+// released back to the queue.
+func RunCheckboxActionWorkerPool(pullCtx context.Context, ctx context.Context) {
+	cfg := LoadAutoscalerConfig()
+
+	drain := &poolDrain{}
+	pool := NewWorkerPool(cfg.MinWorkers, selectWorkerByGroup, drain.process, drain.release)
+	pool.start(ctx)
+
+	go NewAutoscaler(pool, cfg).run(pullCtx)
+
+	pullCheckboxActionsToPool(pullCtx, pool)
+
+	log.Info().Msg("Draining checkbox action worker pool")
+	drainStart := time.Now()
+	_, succeededBefore, _, _ := pool.stats.snapshot()
+	pool.shutdown()
+	_, succeededAfter, _, _ := pool.stats.snapshot()
+
+	DrainResult{
+		Completed:     int(succeededAfter - succeededBefore),
+		Released:      int(drain.released.Load()),
+		ReleaseFailed: int(drain.releaseFailed.Load()),
+	}.log(time.Since(drainStart))
+}
+
+// pullCheckboxActionsToPool keeps the pool fed with checkbox action messages until ctx is cancelled
+func pullCheckboxActionsToPool(ctx context.Context, pool *WorkerPool) {
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Context cancelled, checkbox action worker pool stopped pulling")
+			return
+		default:
+		}
+
+		messages, err := queueservice.PullCheckboxActionMessages(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			log.Error().Err(err).Msg("failed to pull messages from checkbox action queue")
+
+			// Context-aware sleep, as a simple backoff when the queue is unavailable
+			timer := time.NewTimer(pullCheckboxActionErrorSleepDuration)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				log.Info().Msg("Context cancelled during sleep, checkbox action worker pool stopped pulling")
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+
+		for _, message := range messages {
+			if err := pool.dispatchWait(ctx, message); err != nil {
+				log.Warn().Err(err).Msgf("failed to dispatch messageId %s, releasing it", message.MessageId)
+				releaseMessage(message)
+			}
+		}
+	}
+}
+
+// selectWorkerByGroup routes all the messages of a FIFO message group, ie a checkbox, to the same worker
+func selectWorkerByGroup(msg queueservice.Message, numWorkers int) int {
+	key := msg.GroupId
+	if key == "" {
+		key = msg.MessageId
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(numWorkers))
+}
+
+// poolDrain counts the messages the pool's workers release back to the queue during shutdown, whether they failed
+// because the drain deadline passed, or were still waiting on a worker at the deadline
+type poolDrain struct {
+	released      atomic.Int64
+	releaseFailed atomic.Int64
+}
+
+// process applies a message, and releases it back to the queue if it failed because the drain deadline passed, or
+// because it lost a conflict with another transaction and can be retried straight away
+func (d *poolDrain) process(ctx context.Context, msg queueservice.Message, resultCh chan<- WorkerResult) error {
+	err := processCheckboxActionWorkerMessage(ctx, msg, resultCh)
+	if err != nil && ctx.Err() != nil {
+		d.release(msg)
+	} else if apierror.IsErrorType(err, apierror.ErrDatabaseConflict) {
+		releaseMessage(msg)
+	}
+	return err
+}
+
+// release is the WorkerReleaseFunc for the messages a worker never got to before the drain deadline
+func (d *poolDrain) release(msg queueservice.Message) {
+	if releaseMessage(msg) {
+		d.released.Add(1)
+	} else {
+		d.releaseFailed.Add(1)
+	}
+}
+
+// processCheckboxActionWorkerMessage is the WorkerProcessFunc that applies a single checkbox action message
+func processCheckboxActionWorkerMessage(ctx context.Context, msg queueservice.Message, _ chan<- WorkerResult) error {
+	ctx, span := queueservice.StartProcessSpan(ctx, &msg, "checkbox_action")
+	defer span.End()
+
+	request, err := parseCheckboxActionMessage(ctx, msg)
+	if err != nil {
+		if outcome := dropInvalidMessage(ctx, msg, err); outcome.Result == workers.ResultEnum.Failure {
+			return outcome.Err
+		}
+		return nil
+	}
+
+	decision, err := dbservice.GetCheckboxRepository().UpdateCheckbox(ctx, conflictpolicy.GetPolicy(), request)
+	outcome := completeCheckboxAction(ctx, msg, request, decision, err)
+	if outcome.Result == workers.ResultEnum.Failure {
+		return outcome.Err
+	}
+	return nil
+}
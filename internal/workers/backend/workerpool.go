@@ -20,19 +20,28 @@ type WorkerResult struct {
 
 // Stats tracks processing statistics
 type Stats struct {
-	mu        sync.RWMutex
-	processed int64
-	succeeded int64
-	failed    int64
-	totalTime time.Duration
+	mu         sync.RWMutex
+	processed  int64
+	succeeded  int64
+	failed     int64
+	totalTime  time.Duration
+	recentTime time.Duration // exponentially weighted moving average, so it follows the current load
 }
 
+// weight of the latest result in the recent processing time average
+const statsRecentWeight = 0.2
+
 func (s *Stats) record(result WorkerResult) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.processed++
 	s.totalTime += result.Duration
+	if s.recentTime == 0 {
+		s.recentTime = result.Duration
+	} else {
+		s.recentTime = time.Duration((1-statsRecentWeight)*float64(s.recentTime) + statsRecentWeight*float64(result.Duration))
+	}
 
 	if result.Success {
 		s.succeeded++
@@ -56,22 +65,38 @@ func (s *Stats) snapshot() (processed, succeeded, failed int64, avgTime time.Dur
 	return
 }
 
+// recentAvgTime returns the average processing time, weighted towards the most recent results
+func (s *Stats) recentAvgTime() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.recentTime
+}
+
 type WorkerProcessFunc func(ctx context.Context, msg queueservice.Message, resultCh chan<- WorkerResult) error
 
+// WorkerReleaseFunc hands back a message that was dispatched to a worker, but that the worker never got to process
+// because its context was cancelled
+type WorkerReleaseFunc func(msg queueservice.Message)
+
 // Worker represents a single worker
 type Worker struct {
 	id        int
 	processor WorkerProcessFunc
+	release   WorkerReleaseFunc
 	msgChan   chan queueservice.Message
 	resultCh  chan<- WorkerResult
 	quit      chan struct{}
 	wg        *sync.WaitGroup
+	sendMu    sync.RWMutex // held for reading while a message is sent to msgChan, and for writing to set stopped
+	stopped   bool         // set once the worker takes no more messages
 }
 
-func NewWorker(id int, processor WorkerProcessFunc, resultCh chan<- WorkerResult, wg *sync.WaitGroup) *Worker {
+func NewWorker(id int, processor WorkerProcessFunc, release WorkerReleaseFunc, resultCh chan<- WorkerResult, wg *sync.WaitGroup) *Worker {
 	return &Worker{
 		id:        id,
 		processor: processor,
+		release:   release,
 		msgChan:   make(chan queueservice.Message, 1), // Buffered to prevent blocking
 		resultCh:  resultCh,
 		quit:      make(chan struct{}),
@@ -89,29 +114,31 @@ func (w *Worker) start(ctx context.Context) {
 			select {
 			case <-ctx.Done():
 				log.Printf("Worker %d shutting down due to context cancellation", w.id)
+				w.retire(w.releaseMessage)
 				return
 
 			case <-w.quit:
+				// finish anything already dispatched to this worker, since nothing else will pick it up, unless
+				// the context is cancelled part way through
+				w.retire(func(msg queueservice.Message) {
+					if ctx.Err() != nil {
+						w.releaseMessage(msg)
+					} else {
+						w.handleMessage(ctx, msg)
+					}
+				})
 				log.Printf("Worker %d shutting down", w.id)
 				return
 
 			case msg := <-w.msgChan:
-				start := time.Now()
-				err := w.processMessage(ctx, msg)
-
-				result := WorkerResult{
-					MessageID: msg.MessageId,
-					WorkerID:  w.id,
-					Success:   err == nil,
-					Error:     err,
-					Duration:  time.Since(start),
+				// select picks at random between ready cases, so this can still be picked after cancellation
+				if ctx.Err() != nil {
+					w.releaseMessage(msg)
+					w.retire(w.releaseMessage)
+					return
 				}
-
-				// Non-blocking send to prevent deadlock during shutdown
-				select {
-				case w.resultCh <- result:
-				case <-ctx.Done():
-					log.Printf("Worker %d: context cancelled while sending result", w.id)
+				if !w.handleMessage(ctx, msg) {
+					w.retire(w.releaseMessage)
 					return
 				}
 			}
@@ -119,6 +146,29 @@ func (w *Worker) start(ctx context.Context) {
 	}()
 }
 
+// handleMessage processes a message and reports the result, it returns false if the context was cancelled
+func (w *Worker) handleMessage(ctx context.Context, msg queueservice.Message) bool {
+	start := time.Now()
+	err := w.processMessage(ctx, msg)
+
+	result := WorkerResult{
+		MessageID: msg.MessageId,
+		WorkerID:  w.id,
+		Success:   err == nil,
+		Error:     err,
+		Duration:  time.Since(start),
+	}
+
+	// Non-blocking send to prevent deadlock during shutdown
+	select {
+	case w.resultCh <- result:
+		return true
+	case <-ctx.Done():
+		log.Printf("Worker %d: context cancelled while sending result", w.id)
+		return false
+	}
+}
+
 func (w *Worker) processMessage(ctx context.Context, msg queueservice.Message) error {
 	// Simulate processing with context awareness
 	log.Printf("Worker %d processing message %s", w.id, msg.MessageId)
@@ -126,31 +176,94 @@ func (w *Worker) processMessage(ctx context.Context, msg queueservice.Message) e
 	return w.processor(ctx, msg, w.resultCh)
 }
 
+// releaseMessage hands back a message the worker won't process, if the pool has somewhere to hand it back to
+func (w *Worker) releaseMessage(msg queueservice.Message) {
+	log.Printf("Worker %d releasing unprocessed message %s", w.id, msg.MessageId)
+	if w.release != nil {
+		w.release(msg)
+	}
+}
+
 func (w *Worker) stop() {
 	close(w.quit)
 }
 
-type WorkerPoolSelector func(msg queueservice.Message) int
+// send hands a message to the worker, waiting for room in its channel. It returns false, without an error, if the
+// worker was stopped before it took the message.
+func (w *Worker) send(ctx context.Context, msg queueservice.Message) (bool, error) {
+	w.sendMu.RLock()
+	defer w.sendMu.RUnlock()
+
+	if w.stopped {
+		return false, nil
+	}
+	select {
+	case w.msgChan <- msg:
+		return true, nil
+	case <-w.quit:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// retire passes every message left in the worker's channel to handle, including any a send was part way through
+// delivering, and marks the worker stopped so no more can arrive
+func (w *Worker) retire(handle func(msg queueservice.Message)) {
+	drain := func() {
+		for {
+			select {
+			case msg := <-w.msgChan:
+				handle(msg)
+			default:
+				return
+			}
+		}
+	}
+
+	// emptying the channel first lets a send blocked on a full channel finish, before waiting on it to let go
+	drain()
+	w.sendMu.Lock()
+	w.stopped = true
+	w.sendMu.Unlock()
+	drain()
+}
+
+// WorkerPoolSelector picks the index of the worker, out of numWorkers, that a message is routed to
+type WorkerPoolSelector func(msg queueservice.Message, numWorkers int) int
 
-// WorkerPool manages all workers
+// WorkerPool manages all workers. The number of workers can be changed at runtime with resize.
 type WorkerPool struct {
-	workers  []*Worker
-	resultCh chan WorkerResult
-	selector WorkerPoolSelector
-	stats    *Stats
-	wg       sync.WaitGroup
+	mu            sync.RWMutex // guards workers, nextID and closed
+	workers       []*Worker
+	nextID        int
+	closed        bool // set by shutdown, once every worker has been stopped
+	ctx           context.Context
+	processor     WorkerProcessFunc
+	release       WorkerReleaseFunc
+	resultCh      chan WorkerResult
+	selector      WorkerPoolSelector
+	stats         *Stats
+	workerWg      sync.WaitGroup     // running workers
+	wg            sync.WaitGroup     // result collector and stats reporter
+	cancelRunners context.CancelFunc // stops the collector and reporter once the workers have finished
 }
 
-func NewWorkerPool(numWorkers int, selector WorkerPoolSelector, processor WorkerProcessFunc) *WorkerPool {
+// NewWorkerPool creates a pool of numWorkers workers. release, which may be nil, gets the messages still waiting on a
+// worker when the pool's context is cancelled.
+func NewWorkerPool(numWorkers int, selector WorkerPoolSelector, processor WorkerProcessFunc, release WorkerReleaseFunc) *WorkerPool {
 	wp := &WorkerPool{
-		workers:  make([]*Worker, numWorkers),
-		resultCh: make(chan WorkerResult, numWorkers*2), // Buffered to prevent blocking
-		selector: selector,
-		stats:    &Stats{},
+		workers:   make([]*Worker, numWorkers),
+		nextID:    numWorkers,
+		processor: processor,
+		release:   release,
+		resultCh:  make(chan WorkerResult, numWorkers*2), // Buffered to prevent blocking
+		selector:  selector,
+		stats:     &Stats{},
 	}
 
 	for i := range numWorkers {
-		wp.workers[i] = NewWorker(i, processor, wp.resultCh, &wp.wg)
+		wp.workers[i] = NewWorker(i, processor, release, wp.resultCh, &wp.workerWg)
 	}
 
 	return wp
@@ -158,17 +271,24 @@ func NewWorkerPool(numWorkers int, selector WorkerPoolSelector, processor Worker
 
 func (wp *WorkerPool) start(ctx context.Context) {
 	// Start all workers
+	wp.mu.Lock()
+	wp.ctx = ctx
 	for _, w := range wp.workers {
 		w.start(ctx)
 	}
+	wp.mu.Unlock()
+
+	// The collector and reporter outlive the workers, so they see every result during shutdown
+	runnersCtx, cancelRunners := context.WithCancel(ctx)
+	wp.cancelRunners = cancelRunners
 
 	// Start result collector
 	wp.wg.Add(1)
-	go wp.collectResults(ctx)
+	go wp.collectResults(runnersCtx)
 
 	// Start stats reporter
 	wp.wg.Add(1)
-	go wp.reportStats(ctx)
+	go wp.reportStats(runnersCtx)
 }
 
 func (wp *WorkerPool) collectResults(ctx context.Context) {
@@ -231,9 +351,51 @@ func (wp *WorkerPool) printStats() {
 		processed, succeeded, failed, avgTime)
 }
 
+// size returns the current number of workers
+func (wp *WorkerPool) size() int {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+
+	return len(wp.workers)
+}
+
+// resize grows or shrinks the pool to numWorkers. Removed workers finish the messages already dispatched to them
+// before they exit. It must only be called after start.
+func (wp *WorkerPool) resize(numWorkers int) {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	current := len(wp.workers)
+	switch {
+	case numWorkers > current:
+		for range numWorkers - current {
+			w := NewWorker(wp.nextID, wp.processor, wp.release, wp.resultCh, &wp.workerWg)
+			wp.nextID++
+			wp.workers = append(wp.workers, w)
+			w.start(wp.ctx)
+		}
+	case numWorkers < current:
+		for _, w := range wp.workers[numWorkers:] {
+			w.stop()
+		}
+		wp.workers = wp.workers[:numWorkers]
+	default:
+		return
+	}
+
+	log.Printf("Worker pool resized from %d to %d workers", current, numWorkers)
+}
+
 func (wp *WorkerPool) dispatch(msg queueservice.Message) error {
+	wp.mu.RLock()
+	defer wp.mu.RUnlock()
+
 	// Route message to specific worker based on payload
-	workerIndex := wp.selector(msg)
+	workerIndex := wp.selector(msg, len(wp.workers))
 	worker := wp.workers[workerIndex]
 
 	// Non-blocking send to prevent deadlock
@@ -245,15 +407,44 @@ func (wp *WorkerPool) dispatch(msg queueservice.Message) error {
 	}
 }
 
+// dispatchWait routes a message to its worker like dispatch, but waits for the worker to have room rather than
+// failing, which applies backpressure to whoever is pulling messages. It waits without holding the pool's lock, so
+// a resize isn't held up behind a busy worker, and routes the message again if resize removes its worker meanwhile.
+func (wp *WorkerPool) dispatchWait(ctx context.Context, msg queueservice.Message) error {
+	for {
+		wp.mu.RLock()
+		if wp.closed {
+			wp.mu.RUnlock()
+			return fmt.Errorf("worker pool is shut down")
+		}
+		workerIndex := wp.selector(msg, len(wp.workers))
+		worker := wp.workers[workerIndex]
+		wp.mu.RUnlock()
+
+		sent, err := worker.send(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("context cancelled while dispatching to worker %d: %w", workerIndex, err)
+		}
+		if sent {
+			return nil
+		}
+	}
+}
+
 func (wp *WorkerPool) shutdown() {
 	log.Println("Shutting down worker pool...")
 
 	// Stop all workers
+	wp.mu.Lock()
 	for _, w := range wp.workers {
 		w.stop()
 	}
+	wp.closed = true
+	wp.mu.Unlock()
 
-	// Wait for all goroutines to finish
+	// Wait for the workers to finish what was dispatched to them, then for the collector and reporter
+	wp.workerWg.Wait()
+	wp.cancelRunners()
 	wp.wg.Wait()
 
 	// Close result channel after all workers are done
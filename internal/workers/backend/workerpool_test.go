@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatchWaitDuringResize(t *testing.T) {
+	release := make(chan struct{})
+	var processed atomic.Int64
+	processor := func(ctx context.Context, msg queueservice.Message, _ chan<- WorkerResult) error {
+		<-release
+		processed.Add(1)
+		return nil
+	}
+	// every message goes to the last worker, so shrinking the pool removes the one they are waiting on
+	lastWorker := func(msg queueservice.Message, numWorkers int) int { return numWorkers - 1 }
+
+	ctx := context.Background()
+	pool := NewWorkerPool(2, lastWorker, processor, nil)
+	pool.start(ctx)
+
+	// the worker holds the first message, its channel the second, and the third waits for room
+	require.NoError(t, pool.dispatchWait(ctx, queueservice.Message{MessageId: "1"}))
+	require.NoError(t, pool.dispatchWait(ctx, queueservice.Message{MessageId: "2"}))
+	dispatched := make(chan error, 1)
+	go func() { dispatched <- pool.dispatchWait(ctx, queueservice.Message{MessageId: "3"}) }()
+	time.Sleep(50 * time.Millisecond) // for it to be waiting before the resize
+
+	resized := make(chan struct{})
+	go func() {
+		pool.resize(1)
+		close(resized)
+	}()
+	select {
+	case <-resized:
+	case <-time.After(time.Second):
+		t.Fatal("resize waited on a dispatch to a busy worker")
+	}
+
+	// the removed worker won't take the third message, so it goes to the one that is left
+	select {
+	case err := <-dispatched:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("dispatch to a removed worker was not routed again")
+	}
+
+	close(release)
+	pool.shutdown()
+	assert.Equal(t, int64(3), processed.Load())
+	assert.Error(t, pool.dispatchWait(ctx, queueservice.Message{MessageId: "4"}), "a shut down pool takes no more messages")
+}
+
+func TestWorkerReleasesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	processor := func(ctx context.Context, msg queueservice.Message, _ chan<- WorkerResult) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	var mu sync.Mutex
+	var released []string
+	release := func(msg queueservice.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		released = append(released, msg.MessageId)
+	}
+
+	pool := NewWorkerPool(1, func(queueservice.Message, int) int { return 0 }, processor, release)
+	pool.start(ctx)
+
+	// the worker is busy with the first message when the context is cancelled, and never starts the second
+	require.NoError(t, pool.dispatch(queueservice.Message{MessageId: "1"}))
+	<-started
+	require.NoError(t, pool.dispatch(queueservice.Message{MessageId: "2"}))
+	cancel()
+	pool.shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"2"}, released)
+}
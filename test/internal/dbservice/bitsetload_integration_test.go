@@ -0,0 +1,34 @@
+//go:build integration
+
+package dbservice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+)
+
+// BenchmarkLoadBoard compares loading the board packed into a bit string per partition with loading it a row per
+// checkbox, run it with -bench LoadBoard -tags integration against a database with the full board
+func BenchmarkLoadBoard(b *testing.B) {
+	ctx := context.Background()
+	repository := dbservice.GetCheckboxRepository()
+
+	b.Run("bitset", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, apierr := repository.LoadCheckboxBitset(ctx, nil); apierr != nil {
+				b.Fatal(apierr)
+			}
+		}
+	})
+	b.Run("rows", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			if _, apierr := repository.GetFullCheckboxStore(ctx); apierr != nil {
+				b.Fatal(apierr)
+			}
+		}
+	})
+}
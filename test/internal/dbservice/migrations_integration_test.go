@@ -0,0 +1,54 @@
+//go:build integration
+
+package dbservice
+
+import (
+	"context"
+	"testing"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// baselineSchemaVersion is the version setup_database.sh leaves a database at
+const baselineSchemaVersion = 900
+
+// TestMigrateUpFromBaseline takes the database back to the version setup_database.sh leaves it at, and migrates it
+// up again, which needs every migration added since to be numbered above the baseline. It reverts the migrations
+// after the baseline, so run it against a database set up for testing.
+func TestMigrateUpFromBaseline(t *testing.T) {
+	require.NoError(t, apiconfig.InitConfigWithFolder("../../../config/", ""))
+	ctx := context.Background()
+
+	migrator, err := dbservice.NewMigrator(ctx)
+	require.NoError(t, err)
+	defer migrator.Close(ctx)
+
+	require.NoError(t, migrator.Goto(ctx, baselineSchemaVersion))
+	status, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, status.Current)
+	assert.Equal(t, int64(baselineSchemaVersion), status.Current.Version)
+	require.NotEmpty(t, status.Pending)
+
+	require.NoError(t, migrator.Up(ctx))
+	status, err = migrator.Status(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, status.Supported, status.Current.Version)
+	assert.False(t, status.Current.Dirty)
+	assert.Empty(t, status.Pending)
+
+	// the servers' user can read the tables the later migrations created, and the schema is one they accept
+	require.NoError(t, dbservice.InitializePool(ctx))
+	defer dbservice.ClosePool()
+	for _, table := range []string{"MCB.OUTBOX_T", "MCB.PROCESSED_REQUEST_T", "MCB.JOB_LEASE_T", "MCB.JOB_RUN_T",
+		"MCB.METRICS_T", "MCB.CHECKBOX_SNAPSHOT_T", "MCB.SCHEMA_COMPATIBILITY_T"} {
+		rows, err := dbservice.Query(ctx, "SELECT COUNT(*) FROM "+table)
+		require.NoError(t, err, table)
+		rows.Close()
+		assert.NoError(t, rows.Err(), table)
+	}
+	assert.Nil(t, dbservice.CheckSchemaVersion(ctx))
+}
@@ -0,0 +1,204 @@
+//go:build integration
+
+package dbservice
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	apierror "github.com/andrewhollamon/millioncheckboxes-api/internal/error"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain connects to the database in config/, which must be migrated to the current version
+func TestMain(m *testing.M) {
+	if err := apiconfig.InitConfigWithFolder("../../../config/", ""); err != nil {
+		panic(err)
+	}
+	apiconfig.GetConfig().Set("DB_TX_MAX_RETRIES", 3)
+	apiconfig.GetConfig().Set("DB_TX_RETRY_BACKOFF", "20ms")
+	apiconfig.GetConfig().Set("DB_LOG_REDACT", "uuid")
+	if err := dbservice.InitializePool(context.Background()); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	dbservice.ClosePool()
+	os.Exit(code)
+}
+
+// The transactions write to JOB_RUN_T, which the servers' user may delete from, under a job name of their own
+
+func insertJobRun(ctx context.Context, tx pgx.Tx, jobName string) error {
+	_, err := dbservice.ExecTx(ctx, tx, "INSERT INTO MCB.JOB_RUN_T ( JOB_NAME, OWNER, SCHEDULED_TIME, STATUS ) "+
+		"VALUES ( $1, 'tx_integration_test', NOW(), 'running' )", jobName)
+	return err
+}
+
+func countJobRuns(t *testing.T, ctx context.Context, jobName string) int {
+	t.Helper()
+
+	rows, err := dbservice.Query(ctx, "SELECT COUNT(*) FROM MCB.JOB_RUN_T WHERE JOB_NAME = $1", jobName)
+	require.NoError(t, err)
+	count, err := pgx.CollectExactlyOneRow(rows, pgx.RowTo[int])
+	require.NoError(t, err)
+	return count
+}
+
+func newJobName(t *testing.T) string {
+	t.Helper()
+
+	jobName := "tx_test_" + uuid.NewString()[:8]
+	t.Cleanup(func() {
+		_, err := dbservice.Exec(context.Background(), "DELETE FROM MCB.JOB_RUN_T WHERE JOB_NAME = $1", jobName)
+		assert.NoError(t, err)
+	})
+	return jobName
+}
+
+func TestWithTxCommits(t *testing.T) {
+	ctx := context.Background()
+	jobName := newJobName(t)
+
+	apierr := dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+		return insertJobRun(ctx, tx, jobName)
+	})
+	require.Nil(t, apierr)
+	assert.Equal(t, 1, countJobRuns(t, ctx, jobName))
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	ctx := context.Background()
+	jobName := newJobName(t)
+
+	apierr := dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+		if err := insertJobRun(ctx, tx, jobName); err != nil {
+			return err
+		}
+		return errors.New("something went wrong after the insert")
+	})
+	require.NotNil(t, apierr)
+	assert.Contains(t, apierr.Error(), "something went wrong after the insert")
+	assert.Equal(t, 0, countJobRuns(t, ctx, jobName))
+
+	// an APIError from fn comes back as it is
+	apierr = dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+		if err := insertJobRun(ctx, tx, jobName); err != nil {
+			return err
+		}
+		return apierror.NewAPIErrorFromCode(apierror.ErrRecordNotFound, "checkbox not found")
+	})
+	assert.True(t, apierror.IsErrorType(apierr, apierror.ErrRecordNotFound))
+	assert.Equal(t, 0, countJobRuns(t, ctx, jobName))
+}
+
+func TestWithTxRollsBackAndRepanics(t *testing.T) {
+	ctx := context.Background()
+	jobName := newJobName(t)
+
+	assert.PanicsWithValue(t, "panic after the insert", func() {
+		_ = dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+			if err := insertJobRun(ctx, tx, jobName); err != nil {
+				return err
+			}
+			panic("panic after the insert")
+		})
+	})
+	assert.Equal(t, 0, countJobRuns(t, ctx, jobName))
+}
+
+func TestWithTxRetriesConflicts(t *testing.T) {
+	for _, sqlState := range []string{"40001", "40P01"} {
+		t.Run(sqlState, func(t *testing.T) {
+			ctx := context.Background()
+			jobName := newJobName(t)
+
+			// the first two attempts fail on the server, as a serialization failure or deadlock would, after inserting
+			attempts := 0
+			start := time.Now()
+			apierr := dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+				attempts++
+				if err := insertJobRun(ctx, tx, jobName); err != nil {
+					return err
+				}
+				if attempts <= 2 {
+					_, err := dbservice.ExecTx(ctx, tx, "DO $$ BEGIN RAISE EXCEPTION 'conflict' USING ERRCODE = '"+sqlState+"'; END $$")
+					return err
+				}
+				return nil
+			})
+			require.Nil(t, apierr)
+			assert.Equal(t, 3, attempts)
+			// only the attempt that committed left its row
+			assert.Equal(t, 1, countJobRuns(t, ctx, jobName))
+			// waiting 20ms before the first retry and 40ms before the second, each with up to 20ms of jitter
+			assert.GreaterOrEqual(t, time.Since(start), 60*time.Millisecond)
+		})
+	}
+}
+
+func TestWithTxGivesUpAfterMaxRetries(t *testing.T) {
+	ctx := context.Background()
+
+	attempts := 0
+	apierr := dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+		attempts++
+		_, err := dbservice.ExecTx(ctx, tx, "DO $$ BEGIN RAISE EXCEPTION 'conflict' USING ERRCODE = '40001'; END $$")
+		return err
+	})
+	assert.True(t, apierror.IsErrorType(apierr, apierror.ErrDatabaseConflict))
+	assert.Equal(t, 4, attempts, "the first attempt and DB_TX_MAX_RETRIES retries")
+
+	// other errors aren't retried
+	attempts = 0
+	apierr = dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+		attempts++
+		_, err := dbservice.ExecTx(ctx, tx, "SELECT 1/0")
+		return err
+	})
+	assert.NotNil(t, apierr)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestWithTxOptions(t *testing.T) {
+	ctx := context.Background()
+
+	show := func(tx pgx.Tx, setting string) string {
+		rows, err := dbservice.QueryTx(ctx, tx, "SHOW "+setting)
+		require.NoError(t, err)
+		value, err := pgx.CollectExactlyOneRow(rows, pgx.RowTo[string])
+		require.NoError(t, err)
+		return value
+	}
+
+	apierr := dbservice.WithTx(ctx, dbservice.TxOptions{}, func(tx pgx.Tx) error {
+		assert.Equal(t, "off", show(tx, "transaction_read_only"))
+		assert.Equal(t, "read committed", show(tx, "transaction_isolation"))
+		return nil
+	})
+	require.Nil(t, apierr)
+
+	apierr = dbservice.WithTx(ctx, dbservice.TxOptions{IsoLevel: pgx.Serializable, ReadOnly: true}, func(tx pgx.Tx) error {
+		assert.Equal(t, "on", show(tx, "transaction_read_only"))
+		assert.Equal(t, "serializable", show(tx, "transaction_isolation"))
+		return nil
+	})
+	require.Nil(t, apierr)
+
+	// a read only transaction can't write
+	jobName := newJobName(t)
+	apierr = dbservice.WithTx(ctx, dbservice.TxOptions{ReadOnly: true}, func(tx pgx.Tx) error {
+		return insertJobRun(ctx, tx, jobName)
+	})
+	require.NotNil(t, apierr)
+	assert.Contains(t, apierr.Error(), "25006") // read_only_sql_transaction
+	assert.Equal(t, 0, countJobRuns(t, ctx, jobName))
+}
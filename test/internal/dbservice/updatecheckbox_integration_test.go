@@ -0,0 +1,46 @@
+//go:build integration
+
+package dbservice
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/conflictpolicy"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUpdateCheckboxRedactsUserInLogs toggles the last checkbox twice, leaving it as it was, with TestMain's
+// DB_LOG_REDACT=uuid, and checks that nothing logged on the way shows the user
+func TestUpdateCheckboxRedactsUserInLogs(t *testing.T) {
+	var logged bytes.Buffer
+	ctx := zerolog.New(&logged).Level(zerolog.DebugLevel).WithContext(context.Background())
+	previousLevel := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(previousLevel) })
+	policy, err := conflictpolicy.NewPolicy(conflictpolicy.PolicyToggle)
+	require.NoError(t, err)
+	user := uuid.New()
+
+	for range 2 {
+		request := conflictpolicy.CheckboxRequest{
+			CheckboxNbr: 999999,
+			Checked:     true,
+			UserUuid:    user,
+			RequestUuid: uuid.New(),
+			RequestTime: time.Now(),
+		}
+		decision, apierr := dbservice.GetCheckboxRepository().UpdateCheckbox(ctx, policy, request)
+		require.Nil(t, apierr)
+		require.True(t, decision.Apply)
+	}
+
+	assert.Contains(t, logged.String(), "completed successfully under policy")
+	assert.NotContains(t, logged.String(), user.String())
+}
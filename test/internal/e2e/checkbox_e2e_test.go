@@ -0,0 +1,224 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/api"
+	apiconfig "github.com/andrewhollamon/millioncheckboxes-api/internal/config"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/dbservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/memorystore"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/queueservice"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers/apiserver"
+	"github.com/andrewhollamon/millioncheckboxes-api/internal/workers/backend"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	TestCheckboxNbr = 42
+	TestUserUuid1   = "550e8400-e29b-41d4-a716-446655440000"
+	TestUserUuid2   = "550e8400-e29b-41d4-a716-446655440002"
+)
+
+// TestMain runs from the repository root, where the router finds its templates and the config
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../../.."); err != nil {
+		panic(err)
+	}
+	if err := apiconfig.InitConfigWithFolder("config/", ""); err != nil {
+		panic(err)
+	}
+	apiconfig.GetConfig().Set("CONFLICT_POLICY", "first_writer_wins")
+	apiconfig.GetConfig().Set("MEMORY_STORE_USE_SNAPSHOTS", false)
+	gin.SetMode(gin.TestMode)
+	memorystore.Init()
+
+	os.Exit(m.Run())
+}
+
+// TestCheckboxRequestEndToEnd takes check requests from the API through the queue and the backend, with the
+// in-memory queue standing in for AWS, and the in-memory repository or a SQLite file for Postgres. SQLite also has
+// the outbox, so its update events are taken on through the relay and the update queue to the memory store.
+func TestCheckboxRequestEndToEnd(t *testing.T) {
+	for _, provider := range []string{"memory", dbservice.ProviderSQLite} {
+		for _, applyMode := range []string{"single", backend.ApplyModeBatch} {
+			t.Run(provider+"/"+applyMode, func(t *testing.T) {
+				testCheckboxRequestEndToEnd(t, provider, applyMode)
+			})
+		}
+	}
+}
+
+func testCheckboxRequestEndToEnd(t *testing.T, provider string, applyMode string) {
+	ctx := context.Background()
+	apiconfig.GetConfig().Set("BACKEND_APPLY_MODE", applyMode)
+	apiconfig.GetConfig().Set("DATABASE_PROVIDER", provider)
+	t.Cleanup(func() { apiconfig.GetConfig().Set("DATABASE_PROVIDER", dbservice.ProviderPostgres) })
+	var repository dbservice.CheckboxRepository = dbservice.NewMemoryCheckboxRepository(1000)
+	if provider == dbservice.ProviderSQLite {
+		sqliteRepository, err := dbservice.NewSQLiteCheckboxRepository(ctx, filepath.Join(t.TempDir(), "mcb.db"), 1000, time.Second)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = sqliteRepository.Close() })
+		repository = sqliteRepository
+	}
+	dbservice.SetCheckboxRepository(repository)
+	queue := queueservice.NewMemoryQueueProvider()
+	queueservice.SetQueueProvider(queue)
+	router := api.SetupRouter()
+	require.Nil(t, memorystore.LoadCheckboxesFromStore(ctx))
+
+	// two users check the same checkbox before the backend gets to either request
+	first := doRequest(t, router, http.MethodPost, fmt.Sprintf("/api/v1/checkbox/%d/check/%s", TestCheckboxNbr, TestUserUuid1))
+	require.Equal(t, http.StatusOK, first.Code)
+	second := doRequest(t, router, http.MethodPost, fmt.Sprintf("/api/v1/checkbox/%d/check/%s", TestCheckboxNbr, TestUserUuid2))
+	require.Equal(t, http.StatusOK, second.Code)
+
+	depth, apierr := queue.GetCheckboxActionQueueDepth(ctx)
+	require.Nil(t, apierr)
+	assert.Equal(t, int64(2), depth)
+
+	// nothing has been applied yet
+	assert.False(t, getStatus(t, router).Checked)
+
+	result := backend.ConsumeCheckboxActionQueue(ctx, ctx)
+	assert.Equal(t, workers.ResultEnum.Success, result.Result)
+	assert.Equal(t, 2, result.NumProcessed)
+
+	// both messages are done with, and whichever was applied first won
+	depth, apierr = queue.GetCheckboxActionQueueDepth(ctx)
+	require.Nil(t, apierr)
+	assert.Equal(t, int64(0), depth)
+	assert.True(t, getStatus(t, router).Checked)
+
+	// single mode applies the messages concurrently, so either request may be the one applied first
+	history := getHistory(t, router)
+	require.Len(t, history.Updates, 2)
+	assert.False(t, history.Updates[0].Success)
+	assert.True(t, history.Updates[1].Success)
+	assert.ElementsMatch(t,
+		[]uuid.UUID{requestUuid(t, first), requestUuid(t, second)},
+		[]uuid.UUID{history.Updates[0].RequestUuid, history.Updates[1].RequestUuid})
+	if applyMode == backend.ApplyModeBatch {
+		assert.Equal(t, requestUuid(t, first), history.Updates[1].RequestUuid)
+		assert.Equal(t, uuid.MustParse(TestUserUuid1), history.Updates[1].UpdatedBy)
+	}
+
+	if provider == dbservice.ProviderSQLite {
+		// the memory store hears of the change once the relay publishes the events, the change and both results
+		checked, err := memorystore.GetCheckboxStatus(TestCheckboxNbr)
+		require.NoError(t, err)
+		assert.False(t, checked)
+		relayed := backend.RelayOutbox(ctx, 100)
+		assert.Equal(t, workers.ResultEnum.Success, relayed.Result)
+		assert.Equal(t, 3, relayed.NumProcessed)
+		consumed := apiserver.ConsumeCheckboxUpdateQueue(ctx)
+		assert.Equal(t, workers.ResultEnum.Success, consumed.Result)
+		assert.Equal(t, 3, consumed.NumProcessed)
+		checked, err = memorystore.GetCheckboxStatus(TestCheckboxNbr)
+		require.NoError(t, err)
+		assert.True(t, checked)
+	}
+
+	// the policy rejects an uncheck at the API, so it never reaches the queue
+	uncheck := doRequest(t, router, http.MethodPost, fmt.Sprintf("/api/v1/checkbox/%d/uncheck/%s", TestCheckboxNbr, TestUserUuid2))
+	assert.Equal(t, http.StatusForbidden, uncheck.Code)
+	depth, apierr = queue.GetCheckboxActionQueueDepth(ctx)
+	require.Nil(t, apierr)
+	assert.Equal(t, int64(0), depth)
+
+	// a request for a checkbox the repository doesn't have can never be applied, so it is deleted rather than redelivered
+	_, apierr = queueservice.PublishCheckboxAction(ctx, queueservice.CheckboxActionPayload{
+		Action:      queueservice.CheckboxActionChecked,
+		CheckboxNbr: 5000,
+		UserUuid:    TestUserUuid1,
+		RequestUuid: uuid.NewString(),
+		RequestTime: time.Now(),
+	})
+	require.Nil(t, apierr)
+	result = backend.ConsumeCheckboxActionQueue(ctx, ctx)
+	assert.Equal(t, workers.ResultEnum.Success, result.Result)
+	assert.Equal(t, 1, result.NumProcessed)
+	if provider == dbservice.ProviderSQLite {
+		// and raises no events
+		relayed := backend.RelayOutbox(ctx, 100)
+		assert.Equal(t, workers.ResultEnum.Success, relayed.Result)
+		assert.Equal(t, 0, relayed.NumProcessed)
+	}
+
+	// nor can a request with an invalid user, which would otherwise hold up the checkbox's message group for good
+	_, apierr = queueservice.PublishCheckboxAction(ctx, queueservice.CheckboxActionPayload{
+		Action:      queueservice.CheckboxActionChecked,
+		CheckboxNbr: TestCheckboxNbr,
+		UserUuid:    "not-a-user",
+		RequestUuid: uuid.NewString(),
+		RequestTime: time.Now(),
+	})
+	require.Nil(t, apierr)
+	result = backend.ConsumeCheckboxActionQueue(ctx, ctx)
+	assert.Equal(t, workers.ResultEnum.Success, result.Result)
+	assert.Equal(t, 1, result.NumProcessed)
+	assert.Len(t, getHistory(t, router).Updates, 2)
+
+	// the memory store loads from the repository
+	store, apierr := repository.GetFullCheckboxStore(ctx)
+	require.Nil(t, apierr)
+	assert.True(t, (*store)[TestCheckboxNbr])
+	assert.False(t, (*store)[TestCheckboxNbr+1])
+}
+
+func doRequest(t *testing.T, router *gin.Engine, method string, path string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w
+}
+
+type statusResponse struct {
+	Checked bool `json:"checked"`
+}
+
+func getStatus(t *testing.T, router *gin.Engine) statusResponse {
+	t.Helper()
+
+	w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/api/v1/checkbox/%d/status", TestCheckboxNbr))
+	require.Equal(t, http.StatusOK, w.Code)
+	status := statusResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	return status
+}
+
+type historyResponse struct {
+	Updates []dbservice.CheckboxUpdate `json:"updates"`
+}
+
+func getHistory(t *testing.T, router *gin.Engine) historyResponse {
+	t.Helper()
+
+	w := doRequest(t, router, http.MethodGet, fmt.Sprintf("/api/v1/checkbox/%d/history", TestCheckboxNbr))
+	require.Equal(t, http.StatusOK, w.Code)
+	history := historyResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &history))
+	return history
+}
+
+func requestUuid(t *testing.T, w *httptest.ResponseRecorder) uuid.UUID {
+	t.Helper()
+
+	response := struct {
+		RequestUuid uuid.UUID `json:"request_uuid"`
+	}{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response.RequestUuid
+}